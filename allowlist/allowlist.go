@@ -0,0 +1,128 @@
+// Package allowlist restricts destinations to which jobs are
+// dispatched.
+package allowlist
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/model"
+)
+
+// Allowlist checks destinations of jobs.  A nil Allowlist allows
+// anything.
+type Allowlist struct {
+	schemes  map[string]bool
+	hosts    []string
+	networks []*net.IPNet
+}
+
+// New creates an Allowlist from its description, or returns nil if it
+// allows anything.
+func New(spec *model.Allowlist) (*Allowlist, error) {
+	if spec.IsEmpty() {
+		return nil, nil
+	}
+
+	a := &Allowlist{}
+	if len(spec.Schemes) > 0 {
+		a.schemes = make(map[string]bool, len(spec.Schemes))
+		for _, s := range spec.Schemes {
+			a.schemes[strings.ToLower(s)] = true
+		}
+	}
+	for _, h := range spec.Hosts {
+		h = strings.ToLower(h)
+		if h == "" || strings.Contains(strings.TrimPrefix(h, "*."), "*") {
+			return nil, fmt.Errorf("Invalid host pattern: %q", h)
+		}
+		a.hosts = append(a.hosts, h)
+	}
+	for _, n := range spec.Networks {
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, err
+		}
+		a.networks = append(a.networks, network)
+	}
+	return a, nil
+}
+
+// FromConfig creates the global Allowlist from "dispatch_allowed_"
+// configuration values.
+func FromConfig() (*Allowlist, error) {
+	return New(&model.Allowlist{
+		Schemes:  splitList(config.Get("dispatch_allowed_schemes")),
+		Hosts:    splitList(config.Get("dispatch_allowed_hosts")),
+		Networks: splitList(config.Get("dispatch_allowed_networks")),
+	})
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// CheckURL returns an error if a URL is not allowed.  The host of the
+// URL is checked against the networks only if it is an IP address;
+// otherwise it should be checked on connecting by CheckIP.
+func (a *Allowlist) CheckURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return nil
+	}
+
+	if a.schemes != nil && !a.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("Scheme is not allowed: %s", rawurl)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if len(a.hosts) > 0 && !a.matchHost(host) {
+		return fmt.Errorf("Host is not allowed: %s", rawurl)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return a.CheckIP(ip)
+	}
+	return nil
+}
+
+func (a *Allowlist) matchHost(host string) bool {
+	for _, pattern := range a.hosts {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictsIPs tells if the allowlist allows only some IP addresses.
+func (a *Allowlist) RestrictsIPs() bool {
+	return a != nil && len(a.networks) > 0
+}
+
+// CheckIP returns an error if an IP address is not allowed.
+func (a *Allowlist) CheckIP(ip net.IP) error {
+	if a == nil || len(a.networks) == 0 {
+		return nil
+	}
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Address is not allowed: %s", ip)
+}
@@ -0,0 +1,70 @@
+package allowlist
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/model"
+)
+
+func TestCheckURL(t *testing.T) {
+	a, err := New(&model.Allowlist{
+		Schemes:  []string{"https"},
+		Hosts:    []string{"example.com", "*.example.net", "10.0.0.1", "192.168.0.1"},
+		Networks: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/job", true},
+		{"https://EXAMPLE.com./job", true},
+		{"http://example.com/job", false},
+		{"https://www.example.com/job", false},
+		{"https://api.example.net:8443/job", true},
+		{"https://example.net/job", false},
+		{"https://10.0.0.1/job", true},
+		{"https://192.168.0.1/job", false},
+		{"https://169.254.169.254/latest/meta-data/", false},
+	} {
+		if err := a.CheckURL(c.url); (err == nil) != c.allowed {
+			t.Errorf("%s: allowed should be %v: %v", c.url, c.allowed, err)
+		}
+	}
+
+	if err := a.CheckIP(net.ParseIP("127.0.0.1")); err == nil {
+		t.Error("An address out of the networks should not be allowed")
+	}
+
+	var nothing *Allowlist
+	if err := nothing.CheckURL("ftp://127.0.0.1/"); err != nil {
+		t.Errorf("A nil allowlist should allow anything: %s", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if a, err := New(&model.Allowlist{}); a != nil || err != nil {
+		t.Errorf("An empty allowlist should be nil: %v, %v", a, err)
+	}
+	if _, err := New(&model.Allowlist{Hosts: []string{"api.*.example.com"}}); err == nil {
+		t.Error("A wildcard should be only at the beginning")
+	}
+	if _, err := New(&model.Allowlist{Networks: []string{"10.0.0.1"}}); err == nil {
+		t.Error("A network should be in CIDR notation")
+	}
+
+	config.Locally("dispatch_allowed_hosts", " example.com, ,*.example.net ", func() {
+		a, err := FromConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(a.hosts) != 2 || len(a.schemes) != 2 {
+			t.Errorf("Wrong allowlist from configuration: %+v", a)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Setting describes an effective configuration value.
+type Setting struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+// Redacted returns the value unless it is a secret.
+func (s *Setting) Redacted() string {
+	if s.Secret && s.Value != "" {
+		return "********"
+	}
+	return s.Value
+}
+
+// Check returns the effective configuration values sorted by their
+// names, and errors of the values which are invalid for their types or
+// whose files cannot be read.  Default values are always valid.
+func Check() ([]Setting, []error) {
+	keys := Keys()
+	sort.Strings(keys)
+
+	settings := make([]Setting, 0, len(keys))
+	var errs []error
+	for _, k := range keys {
+		cached.RLock()
+		item := defaultConf[k]
+		_, isSet := cached.c[k]
+		cached.RUnlock()
+
+		if !isSet {
+			if _, err := resolve(k); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", k, err))
+			}
+		}
+		v := Get(k)
+		if err := validate(item.label, v); err != nil && v != item.defaultValue {
+			errs = append(errs, fmt.Errorf("%s: %s", k, err))
+		}
+		settings = append(settings, Setting{Name: k, Value: v, Secret: item.secret})
+	}
+
+	return settings, errs
+}
+
+// validate tells if a value is valid for the type described by the
+// label of a configuration key.
+func validate(label string, v string) error {
+	switch label {
+	case "<number>", "<seconds>", "<milliseconds>":
+		if _, err := strconv.ParseUint(v, 10, 32); err != nil {
+			return fmt.Errorf("not a non-negative integer: %q", v)
+		}
+	case "true|false":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("not a boolean: %q", v)
+		}
+	case "<level>":
+		switch strings.ToLower(v) {
+		case "", "0", "1", "2", "3", "4", "debug", "info", "warn", "error", "fatal":
+		default:
+			return fmt.Errorf("unknown level: %q", v)
+		}
+	case "<driver>":
+		if v != "mysql" && v != "in-memory" {
+			return fmt.Errorf("unknown driver: %q", v)
+		}
+	case "<CIDRs>":
+		for _, n := range strings.Split(v, ",") {
+			if n = strings.TrimSpace(n); n == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(n); err != nil {
+				return err
+			}
+		}
+	case "<address>:<port>":
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	Locally("api_tokens", "test:admin:token", func() {
+		Locally("shutdown_timeout", "soon", func() {
+			settings, errs := Check()
+			if len(errs) != 1 {
+				t.Errorf("Only an invalid value should be reported: %v", errs)
+			}
+			for _, s := range settings {
+				switch s.Name {
+				case "api_tokens":
+					if s.Value != "test:admin:token" || s.Redacted() == s.Value {
+						t.Errorf("A secret should be redacted: %v", s)
+					}
+				case "bind":
+					if s.Redacted() != s.Value {
+						t.Errorf("A value should not be redacted: %v", s)
+					}
+				}
+			}
+		})
+	})
+}
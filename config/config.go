@@ -8,37 +8,69 @@ import (
 
 type config struct {
 	sync.RWMutex
-	c map[string]string
+	c        map[string]string // values set explicitly
+	resolved map[string]string // values resolved from fallbacks
+	file     map[string]string // values loaded from a file
 }
 
 var cached = config{
-	c: make(map[string]string),
+	c:        make(map[string]string),
+	resolved: make(map[string]string),
+	file:     make(map[string]string),
 }
 
 // Get returns the current configuration value of a key.
 //
 // If it has no specific value, it falls back to a value of
-// environment variable starting with "MIDDLEMAN_" and then a default
-// value which is returned from GetDefault.
+// environment variable starting with "MIDDLEMAN_", a value in a
+// configuration file loaded by LoadFile, and then a default value
+// which is returned from GetDefault.
+//
+// If neither of the environment variable nor the file has the key but
+// either has the key suffixed by "_file", the value is read from the
+// file named by it.  It falls back to the default value if the file
+// cannot be read.
 func Get(key string) string {
 	cached.RLock()
 	v, ok := cached.c[key]
+	if !ok {
+		v, ok = cached.resolved[key]
+	}
 	cached.RUnlock()
 	if ok {
 		return v
 	}
 
-	envKey := "MIDDLEMAN_" + strings.ToUpper(key)
-	v = os.Getenv(envKey)
-	if v == "" {
+	v, err := resolve(key)
+	if err != nil {
 		v = GetDefault(key)
 	}
 	cached.Lock()
-	cached.c[key] = v
+	cached.resolved[key] = v
 	cached.Unlock()
 	return v
 }
 
+func resolve(key string) (string, error) {
+	if v, ok := lookup(key); ok {
+		return v, nil
+	}
+	if name, ok := lookup(key + secretFileSuffix); ok {
+		return readSecretFile(name)
+	}
+	return GetDefault(key), nil
+}
+
+func lookup(key string) (string, bool) {
+	if v := os.Getenv("MIDDLEMAN_" + strings.ToUpper(key)); v != "" {
+		return v, true
+	}
+	cached.RLock()
+	defer cached.RUnlock()
+	v, ok := cached.file[key]
+	return v, ok
+}
+
 // GetDefault returns the default configuration value of a key.
 func GetDefault(key string) string {
 	cached.RLock()
@@ -61,6 +93,7 @@ func Set(k, v string) {
 func SetDefault(k, v string) {
 	cached.Lock()
 	defer cached.Unlock()
+	delete(cached.resolved, k)
 	item, ok := defaultConf[k]
 	if ok {
 		item.defaultValue = v
@@ -79,6 +112,15 @@ func Locally(k, v string, block func()) {
 	block()
 }
 
+// IsSecret tells if the value of a configuration key is redacted when
+// shown.
+func IsSecret(k string) bool {
+	cached.RLock()
+	defer cached.RUnlock()
+	item, ok := defaultConf[k]
+	return ok && item.secret
+}
+
 // Keys returns a list of configuration keys.
 func Keys() []string {
 	cached.RLock()
@@ -88,3 +88,12 @@ func TestKeys(t *testing.T) {
 		t.Error("There must be some configuration keys")
 	}
 }
+
+func TestIsSecret(t *testing.T) {
+	if !IsSecret("repository_mysql_dsn") {
+		t.Error("repository_mysql_dsn should be a secret")
+	}
+	if IsSecret("bind") || IsSecret("test_no_such_key") {
+		t.Error("Only secret keys should be secrets")
+	}
+}
@@ -4,6 +4,8 @@ type configItem struct {
 	label        string
 	defaultValue string
 	description  string
+	secret       bool // redacted when shown
+	reloadable   bool // can be changed without restarting the daemon
 }
 
 var logLevelDescription = `The level is either a name or a numeric value.  The following table describes the meaning of the value.
@@ -23,6 +25,30 @@ var defaultConf = map[string]*configItem{
 		label:        "<address>:<port>",
 		description: `
 Specifies the address and the port number of a daemon in a form <code><var>address</var>:<var>port</var></code>.
+`,
+	},
+	"api_tokens": {
+		defaultValue: "",
+		label:        "<name>:<role>:<token>,...",
+		description: `
+Specifies API tokens in a comma separated list of <code><var>name</var>:<var>role</var>:<var>token</var></code>.  If this value is specified, a client must send a token by <code>Authorization: Bearer <var>token</var></code> or <code>X-API-Key: <var>token</var></code> header and the <var>name</var> of the token is recorded as ` + "`" + `identity` + "`" + ` field in the access log.  Otherwise, no authentication is required.
+
+The <var>role</var> is one of ` + "`" + `producer` + "`" + `, which is allowed to push jobs and get their status, ` + "`" + `operator` + "`" + `, which is also allowed to inspect queues, routings, jobs and failed jobs, and ` + "`" + `admin` + "`" + `, which is also allowed to modify queue definitions and routings.
+`,
+		secret: true,
+	},
+	"tls_cert_file": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a PEM encoded certificate file for the API.  If this value and [the key file](#env-tls-key-file) are specified, the daemon serves HTTPS instead of HTTP.  The certificate is reloaded from the files when the daemon receives ` + "`" + `SIGUSR1` + "`" + `.
+`,
+	},
+	"tls_key_file": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a PEM encoded private key file of [the certificate](#env-tls-cert-file) for the API.
 `,
 	},
 	"pid": {
@@ -64,6 +90,7 @@ If this value is specified, each line in the file is a JSON string corresponds t
 Specifies a log level of the access log.  ` + logLevelDescription + `
 If none of these values is specified, the level is determined by ` + "`" + `DEBUG` + "`" + ` environment variable.  If ` + "`" + `DEBUG` + "`" + ` has a non-empty value, then the level is ` + "`" + `debug` + "`" + `.  Otherwise, the level is ` + "`" + `info` + "`" + `.
 `,
+		reloadable: true,
 	},
 	"shutdown_timeout": {
 		defaultValue: "30",
@@ -79,12 +106,21 @@ Specifies a timeout, in seconds, which the daemon waits on [gracefully shutting
 Specifies whether connections should be reused.
 `,
 	},
+	"events_buffer_size": {
+		defaultValue: "256",
+		label:        "<number>",
+		description: `
+Specifies the number of events buffered for each client of the [event stream][api-get-events].  A client which falls behind by more events than this is disconnected so that it never blocks dispatching jobs.
+`,
+		reloadable: true,
+	},
 	"config_refresh_interval": {
 		defaultValue: "1000",
 		label:        "<milliseconds>",
 		description: `
 Specifies an interval, in milliseconds, at which a Middleman daemon checks if configurations (such as queue definitions or routings) are changed by other daemons.
 `,
+		reloadable: true,
 	},
 	"driver": {
 		defaultValue: "mysql",
@@ -101,6 +137,7 @@ Note that ` + "`in-memory`" + ` driver is not for production use.  It is intende
 		description: `
 Specifies a data source name for the job queue and the repository database in a form <code><var>user</var>:<var>password</var>@tcp(<var>mysql_host</var>:<var>mysql_port</var>)/<var>database</var>?<var>options</var></code>.  This is in effect only when [the driver](#env-driver) is ` + "`" + `mysql` + "`" + ` and is mandatory for that case.
 `,
+		secret: true,
 	},
 	"repository_mysql_dsn": {
 		defaultValue: "",
@@ -108,6 +145,7 @@ Specifies a data source name for the job queue and the repository database in a
 		description: `
 Specifies a data source name for the repository database in a form <code><var>user</var>:<var>password</var>@tcp(<var>mysql_host</var>:<var>mysql_port</var>)/<var>database</var>?<var>options</var></code>.  This is in effect only when the [driver](#env-driver) is ` + "`" + `mysql` + "`" + ` and overrides [the default DSN](#env-mysql-dsn).  This should be used when you want to specify a DSN differs from [the queue DSN](#env-queue-mysql-dsn).
 `,
+		secret: true,
 	},
 	"queue_default": {
 		defaultValue: "",
@@ -117,6 +155,69 @@ Specifies the name of a default queue.  A job whose ` + "`" + `category` + "`" +
 
 If you already have a queue with the specified name in the job queue database, that one is used.  Or otherwise a new queue is created automatically.
 `,
+		reloadable: true,
+	},
+	"definitions": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a JSON or YAML (` + "`" + `.yaml` + "`" + ` or ` + "`" + `.yml` + "`" + `) file of [queue and routing definitions][section-definitions].  Queues and routings are reconciled with the file on startup and whenever the daemon receives ` + "`" + `SIGHUP` + "`" + `.
+`,
+		reloadable: true,
+	},
+	"definitions_prune": {
+		defaultValue: "false",
+		label:        "true|false",
+		description: `
+Specifies whether queues and routings not declared in [` + "`" + `MIDDLEMAN_DEFINITIONS` + "`" + `][section-definitions] are deleted on reconciling.  The default queue and the callback queue are never deleted.
+`,
+		reloadable: true,
+	},
+	"config": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a [configuration file][section-config-file] in YAML (` + "`" + `.yaml` + "`" + ` or ` + "`" + `.yml` + "`" + `) or TOML (` + "`" + `.toml` + "`" + `).  A value in the file is overridden by the environment variable and the command line option of the same key.
+`,
+	},
+	"callback_queue": {
+		defaultValue: "",
+		label:        "<name>",
+		description: `
+Specifies the name of a queue through which [callbacks][api-post-job] of finished jobs are delivered, such as ` + "`" + `middleman_callback` + "`" + `.  The queue is created automatically if it does not exist.  If this value is empty, no callback is delivered.
+
+A callback is regarded as successful if the response status is ` + "`" + `2xx` + "`" + `.  It is not retried if the status is ` + "`" + `4xx` + "`" + ` other than ` + "`" + `408` + "`" + ` and ` + "`" + `429` + "`" + `.
+`,
+	},
+	"callback_max_retries": {
+		defaultValue: "10",
+		label:        "<number>",
+		description: `
+Specifies the maximum number of retrying a failed callback.
+`,
+	},
+	"callback_retry_delay": {
+		defaultValue: "60",
+		label:        "<seconds>",
+		description: `
+Specifies a delay, in seconds, to wait before retrying a failed callback.
+`,
+	},
+	"overflow_retry_after": {
+		defaultValue: "10",
+		label:        "<seconds>",
+		description: `
+Specifies the value of ` + "`" + `Retry-After` + "`" + ` header, in seconds, of a response rejecting a job pushed to a queue which reached its [length limit][api-put-queue].
+`,
+		reloadable: true,
+	},
+	"run_at_max_horizon": {
+		defaultValue: "31536000",
+		label:        "<seconds>",
+		description: `
+Specifies how far in the future, in seconds, ` + "`" + `run_at` + "`" + ` of a [pushed job][api-post-job] can be.  ` + "`" + `0` + "`" + ` means no limit.
+`,
+		reloadable: true,
 	},
 	"queue_default_polling_interval": {
 		defaultValue: "200",
@@ -124,12 +225,21 @@ If you already have a queue with the specified name in the job queue database, t
 		description: `
 Specifies the default interval, in milliseconds, at which Middleman checks the arrival of new jobs, used when ` + "`" + `polling_interval` + "`" + ` in the [queue API][api-put-queue] is omitted.
 `,
+		reloadable: true,
 	},
 	"queue_default_max_workers": {
 		defaultValue: "20",
 		label:        "<number>",
 		description: `
 Specifies the default maximum number of jobs that are processed simultaneously in a queue, used when ` + "`" + `max_workers` + "`" + ` in the [queue API][api-put-queue] is omitted.
+`,
+		reloadable: true,
+	},
+	"attempt_log_max_per_job": {
+		defaultValue: "10",
+		label:        "<number>",
+		description: `
+Specifies the maximum number of attempts kept for each job in the [attempt log][api-get-queue-job-attempts].  Older attempts are removed when a job is dispatched more than this number of times.  ` + "`" + `0` + "`" + ` disables the attempt log.
 `,
 	},
 	"queue_log": {
@@ -155,6 +265,7 @@ Specifies the value of ` + "`" + `tag` + "`" + ` field in a job queue log item J
 Specifies a log level of the job queue logs.  ` + logLevelDescription + `
 If none of these values is specified, the level is determined by ` + "`" + `DEBUG` + "`" + ` environment variable.  If ` + "`" + `DEBUG` + "`" + ` has a non-empty value, then the level is ` + "`" + `debug` + "`" + `.  Otherwise, the level is ` + "`" + `info` + "`" + `.
 `,
+		reloadable: true,
 	},
 	"queue_mysql_dsn": {
 		defaultValue: "",
@@ -162,6 +273,7 @@ If none of these values is specified, the level is determined by ` + "`" + `DEBU
 		description: `
 Specifies a data source name for the job queue database in a form <code><var>user</var>:<var>password</var>@tcp(<var>mysql_host</var>:<var>mysql_port</var>)/<var>database</var>?<var>options</var></code>.  This is in effect only when the [driver](#env-driver) is ` + "`" + `mysql` + "`" + ` and overrides [the default DSN](#env-mysql-dsn).  This should be used when you want to specify a DSN differs from [the repository DSN](#env-repository-mysql-dsn).
 `,
+		secret: true,
 	},
 	"dispatch_user_agent": {
 		defaultValue: "",
@@ -169,12 +281,62 @@ Specifies a data source name for the job queue database in a form <code><var>use
 		description: `
 Specifies the value of ` + "`" + `User-Agent` + "`" + ` header field used for an HTTP request to a worker.  The default value is <code>Middleman/<var>version</var></code>.
 `,
+		reloadable: true,
+	},
+	"dispatch_allowed_schemes": {
+		defaultValue: "http,https",
+		label:        "<schemes>",
+		description: `
+Specifies a comma-separated list of URL schemes of jobs which can be pushed.
+`,
+		reloadable: true,
+	},
+	"dispatch_allowed_hosts": {
+		defaultValue: "",
+		label:        "<hosts>",
+		description: `
+Specifies a comma-separated list of hosts of URLs of jobs which can be pushed, such as ` + "`" + `example.com` + "`" + ` or ` + "`" + `*.example.com` + "`" + ` which matches its subdomains.  Any host is allowed by default.  See [restricting destinations][section-allowlist].
+`,
+		reloadable: true,
+	},
+	"dispatch_allowed_networks": {
+		defaultValue: "",
+		label:        "<CIDRs>",
+		description: `
+Specifies a comma-separated list of networks in CIDR notation, such as ` + "`" + `10.0.0.0/8` + "`" + `, to which jobs can be dispatched.  The address is checked on every connection after resolving the host name as well as on pushing a job whose URL has an IP address, and a proxy given by ` + "`" + `HTTP_PROXY` + "`" + ` or ` + "`" + `HTTPS_PROXY` + "`" + ` is not used while networks are restricted.  Any address is allowed by default.  See [restricting destinations][section-allowlist].
+`,
+		reloadable: true,
+	},
+	"dispatch_tls_ca_file": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a PEM encoded CA certificate file used to verify HTTPS workers in addition to the system root CAs.
+`,
+		reloadable: true,
+	},
+	"dispatch_tls_cert_file": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a PEM encoded client certificate file presented to HTTPS workers which require client authentication.  This must be specified together with [the key file](#env-dispatch-tls-key-file).
+`,
+		reloadable: true,
+	},
+	"dispatch_tls_key_file": {
+		defaultValue: "",
+		label:        "<file>",
+		description: `
+Specifies a PEM encoded private key file of [the client certificate](#env-dispatch-tls-cert-file).
+`,
+		reloadable: true,
 	},
 	"dispatch_keep_alive": {
 		label: "true|false",
 		description: `
 Specifies whether a connection to a worker should be reused.  This overrides [the default keep-alive setting](#env-keep-alive).
 `,
+		reloadable: true,
 	},
 	"dispatch_max_conns_per_host": {
 		defaultValue: "10",
@@ -182,6 +344,15 @@ Specifies whether a connection to a worker should be reused.  This overrides [th
 		description: `
 Specifies maximum idle connections to keep per-host. This value works only when [connections of the dispatcher are reused](#env-dispatch-keep-alive).
 `,
+		reloadable: true,
+	},
+	"dispatch_max_workers": {
+		defaultValue: "0",
+		label:        "<number>",
+		description: `
+Specifies the maximum number of jobs that are processed simultaneously across all queues on a node, which bounds outbound connections to workers as well.  Each queue is guaranteed ` + "`" + `reserved_workers` + "`" + ` in the [queue API][api-put-queue] within it, and borrows the workers neither running nor reserved by ` + "`" + `worker_weight` + "`" + `.  ` + "`" + `0` + "`" + ` means no limit.
+`,
+		reloadable: true,
 	},
 	"dispatch_idle_conn_timeout": {
 		defaultValue: "0",
@@ -189,5 +360,6 @@ Specifies maximum idle connections to keep per-host. This value works only when
 		description: `
 Specifies the maximum amount of time of an idle (keep-alive) connection will remain idle before closing itself. If zero, an idle connections will not be closed. 
 `,
+		reloadable: true,
 	},
 }
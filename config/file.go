@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"sigs.k8s.io/yaml"
+)
+
+// A key suffixed by this in the environment variables or a
+// configuration file names a file to read the value of the key from.
+const secretFileSuffix = "_file"
+
+// LoadFile loads configuration values from a file, which is in either
+// YAML (.yaml or .yml) or TOML (.toml).  Keys of nested mappings and
+// tables are joined by underscores to configuration keys; sequences
+// are not supported.
+//
+// ${NAME} in a value is replaced with the value of environment
+// variable NAME.
+func LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var values map[string]string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		values, err = parseYAML(f)
+	case ".toml":
+		values, err = parseTOML(f)
+	default:
+		return fmt.Errorf("Unknown configuration file format: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot parse %s: %s", path, err)
+	}
+
+	for k, v := range values {
+		if k == "config" || !isKnownKey(k) {
+			return fmt.Errorf("Unknown configuration key in %s: %s", path, k)
+		}
+		values[k] = interpolate(v)
+	}
+
+	cached.Lock()
+	defer cached.Unlock()
+	cached.file = values
+	cached.resolved = make(map[string]string)
+	return nil
+}
+
+func isKnownKey(key string) bool {
+	cached.RLock()
+	defer cached.RUnlock()
+	if _, ok := defaultConf[key]; ok {
+		return true
+	}
+	_, ok := defaultConf[strings.TrimSuffix(key, secretFileSuffix)]
+	return ok
+}
+
+func parseYAML(r io.Reader) (map[string]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = yaml.YAMLToJSONStrict(b); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var tree map[string]interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	return values, flatten(values, "", tree)
+}
+
+func parseTOML(r io.Reader) (map[string]string, error) {
+	var tree map[string]interface{}
+	if _, err := toml.NewDecoder(r).Decode(&tree); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	return values, flatten(values, "", tree)
+}
+
+// flatten puts scalar values in a tree into values by keys joined by
+// underscores, such as mysql_dsn for dsn in mysql.
+func flatten(values map[string]string, prefix string, tree map[string]interface{}) error {
+	for k, v := range tree {
+		key := prefix + strings.Replace(k, "-", "_", -1)
+		var value string
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if err := flatten(values, key+"_", v); err != nil {
+				return err
+			}
+			continue
+		case []interface{}, []map[string]interface{}:
+			return fmt.Errorf("sequences are not supported: %s", key)
+		case nil:
+			return fmt.Errorf("missing value of %s", key)
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			value = fmt.Sprint(v)
+		}
+		if _, ok := values[key]; ok {
+			return fmt.Errorf("duplicate key %s", key)
+		}
+		values[key] = value
+	}
+	return nil
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func interpolate(s string) string {
+	return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+func readSecretFile(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	SetDefault("test_file_1", "default1")
+	SetDefault("test_file_2", "default2")
+	SetDefault("test_file_3", "default3")
+	SetDefault("test_file_4", "default4")
+	SetDefault("test_file_5", "default5")
+	defer LoadFile(writeFile(t, "empty.yaml", ""))
+
+	os.Setenv("MIDDLEMAN_TEST_FILE_2", "env2")
+	os.Setenv("TEST_FILE_HOST", "example.com")
+	secret := writeFile(t, "secret", "s3cret\n")
+
+	for _, f := range []struct {
+		name    string
+		content string
+	}{
+		{"config.yaml", `
+# comment
+test_file_1: "file1" # comment
+test_file_2: file2
+test-file-3: 'http://${TEST_FILE_HOST}/#'
+test_file_4_file: ` + secret + `
+`},
+		{"config.toml", `
+# comment
+test_file_1 = "file1" # comment
+test_file_2 = "file2"
+test-file-3 = 'http://${TEST_FILE_HOST}/#'
+test_file_4_file = "` + secret + `"
+`},
+	} {
+		Set("test_file_1", "set1")
+		if err := LoadFile(writeFile(t, f.name, f.content)); err != nil {
+			t.Fatalf("%s: %s", f.name, err)
+		}
+		if v := Get("test_file_1"); v != "set1" {
+			t.Errorf("%s: A value set explicitly should have the highest precedence: %s", f.name, v)
+		}
+		if v := Get("test_file_2"); v != "env2" {
+			t.Errorf("%s: A value from environment variable should have higher precedence than the file: %s", f.name, v)
+		}
+		if v := Get("test_file_3"); v != "http://example.com/#" {
+			t.Errorf("%s: A value should be read from the file: %s", f.name, v)
+		}
+		if v := Get("test_file_4"); v != "s3cret" {
+			t.Errorf("%s: A value should be read from the secret file: %s", f.name, v)
+		}
+		if v := Get("test_file_5"); v != "default5" {
+			t.Errorf("%s: It should fallback to the default value: %s", f.name, v)
+		}
+
+		cached.Lock()
+		delete(cached.c, "test_file_1")
+		cached.Unlock()
+		if v := Get("test_file_1"); v != "file1" {
+			t.Errorf("%s: A value in the file should have higher precedence than the default value: %s", f.name, v)
+		}
+	}
+}
+
+func TestLoadFileNested(t *testing.T) {
+	SetDefault("test_nested_1", "default1")
+	SetDefault("test_nested_2", "default2")
+	defer LoadFile(writeFile(t, "empty.yaml", ""))
+
+	for _, f := range []struct {
+		name    string
+		content string
+	}{
+		{"config.yaml", `
+test:
+  nested-1: file1
+  nested_2: 2
+`},
+		{"config.toml", `
+[test]
+nested-1 = "file1"
+nested_2 = 2
+`},
+	} {
+		if err := LoadFile(writeFile(t, f.name, f.content)); err != nil {
+			t.Fatalf("%s: %s", f.name, err)
+		}
+		if v := Get("test_nested_1"); v != "file1" {
+			t.Errorf("%s: A nested key should be joined: %s", f.name, v)
+		}
+		if v := Get("test_nested_2"); v != "2" {
+			t.Errorf("%s: A number should be read as a string: %s", f.name, v)
+		}
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	defer LoadFile(writeFile(t, "empty.yaml", ""))
+
+	for _, f := range []struct {
+		name    string
+		content string
+	}{
+		{"config.json", `{}`},
+		{"config.yaml", "unknown_key_of_test: foo\n"},
+		{"config.yaml", "bind:\n  - foo\n"},
+		{"config.yaml", "bind: [foo]\n"},
+		{"config.yaml", "bind foo\n"},
+		{"config.yaml", "bind: foo\nbind: bar\n"},
+		{"config.yaml", "bind:\n"},
+		{"config.toml", "[table]\nbind = \"foo\"\n"},
+		{"config.toml", "bind = \"foo\n"},
+	} {
+		if err := LoadFile(writeFile(t, f.name, f.content)); err == nil {
+			t.Errorf("%s should not be loaded: %q", f.name, f.content)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Change describes a configuration value changed by Reload.
+type Change struct {
+	Name   string
+	Old    string
+	New    string
+	Secret bool
+}
+
+func (c *Change) String() string {
+	if c.Secret {
+		return fmt.Sprintf("%s: (redacted)", c.Name)
+	}
+	return fmt.Sprintf("%s: %q -> %q", c.Name, c.Old, c.New)
+}
+
+// Reload reads the configuration file again and returns the changed
+// values.  Values which can be changed at runtime are applied, and the
+// others are refused and keep their old values until the daemon
+// restarts.
+//
+// If any changed value is invalid, nothing is changed and an error is
+// returned.
+func Reload() (applied []Change, refused []Change, err error) {
+	keys := Keys()
+	sort.Strings(keys)
+
+	old := make(map[string]string, len(keys))
+	for _, k := range keys {
+		old[k] = Get(k)
+	}
+
+	cached.RLock()
+	file := cached.file
+	resolved := cached.resolved
+	cached.RUnlock()
+	rollback := func() {
+		cached.Lock()
+		defer cached.Unlock()
+		cached.file = file
+		cached.resolved = resolved
+	}
+
+	if path := Get("config"); path != "" {
+		if err := LoadFile(path); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		// only to read secret files again
+		cached.Lock()
+		cached.resolved = make(map[string]string)
+		cached.Unlock()
+	}
+
+	for _, k := range keys {
+		v := Get(k)
+		if v == old[k] {
+			continue
+		}
+
+		cached.RLock()
+		item := defaultConf[k]
+		cached.RUnlock()
+		if err := validate(item.label, v); err != nil && v != item.defaultValue {
+			rollback()
+			return nil, nil, fmt.Errorf("%s: %s", k, err)
+		}
+
+		c := Change{Name: k, Old: old[k], New: v, Secret: item.secret}
+		if item.reloadable {
+			applied = append(applied, c)
+		} else {
+			refused = append(refused, c)
+		}
+	}
+
+	cached.Lock()
+	defer cached.Unlock()
+	for _, c := range refused {
+		cached.resolved[c.Name] = c.Old
+	}
+
+	return applied, refused, nil
+}
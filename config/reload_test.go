@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	defer LoadFile(writeFile(t, "empty.yaml", ""))
+
+	path := writeFile(t, "config.yaml", "")
+	Locally("config", path, func() {
+		bind := Get("bind")
+
+		if err := os.WriteFile(path, []byte("queue_log_level: debug\nbind: 127.0.0.1:65535\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		applied, refused, err := Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != 1 || applied[0].Name != "queue_log_level" || applied[0].New != "debug" {
+			t.Errorf("A reloadable value should be applied: %v", applied)
+		}
+		if len(refused) != 1 || refused[0].Name != "bind" {
+			t.Errorf("A value requiring restart should be refused: %v", refused)
+		}
+		if v := Get("bind"); v != bind {
+			t.Errorf("A refused value should not be applied: %s", v)
+		}
+
+		if err := os.WriteFile(path, []byte("queue_log_level: loud\nbind: 127.0.0.1:65535\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := Reload(); err == nil {
+			t.Error("An invalid value should be rejected")
+		}
+		if v := Get("queue_log_level"); v != "debug" {
+			t.Errorf("An invalid value should not be applied: %s", v)
+		}
+		if v := Get("bind"); v != bind {
+			t.Errorf("A refused value should not be applied after a failed reload: %s", v)
+		}
+	})
+}
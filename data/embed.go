@@ -4,4 +4,5 @@ import "embed"
 
 //go:embed repository/mysql/schema
 //go:embed jobqueue/mysql/schema
+//go:embed jobqueue/mysql/query
 var EFS embed.FS
@@ -0,0 +1,2 @@
+SELECT attempt_id, job_id, result, node, started_at, elapsed FROM `{{.Attempt}}`
+WHERE job_id IN
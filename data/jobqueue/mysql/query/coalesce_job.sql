@@ -0,0 +1,4 @@
+UPDATE `{{.JobQueue}}`
+SET payload = ?,
+    next_try = GREATEST(next_try, FLOOR(UNIX_TIMESTAMP(CURRENT_TIME(3)) * 1000) + ?)
+WHERE job_id = ? AND status = 'claimed'
@@ -0,0 +1,2 @@
+SELECT job_id, concurrency_key, holder IS NOT NULL FROM `{{.ConcurrencyKey}}`
+WHERE job_id IN
@@ -0,0 +1,2 @@
+SELECT COUNT(*) FROM `{{.JobQueue}}`
+WHERE status = 'claimed'
@@ -0,0 +1,3 @@
+UPDATE `{{.Debounce}}`
+SET job_id = ?
+WHERE debounce_key = ?
@@ -0,0 +1,4 @@
+SELECT job.job_id FROM `{{.Debounce}}` AS debounce
+INNER JOIN `{{.JobQueue}}` AS job ON job.job_id = debounce.job_id
+WHERE debounce.debounce_key = ? AND job.status = 'claimed'
+FOR UPDATE
@@ -0,0 +1,2 @@
+DELETE FROM `{{.Attempt}}`
+WHERE job_id IN
@@ -0,0 +1,2 @@
+DELETE FROM `{{.ConcurrencyKey}}`
+WHERE job_id IN
@@ -0,0 +1,2 @@
+DELETE FROM `{{.Debounce}}`
+WHERE job_id IN
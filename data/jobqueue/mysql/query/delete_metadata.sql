@@ -0,0 +1,2 @@
+DELETE FROM `{{.Metadata}}`
+WHERE job_id IN
@@ -0,0 +1,3 @@
+DELETE FROM `{{.JobQueue}}`
+WHERE job_id = ?
+  AND status = 'claimed'
@@ -0,0 +1,3 @@
+SELECT job_id FROM `{{.History}}`
+WHERE completed_at < ?
+ORDER BY completed_at ASC LIMIT
@@ -1,5 +1,9 @@
 SELECT job_id FROM `{{.JobQueue}}`
 WHERE status = 'claimed'
   AND next_try <= FLOOR(UNIX_TIMESTAMP(CURRENT_TIME(3)) * 1000)
+  AND job_id NOT IN (
+    SELECT waiting.job_id FROM `{{.ConcurrencyKey}}` AS waiting
+    INNER JOIN `{{.ConcurrencyKey}}` AS held ON held.holder = waiting.concurrency_key
+  )
 ORDER BY next_try ASC
 LIMIT
@@ -0,0 +1,4 @@
+SELECT COUNT(*) FROM information_schema.columns
+WHERE table_schema = DATABASE()
+  AND table_name = '{{.JobQueue}}'
+  AND column_name = ?
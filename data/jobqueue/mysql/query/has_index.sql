@@ -0,0 +1,4 @@
+SELECT COUNT(*) FROM information_schema.statistics
+WHERE table_schema = DATABASE()
+  AND table_name = '{{.JobQueue}}'
+  AND index_name = ?
@@ -0,0 +1,2 @@
+SELECT job_id, category, url, payload, status, result, attempts, created_at, completed_at FROM `{{.History}}`
+WHERE job_id = ?
@@ -0,0 +1,3 @@
+UPDATE IGNORE `{{.ConcurrencyKey}}`
+SET holder = concurrency_key
+WHERE job_id = ? AND holder IS NULL
@@ -0,0 +1,2 @@
+INSERT INTO `{{.Attempt}}` (job_id, result, node, started_at, elapsed)
+VALUES (?, ?, ?, ?, ?)
@@ -0,0 +1,2 @@
+INSERT INTO `{{.ConcurrencyKey}}` (job_id, concurrency_key)
+VALUES (?, ?)
@@ -0,0 +1,2 @@
+REPLACE INTO `{{.History}}` (job_id, category, url, payload, status, result, attempts, created_at, completed_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
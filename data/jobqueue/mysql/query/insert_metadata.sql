@@ -0,0 +1,2 @@
+INSERT INTO `{{.Metadata}}` (job_id, metadata)
+VALUES (?, ?)
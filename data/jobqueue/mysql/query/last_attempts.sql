@@ -0,0 +1,4 @@
+SELECT attempt_id, job_id, result, node, started_at, elapsed FROM `{{.Attempt}}`
+WHERE attempt_id IN (
+  SELECT MAX(attempt_id) FROM `{{.Attempt}}`
+  WHERE job_id IN
@@ -0,0 +1,4 @@
+INSERT INTO `{{.Debounce}}` (debounce_key)
+VALUES (?)
+ON DUPLICATE KEY UPDATE
+  debounce_key = debounce_key
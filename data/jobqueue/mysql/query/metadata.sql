@@ -0,0 +1,2 @@
+SELECT job_id, metadata FROM `{{.Metadata}}`
+WHERE job_id IN
@@ -0,0 +1,4 @@
+SELECT job_id, next_try FROM `{{.JobQueue}}`
+WHERE status = 'claimed'
+ORDER BY next_try ASC
+LIMIT 1
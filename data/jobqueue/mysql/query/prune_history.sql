@@ -0,0 +1,2 @@
+DELETE FROM `{{.History}}`
+WHERE job_id IN
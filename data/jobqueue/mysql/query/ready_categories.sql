@@ -0,0 +1,4 @@
+SELECT category FROM `{{.JobQueue}}`
+WHERE status = 'claimed'
+GROUP BY category
+HAVING MIN(next_try) <= FLOOR(UNIX_TIMESTAMP(CURRENT_TIME(3)) * 1000)
@@ -0,0 +1,4 @@
+SELECT tenant FROM `{{.JobQueue}}`
+WHERE status = 'claimed'
+GROUP BY tenant
+HAVING MIN(next_try) <= FLOOR(UNIX_TIMESTAMP(CURRENT_TIME(3)) * 1000)
@@ -0,0 +1,3 @@
+UPDATE `{{.ConcurrencyKey}}`
+SET holder = NULL
+WHERE job_id IN
@@ -0,0 +1,8 @@
+DELETE FROM `{{.Attempt}}`
+WHERE job_id = ? AND attempt_id <= (
+  SELECT attempt_id FROM (
+    SELECT attempt_id FROM `{{.Attempt}}`
+    WHERE job_id = ?
+    ORDER BY attempt_id DESC LIMIT 1 OFFSET ?
+  ) AS oldest
+)
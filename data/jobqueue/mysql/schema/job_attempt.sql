@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `{{.Attempt}}` (
+  `attempt_id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `job_id` BIGINT UNSIGNED NOT NULL,
+  `result` MEDIUMBLOB,
+  `node` VARCHAR(255) NOT NULL,
+  `started_at` BIGINT UNSIGNED NOT NULL,
+  `elapsed` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`attempt_id`),
+  KEY `job` (`job_id`, `attempt_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
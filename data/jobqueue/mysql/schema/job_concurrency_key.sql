@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `{{.ConcurrencyKey}}` (
+  `job_id` BIGINT UNSIGNED NOT NULL,
+  `concurrency_key` VARBINARY(255) NOT NULL,
+  `holder` VARBINARY(255),
+  PRIMARY KEY (`job_id`),
+  KEY `concurrency` (`concurrency_key`),
+  UNIQUE KEY `holder` (`holder`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `{{.Debounce}}` (
+  `debounce_key` VARBINARY(255) NOT NULL,
+  `job_id` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (`debounce_key`),
+  KEY `job` (`job_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
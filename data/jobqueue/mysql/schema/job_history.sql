@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `{{.History}}` (
+  `job_id` BIGINT UNSIGNED NOT NULL,
+  `category` VARCHAR(255) NOT NULL,
+  `url` BLOB,
+  `payload` MEDIUMBLOB,
+  `status` ENUM('completed', 'failed') NOT NULL,
+  `result` MEDIUMBLOB,
+  `attempts` INT UNSIGNED NOT NULL,
+  `created_at` BIGINT UNSIGNED NOT NULL,
+  `completed_at` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`job_id`),
+  KEY `completion_order` (`completed_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `{{.Metadata}}` (
+  `job_id` BIGINT UNSIGNED NOT NULL,
+  `metadata` MEDIUMBLOB,
+  PRIMARY KEY (`job_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
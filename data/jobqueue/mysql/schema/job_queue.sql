@@ -12,6 +12,9 @@ CREATE TABLE IF NOT EXISTS `{{.JobQueue}}` (
   `url` BLOB,
   `payload` MEDIUMBLOB,
   `timeout` INT UNSIGNED,
+{{- if .Tenant}}
+  `tenant` VARBINARY(255) NOT NULL DEFAULT '',
+{{- end}}
 
   PRIMARY KEY (`job_id`),
   KEY `grab` (`status`, `next_try`)
@@ -0,0 +1,2 @@
+ALTER TABLE `{{.JobQueue}}`
+  ADD KEY `fair_category` (`status`, `category`, `next_try`)
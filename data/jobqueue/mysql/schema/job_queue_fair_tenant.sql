@@ -0,0 +1,2 @@
+ALTER TABLE `{{.JobQueue}}`
+  ADD KEY `fair_tenant` (`status`, `tenant`, `next_try`)
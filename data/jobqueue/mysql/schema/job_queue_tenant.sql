@@ -0,0 +1,2 @@
+ALTER TABLE `{{.JobQueue}}`
+  ADD COLUMN `tenant` VARBINARY(255) NOT NULL DEFAULT ''
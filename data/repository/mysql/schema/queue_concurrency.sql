@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `queue_concurrency` (
+  `name` VARCHAR(255) NOT NULL,
+  `adaptive_workers` BOOLEAN NOT NULL DEFAULT FALSE,
+  `min_workers` INT UNSIGNED NOT NULL,
+  `target_latency` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `queue_dispatch_window` (
+  `name` VARCHAR(255) NOT NULL,
+  `timezone` VARCHAR(64) NOT NULL,
+  `windows` BLOB NOT NULL,
+  `blackouts` BLOB NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `queue_fair_share` (
+  `name` VARCHAR(255) NOT NULL,
+  `fair_share` VARCHAR(32) NOT NULL,
+  `weights` BLOB NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
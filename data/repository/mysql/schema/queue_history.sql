@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `queue_history` (
+  `name` VARCHAR(255) NOT NULL,
+  `retention` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `queue_limit` (
+  `name` VARCHAR(255) NOT NULL,
+  `max_length` INT UNSIGNED NOT NULL,
+  `max_age` INT UNSIGNED NOT NULL,
+  `overflow_policy` VARCHAR(32) NOT NULL,
+  `spill_queue` VARCHAR(255) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
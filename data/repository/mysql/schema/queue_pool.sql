@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `queue_pool` (
+  `name` VARCHAR(255) NOT NULL,
+  `reserved_workers` INT UNSIGNED NOT NULL,
+  `worker_weight` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `queue_rate_limit` (
+  `name` VARCHAR(255) NOT NULL,
+  `rate_limit_by` VARCHAR(32) NOT NULL,
+  `rate_limits` BLOB NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
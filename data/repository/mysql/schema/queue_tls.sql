@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `queue_tls` (
+  `name` VARCHAR(255) NOT NULL,
+  `insecure_skip_verify` BOOLEAN NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
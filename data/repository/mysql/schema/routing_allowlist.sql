@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `routing_allowlist` (
+  `job_category` VARCHAR(255) NOT NULL,
+  `allowlist` BLOB NOT NULL,
+  PRIMARY KEY (`job_category`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `routing_callback` (
+  `job_category` VARCHAR(255) NOT NULL,
+  `callback_url` BLOB,
+  PRIMARY KEY (`job_category`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
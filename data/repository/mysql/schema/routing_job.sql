@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `routing_job` (
+  `job_category` VARCHAR(255) NOT NULL,
+  `url` BLOB,
+  `timeout` INT UNSIGNED NOT NULL,
+  `max_retries` INT UNSIGNED NOT NULL,
+  `retry_delay` INT UNSIGNED NOT NULL,
+  `timeout_limit` INT UNSIGNED NOT NULL,
+  `max_retries_limit` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`job_category`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `routing_priority` (
+  `job_category` VARCHAR(255) NOT NULL,
+  `priority` INT NOT NULL,
+  PRIMARY KEY (`job_category`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
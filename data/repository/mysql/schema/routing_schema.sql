@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `routing_schema` (
+  `job_category` VARCHAR(255) NOT NULL,
+  `json_schema` BLOB NOT NULL,
+  PRIMARY KEY (`job_category`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `routing_target` (
+  `job_category` VARCHAR(255) NOT NULL,
+  `queue_name` VARCHAR(255) NOT NULL,
+  `weight` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`job_category`, `queue_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=binary;
@@ -0,0 +1,106 @@
+package dispatcher
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coosir/middleman/jobqueue"
+)
+
+const (
+	adaptiveIncrease = 1.0 // additive increase per limit-worth of successes
+	adaptiveDecrease = 0.5 // multiplicative decrease on overload
+)
+
+// adaptiveConcurrency is a concurrency limit which is adjusted by an
+// AIMD (additive increase, multiplicative decrease) algorithm.
+//
+// The limit grows by one after a limit-worth of jobs succeeded in time
+// and is halved when a job failed to reach a worker, timed out, got a
+// server error or took longer than the target latency.  Only one
+// decrease happens for jobs started before the last decrease so that
+// a burst of failures does not drop the limit to the minimum at once.
+type adaptiveConcurrency struct {
+	mu            sync.Mutex
+	cond          *sync.Cond
+	running       uint
+	limit         float64
+	min           uint
+	max           uint
+	targetLatency time.Duration
+	lastDecrease  time.Time
+}
+
+func newAdaptiveConcurrency(min, max uint, targetLatency time.Duration) *adaptiveConcurrency {
+	if min == 0 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	c := &adaptiveConcurrency{
+		limit:         float64(min),
+		min:           min,
+		max:           max,
+		targetLatency: targetLatency,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// acquire waits until the number of running jobs gets below the
+// current limit.
+func (c *adaptiveConcurrency) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.running >= uint(c.limit) {
+		c.cond.Wait()
+	}
+	c.running++
+}
+
+// release reports the result of a job dispatched at started and
+// adjusts the limit.  A nil result means that the job has not been
+// dispatched and leaves the limit as it is.
+func (c *adaptiveConcurrency) release(started time.Time, rslt *jobqueue.Result) {
+	elapsed := time.Since(started)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running--
+	defer c.cond.Broadcast()
+
+	if rslt == nil {
+		return
+	}
+	if isOverloaded(rslt) || (c.targetLatency > 0 && elapsed > c.targetLatency) {
+		if started.After(c.lastDecrease) {
+			c.limit *= adaptiveDecrease
+			if c.limit < float64(c.min) {
+				c.limit = float64(c.min)
+			}
+			c.lastDecrease = time.Now()
+		}
+	} else {
+		c.limit += adaptiveIncrease / c.limit
+		if c.limit > float64(c.max) {
+			c.limit = float64(c.max)
+		}
+	}
+}
+
+// current returns the current limit.
+func (c *adaptiveConcurrency) current() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint(c.limit)
+}
+
+func isOverloaded(rslt *jobqueue.Result) bool {
+	return rslt.Status == jobqueue.ResultStatusInternalFailure ||
+		rslt.Code == http.StatusTooManyRequests ||
+		rslt.Code >= http.StatusInternalServerError
+}
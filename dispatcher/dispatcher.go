@@ -1,8 +1,8 @@
 package dispatcher
 
 import (
-	"context"
 	"sync"
+	"time"
 
 	"github.com/coosir/middleman/dispatcher/kicker"
 	"github.com/coosir/middleman/dispatcher/worker"
@@ -22,6 +22,26 @@ const defaultMinBufferSize = 1000
 // Configuration keys prefixed by "dispatch_" are considered.
 func Init() {
 	worker.HTTPInit()
+	size, err := poolSize()
+	if err != nil {
+		log.Panic().Msg(err.Error())
+	}
+	defaultPool.Resize(size)
+}
+
+// Reload replaces global parameters of dispatchers by the current
+// configuration values.  Running dispatchers use them for subsequent
+// jobs.
+func Reload() error {
+	size, err := poolSize()
+	if err != nil {
+		return err
+	}
+	if err := worker.HTTPReload(); err != nil {
+		return err
+	}
+	defaultPool.Resize(size)
+	return nil
 }
 
 // Config contains information to create a dispatcher instance.
@@ -29,6 +49,7 @@ type Config struct {
 	MinBufferSize uint
 	Kicker        kicker.Config
 	Worker        worker.Config
+	Pool          *Pool // defaults to the pool of the node
 }
 
 // Start creates and starts a new dispatcher instance with the current
@@ -55,7 +76,10 @@ func (cfg Config) Start(q JobQueue, m *model.Queue) Dispatcher {
 
 	wc := cfg.Worker
 	if wc == nil {
-		wc = &worker.HTTPWorker{Logger: &logger}
+		wc = &worker.HTTPWorker{
+			Logger:             &logger,
+			InsecureSkipVerify: m.InsecureSkipVerify,
+		}
 	}
 	w := wc.NewWorker()
 
@@ -65,17 +89,39 @@ func (cfg Config) Start(q JobQueue, m *model.Queue) Dispatcher {
 	}
 	limiter := rate.NewLimiter(dps, int(m.MaxBurstSize))
 
+	pool := cfg.Pool
+	if pool == nil {
+		pool = defaultPool
+	}
+
+	schedule, err := newSchedule(m)
+	if err != nil {
+		logger.Warn().Msgf("Dispatch windows are ignored: %s", err)
+	}
+
 	d := &dispatcher{
-		jobqueue:  q,
-		kicker:    k,
-		worker:    w,
-		kick:      make(chan struct{}),
-		stop:      make(chan struct{}),
-		stopped:   make(chan struct{}),
-		jobBuffer: make(chan jobqueue.Job, bufferSize),
-		sem:       make(chan struct{}, m.MaxWorkers),
-		limiter:   limiter,
-		logger:    logger,
+		jobqueue:   q,
+		kicker:     k,
+		worker:     w,
+		kick:       make(chan struct{}),
+		stop:       make(chan struct{}),
+		stopping:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+		jobBuffer:  make(chan jobqueue.Job, bufferSize),
+		sem:        make(chan struct{}, m.MaxWorkers),
+		limiter:    limiter,
+		pool:       pool.join(m.ReservedWorkers, m.WorkerWeight),
+		rateLimits: newRateLimits(m),
+		deferred:   newDeferredJobs(),
+		schedule:   schedule,
+		logger:     logger,
+	}
+	if m.AdaptiveWorkers {
+		d.adaptive = newAdaptiveConcurrency(
+			m.MinWorkers,
+			m.MaxWorkers,
+			time.Duration(m.TargetLatency)*time.Millisecond,
+		)
 	}
 	go d.loop()
 	k.Start(d)
@@ -101,16 +147,22 @@ func Start(q JobQueue, m *model.Queue) Dispatcher {
 }
 
 type dispatcher struct {
-	jobqueue  JobQueue
-	kicker    kicker.Kicker
-	worker    worker.Worker
-	kick      chan struct{}
-	stop      chan struct{}
-	stopped   chan struct{}
-	jobBuffer chan jobqueue.Job
-	sem       chan struct{}
-	limiter   *rate.Limiter
-	logger    zerolog.Logger
+	jobqueue   JobQueue
+	kicker     kicker.Kicker
+	worker     worker.Worker
+	kick       chan struct{}
+	stop       chan struct{}
+	stopping   chan struct{} // closed on stopping
+	stopped    chan struct{}
+	jobBuffer  chan jobqueue.Job
+	sem        chan struct{}
+	limiter    *rate.Limiter
+	adaptive   *adaptiveConcurrency
+	pool       *poolMember
+	rateLimits *rateLimits
+	deferred   *deferredJobs
+	schedule   *schedule
+	logger     zerolog.Logger
 }
 
 func (d *dispatcher) Kick() {
@@ -124,10 +176,33 @@ func (d *dispatcher) Ping() {
 func (d *dispatcher) Stats() *Stats {
 	runningWorkers := int64(len(d.sem))
 	totalWorkers := int64(cap(d.sem))
+	concurrencyLimit := totalWorkers
+	if d.adaptive != nil {
+		concurrencyLimit = int64(d.adaptive.current())
+	}
+	poolSize, poolRunning := d.pool.pool.Size()
+	var (
+		outsideWindow bool
+		nextWindowAt  *time.Time
+	)
+	if now := time.Now(); d.schedule != nil && !d.schedule.open(now) {
+		outsideWindow = true
+		if next := d.schedule.next(now); !next.IsZero() {
+			nextWindowAt = &next
+		}
+	}
 	return &Stats{
-		OutstandingJobs: int64(len(d.jobBuffer)),
-		TotalWorkers:    totalWorkers,
-		IdleWorkers:     totalWorkers - runningWorkers,
+		OutstandingJobs:    int64(len(d.jobBuffer) + d.deferred.count()),
+		DeferredJobs:       int64(d.deferred.count()),
+		TotalWorkers:       totalWorkers,
+		IdleWorkers:        totalWorkers - runningWorkers,
+		ConcurrencyLimit:   concurrencyLimit,
+		ReservedWorkers:    int64(d.pool.reserved),
+		BorrowedWorkers:    int64(d.pool.borrowed()),
+		PoolWorkers:        int64(poolSize),
+		PoolRunningWorkers: int64(poolRunning),
+		OutsideWindow:      outsideWindow,
+		NextWindowAt:       nextWindowAt,
 	}
 }
 
@@ -162,36 +237,162 @@ func (d *dispatcher) Stop() <-chan struct{} {
 
 func (d *dispatcher) loop() {
 	var wg sync.WaitGroup
-	ctx, cancel := context.WithCancel(context.Background())
 Loop:
 	for {
 		select {
 		case <-d.kick:
 			d.popJobs()
 		case <-d.stop:
-			cancel()
+			close(d.stopping)
+			// Deferred jobs are put back so that they are not left
+			// grabbed until they time out.
+			now := time.Now()
+			for _, j := range d.deferred.drain() {
+				var delay uint64
+				if j.due.After(now) {
+					delay = uint64(j.due.Sub(now) / time.Millisecond)
+				}
+				d.jobqueue.Defer(j.job, delay)
+			}
 			wg.Wait()
+			d.pool.leave()
 			break Loop
-		case job := <-d.jobBuffer:
-			wg.Add(1)
-			d.sem <- struct{}{}
-			go func(job jobqueue.Job) {
-				defer wg.Done()
-				defer func() { <-d.sem }()
-				err := d.limiter.Wait(ctx)
-				if err == nil {
-					rslt := d.worker.Work(job)
-					d.jobqueue.Complete(job, rslt)
+		case now := <-d.deferred.due():
+			for _, j := range d.deferred.pop(now) {
+				if !d.postpone(&wg, j.job, now) {
+					d.dispatch(&wg, j.job, j.queueReserved, j.keyReserved)
 				}
-			}(job)
+			}
+		case job := <-d.jobBuffer:
+			if !d.postpone(&wg, job, time.Now()) {
+				d.dispatch(&wg, job, false, false)
+			}
 		}
 	}
 	d.stopped <- struct{}{}
 }
 
+// maxPostponement is the delay of a job postponed by a schedule which
+// does not open within a week.
+const maxPostponement = 7 * 24 * time.Hour
+
+// postpone puts a job back into the queue until the next window if the
+// schedule is closed at now, and reports whether the job is postponed.
+// Jobs popped or deferred before the window closed are not dispatched
+// outside the window.
+func (d *dispatcher) postpone(wg *sync.WaitGroup, job jobqueue.Job, now time.Time) bool {
+	if d.schedule == nil || d.schedule.open(now) {
+		return false
+	}
+	delay := maxPostponement
+	if next := d.schedule.next(now); !next.IsZero() {
+		delay = next.Sub(now)
+	}
+	d.putBack(wg, job, delay)
+	return true
+}
+
+// putBack puts a job back into the queue to be grabbed after delay.
+func (d *dispatcher) putBack(wg *sync.WaitGroup, job jobqueue.Job, delay time.Duration) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.jobqueue.Defer(job, uint64(delay/time.Millisecond))
+	}()
+}
+
+// dispatch works on a job within the rate limits of the queue and the
+// key of the job, except those it has reserved already.  A job over
+// the limits is held until its reserved dispatch without taking a
+// worker, which other queues can use meanwhile, or put back into the
+// queue if it is to wait longer than maxDeferral.
+//
+// Only the reservation of the longest wait is held so that the token
+// of the other limit is not used up while the job waits; the other
+// limit is reserved again when the job gets due.
+func (d *dispatcher) dispatch(wg *sync.WaitGroup, job jobqueue.Job, queueReserved, keyReserved bool) {
+	now := time.Now()
+	var queue, key *rate.Reservation
+	if !queueReserved {
+		queue = d.limiter.ReserveN(now, 1)
+	}
+	if !keyReserved && d.rateLimits != nil {
+		key = d.rateLimits.reserve(job, now)
+	}
+	queueDelay, keyDelay := delayFrom(queue, now), delayFrom(key, now)
+
+	switch {
+	case queueDelay <= 0 && keyDelay <= 0:
+		d.work(wg, job)
+	case queueDelay > maxDeferral || keyDelay > maxDeferral:
+		cancelAt(queue, now)
+		cancelAt(key, now)
+		if keyDelay > queueDelay {
+			queueDelay = keyDelay
+		}
+		d.putBack(wg, job, queueDelay)
+	case queueDelay >= keyDelay:
+		cancelAt(key, now)
+		d.deferred.push(job, now.Add(queueDelay), true, keyReserved)
+	default:
+		cancelAt(queue, now)
+		d.deferred.push(job, now.Add(keyDelay), queueReserved, true)
+	}
+}
+
+func delayFrom(r *rate.Reservation, now time.Time) time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.DelayFrom(now)
+}
+
+func cancelAt(r *rate.Reservation, now time.Time) {
+	if r != nil {
+		r.CancelAt(now)
+	}
+}
+
+// work runs a job on a worker of the queue.  The job waits for a worker
+// of the pool in its own goroutine so that the loop goes on meanwhile,
+// and it is put back if the dispatcher stops before a worker is
+// granted.
+func (d *dispatcher) work(wg *sync.WaitGroup, job jobqueue.Job) {
+	wg.Add(1)
+	d.sem <- struct{}{}
+	if d.adaptive != nil {
+		d.adaptive.acquire()
+	}
+	go func(job jobqueue.Job) {
+		defer wg.Done()
+		defer func() { <-d.sem }()
+		if !d.pool.acquire(d.stopping) {
+			if d.adaptive != nil {
+				d.adaptive.release(time.Now(), nil)
+			}
+			d.jobqueue.Defer(job, 0)
+			return
+		}
+		defer d.pool.release()
+		started := time.Now()
+		rslt := d.worker.Work(job)
+		rslt.StartedAt = started
+		rslt.Elapsed = time.Since(started)
+		if d.adaptive != nil {
+			d.adaptive.release(started, rslt)
+		}
+		d.jobqueue.Complete(job, rslt)
+	}(job)
+}
+
 func (d *dispatcher) popJobs() {
-	if len(d.jobBuffer) < cap(d.jobBuffer) {
-		reqn := cap(d.jobBuffer) - len(d.jobBuffer)
+	if d.schedule != nil && !d.schedule.open(time.Now()) {
+		return
+	}
+	// Deferred jobs take room in the buffer so that they do not pile
+	// up.
+	if len(d.jobBuffer)+d.deferred.count() < cap(d.jobBuffer) {
+		reqn := cap(d.jobBuffer) - len(d.jobBuffer) - d.deferred.count()
 		jobs, err := d.jobqueue.Pop(uint(reqn))
 		if err != nil {
 			switch err.(type) {
@@ -217,12 +418,29 @@ func (d *dispatcher) popJobs() {
 type JobQueue interface {
 	Pop(limit uint) ([]jobqueue.Job, error)
 	Complete(job jobqueue.Job, res *jobqueue.Result)
+	Defer(job jobqueue.Job, delay uint64)
 	Name() string
 }
 
 // Stats contains statistics of a dispatcher.
 type Stats struct {
-	OutstandingJobs int64 `json:"outstanding_jobs"`
-	TotalWorkers    int64 `json:"total_workers"`
-	IdleWorkers     int64 `json:"idle_workers"`
+	OutstandingJobs  int64 `json:"outstanding_jobs"`
+	DeferredJobs     int64 `json:"deferred_jobs"` // held over their rate limits
+	TotalWorkers     int64 `json:"total_workers"`
+	IdleWorkers      int64 `json:"idle_workers"`
+	ConcurrencyLimit int64 `json:"concurrency_limit"`
+
+	// Workers of the queue in the worker budget of the node.
+	ReservedWorkers int64 `json:"reserved_workers"`
+	BorrowedWorkers int64 `json:"borrowed_workers"` // running beyond the reservation
+
+	// The worker budget of the node shared by all queues.  0 means no
+	// limit.
+	PoolWorkers        int64 `json:"pool_workers"`
+	PoolRunningWorkers int64 `json:"pool_running_workers"`
+
+	// Whether the queue is outside its dispatch windows, and the time
+	// when the next window opens if any.
+	OutsideWindow bool       `json:"outside_window"`
+	NextWindowAt  *time.Time `json:"next_window_at,omitempty"`
 }
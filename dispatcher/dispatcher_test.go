@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/coosir/middleman/dispatcher/kicker"
 	"github.com/coosir/middleman/dispatcher/worker"
@@ -235,7 +236,13 @@ func TestThrottling(t *testing.T) {
 	if len(jq.completed) != 1 || len(jq.jobs) != 0 {
 		t.Error("Jobs must be throttled")
 	}
+	if len(jq.deferred) != 14 {
+		t.Errorf("Jobs to wait longer than maxDeferral must be put back: %d", len(jq.deferred))
+	}
 	jq.Unlock()
+	if stats := d.Stats(); stats.PoolRunningWorkers != 0 {
+		t.Errorf("Throttled jobs must not hold workers of the pool: %+v", stats)
+	}
 
 	<-d.Stop()
 	if kicker.stopped != 1 {
@@ -407,10 +414,387 @@ func TestStats(t *testing.T) {
 	}
 }
 
+func TestAdaptiveConcurrency(t *testing.T) {
+	kicker := &dummyKicker{}
+
+	jobs := make([]jobqueue.Job, 0)
+	for i := 0; i < 15; i++ {
+		jobs = append(jobs, &job{fmt.Sprintf("%d", i)})
+	}
+	jq := &dummyJobQueue{jobs: jobs}
+
+	cfg := Config{
+		MinBufferSize: 10,
+		Kicker:        &dummyKickerConfig{instance: kicker},
+		Worker:        &dummyWorker{},
+	}
+	d := cfg.Start(jq, &model.Queue{MaxWorkers: 5, AdaptiveWorkers: true, MinWorkers: 2}).(*dispatcher)
+	defer func() { <-d.Stop() }()
+
+	if stats := d.Stats(); stats.ConcurrencyLimit != 2 || stats.TotalWorkers != 5 {
+		t.Errorf("Wrong initial concurrency limit: %d", stats.ConcurrencyLimit)
+	}
+
+	d.Kick()
+	time.Sleep(200 * time.Millisecond)
+	d.Kick()
+	time.Sleep(200 * time.Millisecond)
+
+	jq.Lock()
+	if len(jq.completed) != 15 {
+		t.Error("Queue must be popped on kicking")
+	}
+	jq.Unlock()
+
+	if limit := d.Stats().ConcurrencyLimit; limit <= 2 || limit > 5 {
+		t.Errorf("Concurrency limit should be increased on successes: %d", limit)
+	}
+}
+
+func TestAdaptiveConcurrencyLimit(t *testing.T) {
+	success := &jobqueue.Result{Status: jobqueue.ResultStatusSuccess, Code: 200}
+	failure := &jobqueue.Result{Status: jobqueue.ResultStatusInternalFailure}
+
+	c := newAdaptiveConcurrency(1, 4, 0)
+	if c.current() != 1 {
+		t.Errorf("Wrong initial limit: %d", c.current())
+	}
+
+	for i := 0; i < 20; i++ {
+		c.acquire()
+		c.release(time.Now(), success)
+	}
+	if c.current() != 4 {
+		t.Errorf("Limit should be increased up to the max: %d", c.current())
+	}
+
+	started := time.Now()
+	c.acquire()
+	c.acquire()
+	c.release(started, failure)
+	c.release(started, failure)
+	if c.current() != 2 {
+		t.Errorf("Limit should be decreased only once for concurrent failures: %d", c.current())
+	}
+
+	for i := 0; i < 3; i++ {
+		c.acquire()
+		c.release(time.Now(), failure)
+	}
+	if c.current() != 1 {
+		t.Errorf("Limit should not be decreased below the min: %d", c.current())
+	}
+
+	c.acquire()
+	c.release(time.Time{}, nil)
+	if c.current() != 1 {
+		t.Errorf("Limit should not be changed for undispatched jobs: %d", c.current())
+	}
+
+	slow := newAdaptiveConcurrency(1, 4, 1*time.Second)
+	for i := 0; i < 20; i++ {
+		slow.acquire()
+		slow.release(time.Now(), success)
+	}
+	slow.acquire()
+	slow.release(time.Now().Add(-2*time.Second), success)
+	if slow.current() != 2 {
+		t.Errorf("Limit should be decreased on a slow response: %d", slow.current())
+	}
+}
+
+func TestWorkInPool(t *testing.T) {
+	worker := &dummyBlockingWorker{make(chan struct{}, 1)}
+
+	kicker := &dummyKicker{}
+
+	totalJobs := 5
+	jobs := make([]jobqueue.Job, 0)
+	for i := 0; i < totalJobs; i++ {
+		jobs = append(jobs, &job{fmt.Sprintf("%d", i)})
+	}
+	jq := &dummyJobQueue{jobs: jobs}
+
+	cfg := Config{
+		MinBufferSize: 10,
+		Kicker:        &dummyKickerConfig{instance: kicker},
+		Worker:        worker,
+		Pool:          NewPool(2),
+	}
+	d := cfg.Start(jq, &model.Queue{MaxWorkers: 5, ReservedWorkers: 1}).(*dispatcher)
+	defer func() { <-d.Stop() }()
+
+	d.Kick()
+	time.Sleep(200 * time.Millisecond)
+
+	stats := d.Stats()
+	if stats.PoolWorkers != 2 || stats.PoolRunningWorkers != 2 {
+		t.Errorf("Workers should be limited by the pool: %+v", stats)
+	}
+	if stats.ReservedWorkers != 1 || stats.BorrowedWorkers != 1 {
+		t.Errorf("Workers beyond the reservation should be borrowed: %+v", stats)
+	}
+
+	for i := 0; i < totalJobs; i++ {
+		worker.Process()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	jq.Lock()
+	defer jq.Unlock()
+
+	if len(jq.completed) != totalJobs {
+		t.Errorf("All jobs should be completed in the pool: %d", len(jq.completed))
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	kicker := &dummyKicker{}
+
+	jobs := make([]jobqueue.Job, 0)
+	for i := 0; i < 4; i++ {
+		jobs = append(jobs, &hostJob{job{fmt.Sprintf("%d", i)}, "a.example.com"})
+	}
+	jobs = append(jobs, &hostJob{job{"4"}, "b.example.com"})
+	jq := &dummyJobQueue{jobs: jobs}
+
+	cfg := Config{
+		MinBufferSize: 10,
+		Kicker:        &dummyKickerConfig{instance: kicker},
+		Worker:        &dummyWorker{},
+	}
+	d := cfg.Start(jq, &model.Queue{
+		MaxWorkers:  1,
+		RateLimitBy: RateLimitByHost,
+		RateLimits: map[string]model.RateLimit{
+			"a.example.com": {MaxDispatchesPerSecond: 2, MaxBurstSize: 1},
+		},
+	}).(*dispatcher)
+	defer func() { <-d.Stop() }()
+
+	d.Kick()
+	time.Sleep(100 * time.Millisecond)
+
+	// Jobs over the limit do not block the others.
+	jq.Lock()
+	if len(jq.completed) != 2 || len(jq.deferred) != 1 {
+		t.Errorf("Jobs over the limit should be deferred: %d, %d", len(jq.completed), len(jq.deferred))
+	}
+	jq.Unlock()
+	if stats := d.Stats(); stats.DeferredJobs != 2 {
+		t.Errorf("Wrong deferred jobs: %+v", stats)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	jq.Lock()
+	defer jq.Unlock()
+	if len(jq.completed) != 4 {
+		t.Errorf("Deferred jobs should be dispatched in time: %d", len(jq.completed))
+	}
+}
+
+func TestRateLimitsSweep(t *testing.T) {
+	l := newRateLimits(&model.Queue{
+		RateLimitBy: RateLimitByHost,
+		RateLimits: map[string]model.RateLimit{
+			RateLimitAny: {MaxDispatchesPerSecond: 1, MaxBurstSize: 1},
+		},
+	})
+
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		l.reserve(&hostJob{job{""}, fmt.Sprintf("%d.example.com", i)}, now)
+		now = now.Add(100 * time.Millisecond)
+	}
+	// Only the hosts dispatched within the last second may be limited.
+	if len(l.limiters) > 2*minSweep {
+		t.Errorf("Idle limiters should be swept: %d", len(l.limiters))
+	}
+
+	busy := &hostJob{job{""}, "busy.example.com"}
+	l.reserve(busy, now)
+	for i := 0; i < 1000; i++ {
+		l.reserve(&hostJob{job{""}, fmt.Sprintf("%d.example.org", i)}, now)
+	}
+	if delay := l.reserve(busy, now).DelayFrom(now); delay <= 0 {
+		t.Errorf("A limiter in use should not be swept: %s", delay)
+	}
+}
+
+func TestDispatchWindows(t *testing.T) {
+	kicker := &dummyKicker{}
+	jq := &dummyJobQueue{jobs: []jobqueue.Job{&job{"0"}}}
+
+	cfg := Config{
+		Kicker: &dummyKickerConfig{instance: kicker},
+		Worker: &dummyWorker{},
+	}
+	d := cfg.Start(jq, &model.Queue{
+		MaxWorkers:      1,
+		BlackoutWindows: []model.DispatchWindow{{Start: "00:00", End: "24:00"}},
+	}).(*dispatcher)
+	defer func() { <-d.Stop() }()
+
+	d.Kick()
+	time.Sleep(100 * time.Millisecond)
+
+	jq.Lock()
+	if len(jq.jobs) != 1 || len(jq.completed) != 0 {
+		t.Errorf("Jobs should not be popped outside windows: %d, %d", len(jq.jobs), len(jq.completed))
+	}
+	jq.Unlock()
+	if stats := d.Stats(); !stats.OutsideWindow || stats.NextWindowAt != nil {
+		t.Errorf("Wrong stats outside windows: %+v", stats)
+	}
+
+	// Jobs popped before the window closed are put back.
+	d.jobBuffer <- &job{"1"}
+	d.jobBuffer <- &job{"2"}
+	time.Sleep(100 * time.Millisecond)
+
+	jq.Lock()
+	defer jq.Unlock()
+	if len(jq.deferred) != 2 || len(jq.completed) != 0 {
+		t.Errorf("Buffered jobs should be put back outside windows: %d, %d", len(jq.deferred), len(jq.completed))
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s, err := newSchedule(&model.Queue{
+		DispatchTimezone: "Asia/Tokyo",
+		DispatchWindows: []model.DispatchWindow{
+			{Weekdays: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"},
+			{Weekdays: []string{"sat"}, Start: "22:00", End: "02:00"},
+		},
+		BlackoutWindows: []model.DispatchWindow{
+			{Weekdays: []string{"wed"}, Start: "12:00", End: "13:30"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	at := func(day, hour, min int) time.Time {
+		// 2024-01-01 is a Monday.
+		return time.Date(2024, 1, day, hour, min, 0, 0, loc).UTC()
+	}
+	for _, c := range []struct {
+		now  time.Time
+		open bool
+		next time.Time
+	}{
+		{at(1, 8, 59), false, at(1, 9, 0)},
+		{at(1, 9, 0), true, time.Time{}},
+		{at(1, 17, 0), false, at(2, 9, 0)},
+		{at(3, 12, 0), false, at(3, 13, 30)},
+		{at(5, 18, 0), false, at(6, 22, 0)},
+		{at(7, 1, 59), true, time.Time{}},
+		{at(7, 2, 0), false, at(8, 9, 0)},
+	} {
+		if s.open(c.now) != c.open {
+			t.Errorf("Wrong window at %s: %v", c.now, !c.open)
+		}
+		if !c.open && !s.next(c.now).Equal(c.next) {
+			t.Errorf("Wrong next window at %s: %s", c.now, s.next(c.now))
+		}
+	}
+
+	for _, w := range []model.DispatchWindow{
+		{Weekdays: []string{"someday"}, Start: "09:00", End: "17:00"},
+		{Start: "9", End: "17:00"},
+		{Start: "09:00", End: "24:01"},
+		{Start: "24:00", End: "09:00"},
+		{Start: "09:00", End: "09:00"},
+	} {
+		if err := ValidateSchedule(&model.Queue{DispatchWindows: []model.DispatchWindow{w}}); err == nil {
+			t.Errorf("Invalid window should be rejected: %+v", w)
+		}
+	}
+	if err := ValidateSchedule(&model.Queue{
+		DispatchTimezone: "Nowhere/Unknown",
+		DispatchWindows:  []model.DispatchWindow{{Start: "09:00", End: "17:00"}},
+	}); err == nil {
+		t.Error("Unknown timezone should be rejected")
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := NewPool(3)
+	reserved := p.join(1, 1)
+	light := p.join(0, 1)
+	heavy := p.join(0, 2)
+
+	granted := make(chan *poolMember, 3)
+	wait := func(m *poolMember, cancel <-chan struct{}) {
+		go func() {
+			if m.acquire(cancel) {
+				granted <- m
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	waiting := func() bool {
+		select {
+		case m := <-granted:
+			granted <- m
+			return false
+		default:
+			return true
+		}
+	}
+
+	// Workers reserved for a queue are not borrowed by others.
+	light.acquire(nil)
+	heavy.acquire(nil)
+	wait(light, nil)
+	wait(heavy, nil)
+	if !waiting() {
+		t.Error("Reserved workers should not be borrowed")
+	}
+	wait(reserved, nil)
+	if m := <-granted; m != reserved {
+		t.Error("A queue below its reservation should be granted")
+	}
+	reserved.release()
+	if !waiting() {
+		t.Error("Reserved workers should not be borrowed even if idle")
+	}
+
+	p.Resize(4)
+	if m := <-granted; m != heavy {
+		t.Error("A queue borrowing fewer workers for its weight should be granted first")
+	}
+	heavy.release()
+	if m := <-granted; m != light {
+		t.Error("A waiting queue should be granted on releasing a worker")
+	}
+	if heavy.borrowed() != 1 || light.borrowed() != 2 || reserved.borrowed() != 0 {
+		t.Errorf("Wrong borrowed workers: %d, %d, %d", heavy.borrowed(), light.borrowed(), reserved.borrowed())
+	}
+
+	cancel := make(chan struct{})
+	wait(light, cancel)
+	close(cancel)
+	time.Sleep(10 * time.Millisecond)
+	p.mu.Lock()
+	if p.running != 3 || len(light.waiters) != 0 {
+		t.Errorf("A canceled acquisition should not be granted: %d, %d", p.running, len(light.waiters))
+	}
+	p.mu.Unlock()
+
+	reserved.leave()
+	if len(p.members) != 2 {
+		t.Errorf("A queue should leave the pool: %d", len(p.members))
+	}
+}
+
 type dummyJobQueue struct {
 	sync.Mutex
 	jobs      []jobqueue.Job
 	completed []jobqueue.Result
+	deferred  []jobqueue.Job
 }
 
 func (jq *dummyJobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
@@ -435,6 +819,13 @@ func (jq *dummyJobQueue) Complete(job jobqueue.Job, res *jobqueue.Result) {
 	jq.completed = append(jq.completed, *res)
 }
 
+func (jq *dummyJobQueue) Defer(job jobqueue.Job, delay uint64) {
+	jq.Lock()
+	defer jq.Unlock()
+
+	jq.deferred = append(jq.deferred, job)
+}
+
 func (jq *dummyJobQueue) Name() string { return "dummy" }
 
 type errorJobQueue struct {
@@ -452,6 +843,8 @@ func (jq *errorJobQueue) Complete(job jobqueue.Job, res *jobqueue.Result) {
 	atomic.AddInt64(&jq.completed, 1)
 }
 
+func (jq *errorJobQueue) Defer(job jobqueue.Job, delay uint64) {}
+
 type brokenJobQueue struct {
 	dummyJobQueue
 }
@@ -528,3 +921,10 @@ func (j *job) RetryDelay() uint               { return 0 }
 func (j *job) FailCount() uint                { return 0 }
 func (j *job) Timeout() uint                  { return 0 }
 func (j *job) ToLoggable() logger.LoggableJob { return nil }
+
+type hostJob struct {
+	job
+	host string
+}
+
+func (j *hostJob) URL() string { return "http://" + j.host + "/" }
@@ -0,0 +1,219 @@
+package dispatcher
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/coosir/middleman/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultPool is the pool of workers shared by dispatchers started
+// without their own pool.
+var defaultPool = NewPool(0)
+
+// Pool is a budget of workers shared by dispatchers of all queues on a
+// node.
+//
+// Each queue is guaranteed its reserved workers and borrows workers
+// beyond them which are neither running nor reserved for the other
+// queues.  When queues are waiting for workers, a freed worker goes to
+// a queue below its reservation first, and then to the queue borrowing
+// the fewest workers for its weight.  Workers are never taken back
+// from running jobs.
+type Pool struct {
+	mu      sync.Mutex
+	size    uint // 0 means no limit
+	running uint
+	members []*poolMember
+}
+
+// NewPool creates a pool of size workers.  0 means no limit.
+func NewPool(size uint) *Pool {
+	return &Pool{size: size}
+}
+
+// Resize changes the number of workers of the pool.  Workers beyond the
+// new size are not taken back from running jobs.
+func (p *Pool) Resize(size uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size = size
+	p.warnOversubscription()
+	p.grant()
+}
+
+// Size returns the number of workers of the pool and the number of
+// running ones among them.
+func (p *Pool) Size() (size, running uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size, p.running
+}
+
+func (p *Pool) join(reserved, weight uint) *poolMember {
+	if weight == 0 {
+		weight = 1
+	}
+	m := &poolMember{
+		pool:     p,
+		reserved: reserved,
+		weight:   weight,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.members = append(p.members, m)
+	p.warnOversubscription()
+	return m
+}
+
+func (p *Pool) warnOversubscription() {
+	if p.size == 0 {
+		return
+	}
+	var reserved uint
+	for _, m := range p.members {
+		reserved += m.reserved
+	}
+	if reserved > p.size {
+		log.Warn().Msgf("Reserved workers %d exceed the worker budget %d of the node", reserved, p.size)
+	}
+}
+
+// grant gives free workers to waiting members.
+func (p *Pool) grant() {
+	for p.size == 0 || p.running < p.size {
+		var next *poolMember
+		for _, m := range p.members {
+			if len(m.waiters) > 0 && (next == nil || m.borrowing() < next.borrowing()) {
+				next = m
+			}
+		}
+		if next == nil {
+			return
+		}
+		if next.running >= next.reserved && p.size > 0 && p.running+p.unreserved() >= p.size {
+			// The free workers are reserved for the other members.
+			return
+		}
+		granted := next.waiters[0]
+		next.waiters = next.waiters[1:]
+		next.running++
+		p.running++
+		granted <- struct{}{}
+	}
+}
+
+// unreserved returns the number of reserved workers which are not
+// running.
+func (p *Pool) unreserved() uint {
+	var n uint
+	for _, m := range p.members {
+		if m.running < m.reserved {
+			n += m.reserved - m.running
+		}
+	}
+	return n
+}
+
+// poolMember is a share of a pool used by a dispatcher.
+type poolMember struct {
+	pool     *Pool
+	reserved uint
+	weight   uint
+	running  uint
+	waiters  []chan struct{} // to be granted workers in order
+}
+
+// borrowing returns how many workers the member borrows for its
+// weight, which is negative while it is below its reservation.
+func (m *poolMember) borrowing() float64 {
+	if m.running < m.reserved {
+		return float64(m.running)/float64(m.reserved) - 1
+	}
+	return float64(m.running-m.reserved) / float64(m.weight)
+}
+
+// acquire waits until a worker of the pool is granted and reports
+// whether it is granted before cancel is closed.
+func (m *poolMember) acquire(cancel <-chan struct{}) bool {
+	granted := make(chan struct{}, 1)
+	p := m.pool
+	p.mu.Lock()
+	m.waiters = append(m.waiters, granted)
+	p.grant()
+	p.mu.Unlock()
+
+	select {
+	case <-granted:
+		return true
+	case <-cancel:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, w := range m.waiters {
+		if w == granted {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return false
+		}
+	}
+	// granted meanwhile
+	m.running--
+	p.running--
+	p.grant()
+	return false
+}
+
+// release returns a worker to the pool.
+func (m *poolMember) release() {
+	p := m.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m.running--
+	p.running--
+	p.grant()
+}
+
+// borrowed returns the number of workers running beyond the
+// reservation.
+func (m *poolMember) borrowed() uint {
+	p := m.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if m.running < m.reserved {
+		return 0
+	}
+	return m.running - m.reserved
+}
+
+// leave removes the member from the pool after all its workers are
+// released.
+func (m *poolMember) leave() {
+	p := m.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, x := range p.members {
+		if x == m {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			break
+		}
+	}
+}
+
+func poolSize() (uint, error) {
+	size, err := strconv.ParseUint(config.Get("dispatch_max_workers"), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid dispatch_max_workers: %s", err)
+	}
+	return uint(size), nil
+}
@@ -0,0 +1,214 @@
+package dispatcher
+
+import (
+	"container/heap"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/coosir/middleman/jobqueue"
+	"github.com/coosir/middleman/model"
+
+	"golang.org/x/time/rate"
+)
+
+// Keys of rate limits of a queue.
+const (
+	RateLimitByCategory = "category" // limit by job categories
+	RateLimitByHost     = "host"     // limit by destination hosts
+	RateLimitAny        = "*"        // limit each key without its own limit
+)
+
+// maxDeferral is the longest time for which a dispatcher holds a job
+// over its rate limit.  A job to wait longer is put back into the
+// queue so that it does not occupy the buffer.
+const maxDeferral = 1 * time.Second
+
+// minSweep is the number of limiters of keys above which idle ones
+// are swept.
+const minSweep = 64
+
+// rateLimits limits dispatches of jobs by their keys.  It is used only
+// in the loop of a dispatcher.
+//
+// Limiters whose buckets are full again behave the same as new ones, so
+// they are dropped whenever the number of limiters doubles so that keys
+// without their own limits do not pile up.
+type rateLimits struct {
+	by       string
+	limits   map[string]model.RateLimit
+	limiters map[string]*keyLimiter
+	sweepAt  int
+}
+
+type keyLimiter struct {
+	*rate.Limiter
+	full time.Time // by which the bucket gets full again
+}
+
+func newRateLimits(m *model.Queue) *rateLimits {
+	if len(m.RateLimits) == 0 {
+		return nil
+	}
+	by := m.RateLimitBy
+	if by == "" {
+		by = RateLimitByCategory
+	}
+	return &rateLimits{
+		by:       by,
+		limits:   m.RateLimits,
+		limiters: make(map[string]*keyLimiter),
+		sweepAt:  minSweep,
+	}
+}
+
+func (l *rateLimits) key(job jobqueue.Job) string {
+	if l.by == RateLimitByHost {
+		u, err := url.Parse(job.URL())
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	return job.ToLoggable().Category()
+}
+
+// reserve reserves a dispatch of a job within the limit of its key, or
+// returns nil if the key is not limited.
+func (l *rateLimits) reserve(job jobqueue.Job, now time.Time) *rate.Reservation {
+	key := l.key(job)
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limit, ok := l.limits[key]
+		if !ok {
+			limit, ok = l.limits[RateLimitAny]
+		}
+		if !ok {
+			return nil
+		}
+		if len(l.limiters) >= l.sweepAt {
+			l.sweep(now)
+		}
+		limiter = &keyLimiter{
+			Limiter: rate.NewLimiter(rate.Limit(limit.MaxDispatchesPerSecond), int(limit.MaxBurstSize)),
+		}
+		l.limiters[key] = limiter
+	}
+
+	r := limiter.ReserveN(now, 1)
+	// The bucket gets full again at the latest by refilling the whole
+	// burst after the reserved dispatch.
+	refill := float64(limiter.Burst()) / float64(limiter.Limit())
+	limiter.full = now.Add(r.DelayFrom(now) + time.Duration(refill*float64(time.Second)))
+	return r
+}
+
+// sweep drops the limiters whose buckets are full at now.
+func (l *rateLimits) sweep(now time.Time) {
+	for key, limiter := range l.limiters {
+		if !limiter.full.After(now) {
+			delete(l.limiters, key)
+		}
+	}
+	l.sweepAt = 2 * len(l.limiters)
+	if l.sweepAt < minSweep {
+		l.sweepAt = minSweep
+	}
+}
+
+type deferredJob struct {
+	job jobqueue.Job
+	due time.Time
+	// reserved dispatches within the rate limits of the queue and the
+	// key of the job
+	queueReserved bool
+	keyReserved   bool
+}
+
+// deferredJobs is jobs held by a dispatcher until their reserved
+// dispatches.  It is used only in the loop of a dispatcher except for
+// count().
+type deferredJobs struct {
+	jobs  deferredHeap
+	timer *time.Timer
+	n     int64
+}
+
+func newDeferredJobs() *deferredJobs {
+	timer := time.NewTimer(0)
+	<-timer.C
+	return &deferredJobs{timer: timer}
+}
+
+func (q *deferredJobs) push(job jobqueue.Job, due time.Time, queueReserved, keyReserved bool) {
+	heap.Push(&q.jobs, &deferredJob{job, due, queueReserved, keyReserved})
+	atomic.StoreInt64(&q.n, int64(len(q.jobs)))
+	if q.jobs[0].job == job {
+		q.reset()
+	}
+}
+
+// due returns a channel which receives when the earliest job gets due
+// or nil if there is no job.
+func (q *deferredJobs) due() <-chan time.Time {
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	return q.timer.C
+}
+
+// pop removes the jobs due at now.  It should be called after due()
+// receives.
+func (q *deferredJobs) pop(now time.Time) []*deferredJob {
+	var jobs []*deferredJob
+	for len(q.jobs) > 0 && !q.jobs[0].due.After(now) {
+		jobs = append(jobs, heap.Pop(&q.jobs).(*deferredJob))
+	}
+	atomic.StoreInt64(&q.n, int64(len(q.jobs)))
+	if len(q.jobs) > 0 {
+		q.timer.Reset(time.Until(q.jobs[0].due))
+	}
+	return jobs
+}
+
+// drain removes all the jobs.
+func (q *deferredJobs) drain() []*deferredJob {
+	jobs := q.jobs
+	q.jobs = nil
+	atomic.StoreInt64(&q.n, 0)
+	q.timer.Stop()
+	return jobs
+}
+
+func (q *deferredJobs) reset() {
+	if !q.timer.Stop() {
+		select {
+		case <-q.timer.C:
+		default:
+		}
+	}
+	q.timer.Reset(time.Until(q.jobs[0].due))
+}
+
+// count returns the number of jobs.  This method is goroutine safe.
+func (q *deferredJobs) count() int {
+	return int(atomic.LoadInt64(&q.n))
+}
+
+type deferredHeap []*deferredJob
+
+func (h deferredHeap) Len() int           { return len(h) }
+func (h deferredHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h deferredHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *deferredHeap) Push(x interface{}) {
+	*h = append(*h, x.(*deferredJob))
+}
+
+func (h *deferredHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
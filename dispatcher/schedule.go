@@ -0,0 +1,177 @@
+package dispatcher
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coosir/middleman/model"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// window is a weekly range of time in minutes from midnight.  A window
+// whose end is before its start spans midnight, and belongs to the
+// weekday on which it starts.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+func parseWindow(w model.DispatchWindow) (window, error) {
+	var parsed window
+	if len(w.Weekdays) == 0 {
+		for i := range parsed.days {
+			parsed.days[i] = true
+		}
+	}
+	for _, day := range w.Weekdays {
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return parsed, fmt.Errorf("Unknown weekday: %s", day)
+		}
+		parsed.days[wd] = true
+	}
+
+	var err error
+	if parsed.start, err = parseClock(w.Start); err != nil || parsed.start == 24*60 {
+		return parsed, fmt.Errorf("Invalid start of a window: %q", w.Start)
+	}
+	if parsed.end, err = parseClock(w.End); err != nil {
+		return parsed, fmt.Errorf("Invalid end of a window: %q", w.End)
+	}
+	if parsed.start == parsed.end {
+		return parsed, fmt.Errorf("Empty window: %s-%s", w.Start, w.End)
+	}
+	return parsed, nil
+}
+
+// parseClock parses HH:MM from 00:00 to 24:00 into minutes.
+func parseClock(s string) (int, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return 0, fmt.Errorf("Invalid time: %s", s)
+	}
+	h, err := strconv.ParseUint(s[:i], 10, 8)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.ParseUint(s[i+1:], 10, 8)
+	if err != nil {
+		return 0, err
+	}
+	if m >= 60 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("Invalid time: %s", s)
+	}
+	return int(h*60 + m), nil
+}
+
+// contains reports whether t, in the location of the schedule, is in
+// the window.
+func (w window) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && w.start <= m && m < w.end
+	}
+	return (w.days[day] && w.start <= m) || (w.days[(day+6)%7] && m < w.end)
+}
+
+// schedule is the dispatch windows of a queue.  It is immutable and
+// goroutine safe.
+type schedule struct {
+	loc       *time.Location
+	windows   []window
+	blackouts []window
+}
+
+func newSchedule(m *model.Queue) (*schedule, error) {
+	if len(m.DispatchWindows) == 0 && len(m.BlackoutWindows) == 0 {
+		return nil, nil
+	}
+
+	loc, err := time.LoadLocation(m.DispatchTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DispatchTimezone: %s", err)
+	}
+	s := &schedule{loc: loc}
+	for _, w := range m.DispatchWindows {
+		parsed, err := parseWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid DispatchWindows: %s", err)
+		}
+		s.windows = append(s.windows, parsed)
+	}
+	for _, w := range m.BlackoutWindows {
+		parsed, err := parseWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid BlackoutWindows: %s", err)
+		}
+		s.blackouts = append(s.blackouts, parsed)
+	}
+	return s, nil
+}
+
+// ValidateSchedule validates the dispatch windows of a queue.
+func ValidateSchedule(m *model.Queue) error {
+	_, err := newSchedule(m)
+	return err
+}
+
+// open reports whether jobs are dispatched at now.
+func (s *schedule) open(now time.Time) bool {
+	t := now.In(s.loc)
+	for _, b := range s.blackouts {
+		if b.contains(t) {
+			return false
+		}
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the time when the schedule opens next after now, or the
+// zero time if it does not open within a week.
+func (s *schedule) next(now time.Time) time.Time {
+	t := now.In(s.loc)
+
+	// The schedule opens at the start of a window or at the end of a
+	// blackout.
+	var candidates []time.Time
+	at := func(day, minutes int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+day, minutes/60, minutes%60, 0, 0, s.loc)
+	}
+	for day := 0; day <= 7; day++ {
+		for _, w := range s.windows {
+			candidates = append(candidates, at(day, w.start))
+		}
+		for _, b := range s.blackouts {
+			candidates = append(candidates, at(day, b.end))
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	for _, c := range candidates {
+		if c.After(now) && s.open(c) {
+			return c
+		}
+	}
+	return time.Time{}
+}
@@ -1,28 +1,129 @@
 package worker
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/coosir/middleman/allowlist"
 	"github.com/coosir/middleman/config"
 	"github.com/coosir/middleman/jobqueue"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
-var defaultUserAgent string
+// HTTP settings shared by workers, which are replaced as a whole by
+// HTTPInit() or HTTPReload().
+type httpSettings struct {
+	transport *http.Transport // for jobs without their own networks
+	userAgent string
+	allowlist *allowlist.Allowlist
+
+	// Transports are separated by the networks allowed for jobs so that
+	// a connection is reused only by the jobs allowed to connect to it.
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+type transportKey struct {
+	insecure bool   // skips verifying servers
+	networks string // allowed for jobs in addition to the global allowlist
+}
+
+var (
+	shared atomic.Value // *httpSettings
+
+	// settings used before HTTPInit() is called
+	defaultSettings = newHTTPSettings(http.DefaultTransport.(*http.Transport), "", nil)
+)
+
+func sharedSettings() *httpSettings {
+	if s, ok := shared.Load().(*httpSettings); ok {
+		return s
+	}
+	return defaultSettings
+}
+
+func newHTTPSettings(transport *http.Transport, userAgent string, global *allowlist.Allowlist) *httpSettings {
+	return &httpSettings{
+		transport:  transport,
+		userAgent:  userAgent,
+		allowlist:  global,
+		transports: map[transportKey]*http.Transport{{}: transport},
+	}
+}
+
+// transportFor returns the transport for a job allowed to connect to
+// the networks of local, creating it on the first use.
+func (s *httpSettings) transportFor(insecure bool, local *allowlist.Allowlist, networks []string) *http.Transport {
+	key := transportKey{insecure: insecure}
+	if local.RestrictsIPs() {
+		key.networks = strings.Join(networks, ",")
+	} else {
+		local = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.transports[key]; ok {
+		return t
+	}
+	t := s.transport.Clone()
+	if insecure {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+	if local != nil {
+		setDialer(t, s.allowlist, local)
+	}
+	s.transports[key] = t
+	return t
+}
+
+// closeIdleConnections closes idle connections of all the transports.
+func (s *httpSettings) closeIdleConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.transports {
+		t.CloseIdleConnections()
+	}
+}
 
 // HTTPInit initializes global parameters of HTTP workers by
 // configuration values.
 //
 // Configuration keys prefixed by "dispatch_" are considered.
 func HTTPInit() {
-	transport := http.DefaultTransport.(*http.Transport)
+	if err := HTTPReload(); err != nil {
+		log.Panic().Msg(err.Error())
+	}
+}
+
+// HTTPReload replaces global parameters of HTTP workers by the current
+// configuration values.  Running workers use them from their next
+// requests.  If the values are invalid, an error is returned and
+// nothing is changed.
+//
+// This function is goroutine safe.
+func HTTPReload() error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.MaxIdleConns = 0
 
 	b, err := strconv.ParseBool(config.Get("dispatch_keep_alive"))
@@ -43,14 +144,95 @@ func HTTPInit() {
 	}
 	transport.IdleConnTimeout = time.Duration(v) * time.Second
 
-	defaultUserAgent = config.Get("dispatch_user_agent")
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	global, err := allowlist.FromConfig()
+	if err != nil {
+		return err
+	}
+	setDialer(transport, global, nil)
+
+	old := sharedSettings()
+	shared.Store(newHTTPSettings(transport, config.Get("dispatch_user_agent"), global))
+	old.closeIdleConnections()
+	return nil
+}
+
+// newTLSConfig creates a TLS configuration for connections to workers
+// from "dispatch_tls_" configuration values.
+func newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if caFile := config.Get("dispatch_tls_ca_file"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile := config.Get("dispatch_tls_cert_file")
+	keyFile := config.Get("dispatch_tls_key_file")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// setDialer makes a transport connect to workers only at the addresses
+// allowed by both the global allowlist and the allowlist of jobs.  The
+// addresses are checked after resolving host names so that DNS cannot
+// bypass the allowlists, and no proxy is used if they restrict the
+// addresses, which would otherwise be checked instead of the workers.
+func setDialer(transport *http.Transport, global, local *allowlist.Allowlist) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if err := global.CheckIP(ip); err != nil {
+				return &deniedError{err}
+			}
+			if err := local.CheckIP(ip); err != nil {
+				return &deniedError{err}
+			}
+			return nil
+		},
+	}
+	transport.DialContext = dialer.DialContext
+	if global.RestrictsIPs() || local.RestrictsIPs() {
+		transport.Proxy = nil
+	}
 }
 
 // HTTPWorker is a worker which handles a job as an HTTP POST request
 // to the URL specified by the job.
 type HTTPWorker struct {
-	UserAgent string
-	Logger    *zerolog.Logger
+	UserAgent          string
+	Logger             *zerolog.Logger
+	InsecureSkipVerify bool
+	StatusOnly         bool // decides the result only by the status code
 }
 
 // NewWorker creates a new HTTP worker instance which inherits the
@@ -58,10 +240,6 @@ type HTTPWorker struct {
 func (worker *HTTPWorker) NewWorker() Worker {
 	w := *worker
 
-	if w.UserAgent == "" {
-		w.UserAgent = defaultUserAgent
-	}
-
 	if w.Logger == nil {
 		logger := zerolog.Nop()
 		w.Logger = &logger
@@ -72,8 +250,37 @@ func (worker *HTTPWorker) NewWorker() Worker {
 
 // Work makes a POST request to job.URL and returns the result.
 func (worker *HTTPWorker) Work(job jobqueue.Job) *jobqueue.Result {
+	settings := sharedSettings()
+	metadata := jobqueue.MetadataOf(job)
+	var local *allowlist.Allowlist
+	var networks []string
+	if metadata != nil {
+		var err error
+		if local, err = allowlist.New(metadata.Allowlist); err != nil {
+			return &jobqueue.Result{
+				Status:  jobqueue.ResultStatusPermanentFailure,
+				Message: fmt.Sprintf("Invalid allowlist: %v", err),
+			}
+		}
+		if metadata.Allowlist != nil {
+			networks = metadata.Allowlist.Networks
+		}
+	}
 	client := &http.Client{
-		Timeout: time.Duration(job.Timeout()) * time.Second,
+		Transport: settings.transportFor(worker.InsecureSkipVerify, local, networks),
+		Timeout:   time.Duration(job.Timeout()) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return checkURL(req.URL.String(), settings.allowlist, local)
+		},
+	}
+	if err := checkURL(job.URL(), settings.allowlist, local); err != nil {
+		return &jobqueue.Result{
+			Status:  jobqueue.ResultStatusPermanentFailure,
+			Message: fmt.Sprintf("Request denied: %v", err),
+		}
 	}
 	req, err := http.NewRequest(
 		"POST",
@@ -90,9 +297,10 @@ func (worker *HTTPWorker) Work(job jobqueue.Job) *jobqueue.Result {
 
 	userAgent := worker.UserAgent
 	if userAgent == "" {
-		userAgent = defaultUserAgent
+		userAgent = settings.userAgent
 	}
 	req.Header.Add("User-Agent", userAgent)
+	setTraceContext(req.Header, metadata)
 
 	resp, err := client.Do(req)
 
@@ -103,6 +311,14 @@ func (worker *HTTPWorker) Work(job jobqueue.Job) *jobqueue.Result {
 		Str("payload", job.Payload()).
 		Msg("Dispatched via HTTP")
 
+	var denied *deniedError
+	if errors.As(err, &denied) {
+		// Retrying never helps, and the worker is not overloaded.
+		return &jobqueue.Result{
+			Status:  jobqueue.ResultStatusPermanentFailure,
+			Message: fmt.Sprintf("Request denied: %v", denied),
+		}
+	}
 	if err != nil {
 		return &jobqueue.Result{
 			Status:  jobqueue.ResultStatusInternalFailure,
@@ -111,6 +327,10 @@ func (worker *HTTPWorker) Work(job jobqueue.Job) *jobqueue.Result {
 	}
 	defer resp.Body.Close()
 
+	if worker.StatusOnly {
+		return resultOfStatus(resp)
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return &jobqueue.Result{
@@ -145,3 +365,67 @@ func (worker *HTTPWorker) Work(job jobqueue.Job) *jobqueue.Result {
 	rslt.Code = resp.StatusCode
 	return &rslt
 }
+
+// The maximum number of redirects followed by a request, which is the
+// same as the default of net/http.
+const maxRedirects = 10
+
+// checkURL returns a *deniedError if the allowlists do not allow a URL.
+// An invalid URL is left to fail the request.
+func checkURL(rawurl string, allowlists ...*allowlist.Allowlist) error {
+	if u, err := url.Parse(rawurl); err != nil || !u.IsAbs() {
+		return nil
+	}
+	for _, a := range allowlists {
+		if err := a.CheckURL(rawurl); err != nil {
+			return &deniedError{err}
+		}
+	}
+	return nil
+}
+
+// deniedError is an error of a request to a destination which is not
+// allowed by the allowlists.
+type deniedError struct {
+	err error
+}
+
+func (e *deniedError) Error() string {
+	return e.err.Error()
+}
+
+// setTraceContext propagates the tracing context of the request which
+// pushed the job.
+func setTraceContext(header http.Header, metadata *jobqueue.Metadata) {
+	if metadata == nil {
+		return
+	}
+	if metadata.TraceParent != "" {
+		header.Set("traceparent", metadata.TraceParent)
+		if metadata.TraceState != "" {
+			header.Set("tracestate", metadata.TraceState)
+		}
+	}
+	if metadata.RequestID != "" {
+		header.Set("X-Request-Id", metadata.RequestID)
+	}
+}
+
+// resultOfStatus decides the result of a request only by its status
+// code.  A client error other than a timeout or throttling is
+// considered permanent.
+func resultOfStatus(resp *http.Response) *jobqueue.Result {
+	status := jobqueue.ResultStatusFailure
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		status = jobqueue.ResultStatusSuccess
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests:
+	case code >= 400 && code < 500:
+		status = jobqueue.ResultStatusPermanentFailure
+	}
+	return &jobqueue.Result{
+		Status:  status,
+		Code:    resp.StatusCode,
+		Message: resp.Status,
+	}
+}
@@ -1,16 +1,19 @@
 package worker
 
 import (
+	"encoding/pem"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/coosir/middleman/config"
 	"github.com/coosir/middleman/jobqueue"
 	"github.com/coosir/middleman/jobqueue/logger"
+	"github.com/coosir/middleman/model"
 )
 
 func TestMain(m *testing.M) {
@@ -22,7 +25,7 @@ func TestHTTPInit(t *testing.T) {
 	config.Locally("dispatch_max_conns_per_host", "foo", func() {
 		HTTPInit()
 
-		transport := http.DefaultTransport.(*http.Transport)
+		transport := sharedSettings().transport
 		if transport.MaxIdleConnsPerHost != 10 {
 			t.Error("Not set a default value to MaxIdleConnsPerHost")
 		}
@@ -31,7 +34,7 @@ func TestHTTPInit(t *testing.T) {
 	config.Locally("dispatch_max_conns_per_host", "1000", func() {
 		HTTPInit()
 
-		transport := http.DefaultTransport.(*http.Transport)
+		transport := sharedSettings().transport
 		if transport.MaxIdleConnsPerHost != 1000 {
 			t.Error("Not set to MaxIdleConnsPerHost")
 		}
@@ -40,7 +43,7 @@ func TestHTTPInit(t *testing.T) {
 	config.Locally("dispatch_idle_conn_timeout", "foo", func() {
 		HTTPInit()
 
-		transport := http.DefaultTransport.(*http.Transport)
+		transport := sharedSettings().transport
 		if transport.IdleConnTimeout != 0 {
 			t.Error("Not set a default value to IdleConnTimeout")
 		}
@@ -49,7 +52,7 @@ func TestHTTPInit(t *testing.T) {
 	config.Locally("dispatch_idle_conn_timeout", "10", func() {
 		HTTPInit()
 
-		transport := http.DefaultTransport.(*http.Transport)
+		transport := sharedSettings().transport
 		if transport.IdleConnTimeout != 10*time.Second {
 			t.Error("Not set to IdleConnTimeout")
 		}
@@ -275,6 +278,175 @@ func TestWork(t *testing.T) {
 	}()
 }
 
+func TestWorkTLS(t *testing.T) {
+	w := &testWorker{request: make(chan struct{}, 1)}
+	server := httptest.NewTLSServer(w)
+	defer server.Close()
+
+	payload := `{"status":"success"}`
+
+	func() {
+		rslt := (&HTTPWorker{}).NewWorker().Work(&job{
+			url:     server.URL,
+			payload: payload,
+		})
+		if rslt.Status != jobqueue.ResultStatusInternalFailure {
+			t.Error("Worker request to an unknown authority should fail internally")
+		}
+	}()
+
+	func() {
+		wc := &HTTPWorker{InsecureSkipVerify: true}
+		rslt := wc.NewWorker().Work(&job{
+			url:     server.URL,
+			payload: payload,
+		})
+		if rslt.IsFailure() {
+			t.Error("Worker request should succeed without verification")
+		}
+		<-w.request
+	}()
+
+	func() {
+		f, err := ioutil.TempFile("", "ca")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		f.Close()
+
+		defer HTTPInit()
+		config.Locally("dispatch_tls_ca_file", f.Name(), func() {
+			HTTPInit()
+
+			rslt := (&HTTPWorker{}).NewWorker().Work(&job{
+				url:     server.URL,
+				payload: payload,
+			})
+			if rslt.IsFailure() {
+				t.Error("Worker request should succeed with the CA")
+			}
+			<-w.request
+		})
+	}()
+}
+
+func TestWorkStatusOnly(t *testing.T) {
+	tests := []struct {
+		code   int
+		status string
+	}{
+		{http.StatusOK, jobqueue.ResultStatusSuccess},
+		{http.StatusNoContent, jobqueue.ResultStatusSuccess},
+		{http.StatusTooManyRequests, jobqueue.ResultStatusFailure},
+		{http.StatusNotFound, jobqueue.ResultStatusPermanentFailure},
+		{http.StatusServiceUnavailable, jobqueue.ResultStatusFailure},
+	}
+
+	for _, tt := range tests {
+		code := tt.code
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(code)
+			w.Write([]byte("not a JSON"))
+		}))
+
+		rslt := (&HTTPWorker{StatusOnly: true}).NewWorker().Work(&job{
+			url:     server.URL,
+			payload: "{}",
+		})
+		if rslt.Status != tt.status {
+			t.Errorf("Status %d should be %s: %s", tt.code, tt.status, rslt.Status)
+		}
+		if rslt.Code != tt.code {
+			t.Errorf("Wrong code: %d", rslt.Code)
+		}
+
+		server.Close()
+	}
+}
+
+func TestWorkTraceContext(t *testing.T) {
+	header := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header <- req.Header
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+
+	metadata := &jobqueue.Metadata{
+		TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		TraceState:  "congo=t61rcWkgMzE",
+		RequestID:   "request1",
+	}
+	rslt := (&HTTPWorker{}).NewWorker().Work(&jobWithMetadata{
+		job:      job{url: server.URL, payload: "{}"},
+		metadata: metadata,
+	})
+	if rslt.IsFailure() {
+		t.Errorf("Worker request should succeed: %s", rslt.Message)
+	}
+
+	h := <-header
+	if h.Get("traceparent") != metadata.TraceParent {
+		t.Errorf("Wrong traceparent: %s", h.Get("traceparent"))
+	}
+	if h.Get("tracestate") != metadata.TraceState {
+		t.Errorf("Wrong tracestate: %s", h.Get("tracestate"))
+	}
+	if h.Get("X-Request-Id") != metadata.RequestID {
+		t.Errorf("Wrong X-Request-Id: %s", h.Get("X-Request-Id"))
+	}
+}
+
+func TestWorkAllowlist(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+	defer HTTPReload()
+
+	// a host name is checked only on connecting
+	u := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	config.Locally("dispatch_allowed_networks", "10.0.0.0/8", func() {
+		if err := HTTPReload(); err != nil {
+			t.Fatal(err)
+		}
+		rslt := (&HTTPWorker{}).NewWorker().Work(&job{url: u, payload: "{}"})
+		if rslt.Status != jobqueue.ResultStatusPermanentFailure {
+			t.Errorf("Connecting to an address out of the global allowlist should fail permanently: %+v", rslt)
+		}
+		if sharedSettings().transport.Proxy != nil {
+			t.Error("A proxy should not be used to restrict addresses")
+		}
+	})
+
+	config.Locally("dispatch_keep_alive", "true", func() {
+		if err := HTTPReload(); err != nil {
+			t.Fatal(err)
+		}
+		w := (&HTTPWorker{}).NewWorker()
+		for _, c := range []struct {
+			networks []string
+			success  bool
+		}{
+			{nil, true},
+			{[]string{"127.0.0.0/8"}, true},
+			{[]string{"10.0.0.0/8"}, false}, // even if a connection is reused
+			{[]string{"127.0.0.0/8"}, true},
+		} {
+			rslt := w.Work(&jobWithMetadata{
+				job:      job{url: u, payload: "{}"},
+				metadata: &jobqueue.Metadata{Allowlist: &model.Allowlist{Networks: c.networks}},
+			})
+			if rslt.IsSuccess() != c.success {
+				t.Errorf("Wrong result with %v: %+v", c.networks, rslt)
+			}
+		}
+	})
+}
+
 type testServer struct {
 	worker *testWorker
 	server *httptest.Server
@@ -348,3 +520,10 @@ func (j *job) RetryDelay() uint               { return 0 }
 func (j *job) FailCount() uint                { return 0 }
 func (j *job) Timeout() uint                  { return 0 }
 func (j *job) ToLoggable() logger.LoggableJob { return nil }
+
+type jobWithMetadata struct {
+	job
+	metadata *jobqueue.Metadata
+}
+
+func (j *jobWithMetadata) Metadata() *jobqueue.Metadata { return j.metadata }
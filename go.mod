@@ -3,6 +3,7 @@ module github.com/coosir/middleman
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/fukata/golang-stats-api-handler v1.0.0
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gorilla/mux v1.8.0
@@ -10,7 +11,12 @@ require (
 	github.com/paulbellamy/ratecounter v0.2.0
 	github.com/phayes/freeport v0.0.0-20220201140144-74d24b5ae9f5
 	github.com/rs/zerolog v1.26.1
+	github.com/santhosh-tekuri/jsonschema/v5 v5.0.0
 	golang.org/x/time v0.0.0-20220411224347-583f2d630306
+	sigs.k8s.io/yaml v1.3.0
 )
 
-require github.com/rs/xid v1.3.0 // indirect
+require (
+	github.com/rs/xid v1.3.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+)
@@ -0,0 +1,75 @@
+package jobqueue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coosir/middleman/model"
+)
+
+// Overflow policies, which decide what happens to a job pushed to a
+// queue that reached its limit.
+const (
+	OverflowReject     = "reject"      // reject the job
+	OverflowDropOldest = "drop_oldest" // drop the oldest waiting job
+	OverflowSpill      = "spill"       // push the job to another queue
+)
+
+// Backlog describes jobs remaining in a queue.
+type Backlog struct {
+	Length uint64 // the number of jobs in the queue
+	Oldest uint64 // next_try of the oldest waiting job; 0 if no job
+}
+
+// HasBacklog is an interface describing that it can tell its backlog
+// cheaply enough to be called on every push, typically from a cached
+// counter, and drop waiting jobs.
+//
+// This is typically a jobqueue.Impl sub-interface.
+type HasBacklog interface {
+	Backlog() (*Backlog, error)
+	// DropOldest deletes the waiting job to be grabbed next and
+	// returns it, or nil if there is no waiting job.
+	DropOldest() (Job, error)
+}
+
+// OverflowError is an error returned when Push() is called on a queue
+// which reached its limit and does not accept the job.
+type OverflowError struct {
+	Queue      string
+	SpillQueue string // a queue to push the job instead if any
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("queue %s is full", e.Queue)
+}
+
+type limit struct {
+	maxLength  uint64
+	maxAge     uint64 // milliseconds
+	policy     string
+	spillQueue string
+}
+
+func newLimit(definition *model.Queue) *limit {
+	if definition.MaxLength == 0 && definition.MaxAge == 0 {
+		return nil
+	}
+	return &limit{
+		maxLength:  uint64(definition.MaxLength),
+		maxAge:     uint64(definition.MaxAge) * 1000,
+		policy:     definition.OverflowPolicy,
+		spillQueue: definition.SpillQueue,
+	}
+}
+
+func (l *limit) exceeded(backlog *Backlog) bool {
+	if l.maxLength > 0 && backlog.Length >= l.maxLength {
+		return true
+	}
+	if l.maxAge > 0 && backlog.Oldest > 0 {
+		now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		return now > backlog.Oldest && now-backlog.Oldest > l.maxAge
+	}
+	return false
+}
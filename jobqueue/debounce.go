@@ -0,0 +1,50 @@
+package jobqueue
+
+// Debounce policies, which decide what happens to a waiting job when a
+// job with the same debounce key is pushed.
+const (
+	DebounceLast  = "last"  // replace the payload and push back the next try
+	DebounceFirst = "first" // keep the waiting job as it is
+)
+
+// MaxDebounceKeyLength is the maximum length in bytes of a debounce
+// key.
+const MaxDebounceKeyLength = 255
+
+// Debounce describes how to coalesce jobs pushed repeatedly.
+type Debounce struct {
+	Key    string
+	Window uint // seconds
+	Policy string
+}
+
+// HasDebounce is an interface describing that it is coalesced with a
+// waiting job with the same debounce key.
+//
+// This is typically an IncomingJob sub-interface.
+type HasDebounce interface {
+	Debounce() *Debounce
+}
+
+// DebounceOf returns how to coalesce a job or nil if the job is not
+// coalesced.
+func DebounceOf(job interface{}) *Debounce {
+	if hasDebounce, ok := job.(HasDebounce); ok {
+		if d := hasDebounce.Debounce(); d != nil && d.Key != "" {
+			return d
+		}
+	}
+	return nil
+}
+
+// Debouncer is an interface describing that it can coalesce a job into
+// a waiting job atomically.
+//
+// This is typically a jobqueue.Impl sub-interface.
+type Debouncer interface {
+	// PushDebounced coalesces a job into the waiting job with the same
+	// debounce key and returns the waiting job and true, or pushes the
+	// job as a new one after admit() succeeds if there is no waiting
+	// job with the key.
+	PushDebounced(job IncomingJob, d *Debounce, admit func() error) (Job, bool, error)
+}
@@ -0,0 +1,157 @@
+package event
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coosir/middleman/jobqueue/logger"
+)
+
+// Types of job lifecycle events.
+const (
+	TypePush             = "push"
+	TypePop              = "pop"
+	TypeComplete         = "complete"
+	TypeRetry            = "retry"
+	TypePermanentFailure = "permanent-failure"
+	TypeExpired          = "expired"
+	TypeOverflow         = "overflow" // dropped on overflow
+)
+
+// Event describes a change of the state of a job.
+type Event struct {
+	Type      string `json:"type"`
+	Time      int64  `json:"time"`
+	Queue     string `json:"queue"`
+	Category  string `json:"category"`
+	ID        uint64 `json:"id"`
+	URL       string `json:"url"`
+	FailCount uint   `json:"fail_count"`
+	Status    string `json:"status,omitempty"`
+	Code      int    `json:"code,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// New creates an event of a job in a queue.
+func New(typ string, queue string, j logger.LoggableJob) *Event {
+	return &Event{
+		Type:      typ,
+		Time:      time.Now().UnixNano() / int64(time.Millisecond),
+		Queue:     queue,
+		Category:  j.Category(),
+		ID:        j.ID(),
+		URL:       j.URL(),
+		FailCount: j.FailCount(),
+	}
+}
+
+// Filter selects events delivered to a subscriber.  An empty field
+// matches any value.
+type Filter struct {
+	Queue    string
+	Category string
+}
+
+func (f *Filter) match(e *Event) bool {
+	return (f.Queue == "" || f.Queue == e.Queue) &&
+		(f.Category == "" || f.Category == e.Category)
+}
+
+// Subscriber receives events from the bus through C.
+//
+// C is closed when the subscriber is unsubscribed or dropped by the
+// bus because it could not keep up with events.
+type Subscriber struct {
+	C       <-chan *Event
+	c       chan *Event
+	filter  Filter
+	dropped bool
+}
+
+// Dropped tells if the subscriber has been dropped by the bus because
+// its buffer had been full.  It is meaningful after C is closed.
+func (s *Subscriber) Dropped() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return s.dropped
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[*Subscriber]struct{})
+	count       int32
+)
+
+// Subscribe registers a new subscriber which receives events selected
+// by filter.  Up to bufferSize events are buffered for the subscriber;
+// if the buffer is full, the subscriber is dropped instead of blocking
+// the publisher.
+func Subscribe(filter Filter, bufferSize uint) *Subscriber {
+	c := make(chan *Event, bufferSize)
+	s := &Subscriber{C: c, c: c, filter: filter}
+
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers[s] = struct{}{}
+	atomic.AddInt32(&count, 1)
+	return s
+}
+
+// Unsubscribe stops delivering events to s.
+func Unsubscribe(s *Subscriber) {
+	mu.Lock()
+	defer mu.Unlock()
+	remove(s)
+}
+
+func remove(s *Subscriber) {
+	if _, ok := subscribers[s]; !ok {
+		return
+	}
+	delete(subscribers, s)
+	atomic.AddInt32(&count, -1)
+	close(s.c)
+}
+
+// Active tells if there is any subscriber.  Publishers may skip
+// building events if it returns false.
+func Active() bool {
+	return atomic.LoadInt32(&count) > 0
+}
+
+// Publish delivers an event to the subscribers.  It never blocks.
+//
+// This function is goroutine safe.
+func Publish(e *Event) {
+	if !Active() {
+		return
+	}
+
+	var slow []*Subscriber
+	mu.RLock()
+	for s := range subscribers {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range slow {
+		if _, ok := subscribers[s]; ok {
+			s.dropped = true
+			remove(s)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package event
+
+import (
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	if Active() {
+		t.Fatal("There should be no subscriber at first")
+	}
+
+	all := Subscribe(Filter{}, 10)
+	defer Unsubscribe(all)
+	queue := Subscribe(Filter{Queue: "q1"}, 10)
+	defer Unsubscribe(queue)
+	category := Subscribe(Filter{Queue: "q1", Category: "c2"}, 10)
+	defer Unsubscribe(category)
+
+	if !Active() {
+		t.Error("Should be active with subscribers")
+	}
+
+	Publish(&Event{Type: TypePush, Queue: "q1", Category: "c1", ID: 1})
+	Publish(&Event{Type: TypePush, Queue: "q1", Category: "c2", ID: 2})
+	Publish(&Event{Type: TypePush, Queue: "q2", Category: "c2", ID: 3})
+
+	expect := func(s *Subscriber, ids ...uint64) {
+		for _, id := range ids {
+			select {
+			case e := <-s.C:
+				if e.ID != id {
+					t.Errorf("Wrong event: %d != %d", e.ID, id)
+				}
+			default:
+				t.Errorf("Event %d should be delivered", id)
+			}
+		}
+		select {
+		case e := <-s.C:
+			t.Errorf("Unexpected event: %d", e.ID)
+		default:
+		}
+	}
+	expect(all, 1, 2, 3)
+	expect(queue, 1, 2)
+	expect(category, 2)
+}
+
+func TestDrop(t *testing.T) {
+	slow := Subscribe(Filter{}, 1)
+	fast := Subscribe(Filter{}, 10)
+	defer Unsubscribe(fast)
+
+	Publish(&Event{Type: TypePop, ID: 1})
+	Publish(&Event{Type: TypePop, ID: 2})
+
+	if e := <-slow.C; e.ID != 1 {
+		t.Errorf("Wrong event: %d", e.ID)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("A slow subscriber should be dropped")
+	}
+	if !slow.Dropped() {
+		t.Error("A slow subscriber should be marked as dropped")
+	}
+	Unsubscribe(slow) // must not panic
+
+	if len(fast.C) != 2 {
+		t.Error("Other subscribers should receive all events")
+	}
+	if fast.Dropped() {
+		t.Error("A fast subscriber should not be dropped")
+	}
+}
@@ -24,6 +24,25 @@ type IncomingJob = jobqueue.IncomingJob
 // such as mysql.
 type JobQueue = jobqueue.JobQueue
 
+// OverflowError imitates OverflowError in jobqueue package for the
+// same reason as JobQueue.
+type OverflowError = jobqueue.OverflowError
+
+// Overflow policies imitate those in jobqueue package for the same
+// reason as JobQueue.
+const (
+	OverflowReject     = jobqueue.OverflowReject
+	OverflowDropOldest = jobqueue.OverflowDropOldest
+	OverflowSpill      = jobqueue.OverflowSpill
+)
+
+// Fair share modes imitate those in jobqueue package for the same
+// reason as JobQueue.
+const (
+	FairShareCategory = jobqueue.FairShareCategory
+	FairShareTenant   = jobqueue.FairShareTenant
+)
+
 // NewImpl creates a new jobqueue.Impl instance according to the value
 // of "driver" configuration.
 func NewImpl(q *model.Queue) jobqueue.Impl {
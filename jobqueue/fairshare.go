@@ -0,0 +1,206 @@
+package jobqueue
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/coosir/middleman/model"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Fair share modes, which decide the groups of jobs among which a
+// queue is shared fairly.
+const (
+	FairShareCategory = "category" // share by job categories
+	FairShareTenant   = "tenant"   // share by tenants of jobs
+)
+
+// MaxTenantLength is the maximum length in bytes of a tenant.
+const MaxTenantLength = 255
+
+// HasTenant is an interface describing that it belongs to a tenant
+// sharing a queue with others.
+//
+// This is typically an IncomingJob sub-interface.
+type HasTenant interface {
+	Tenant() string
+}
+
+// TenantOf returns the tenant of a job or an empty string if the job
+// has none.
+func TenantOf(job interface{}) string {
+	if hasTenant, ok := job.(HasTenant); ok {
+		return hasTenant.Tenant()
+	}
+	return ""
+}
+
+// GroupOf returns the group of a job in a fair share mode.
+func GroupOf(job IncomingJob, by string) string {
+	if by == FairShareTenant {
+		return TenantOf(job)
+	}
+	return job.Category()
+}
+
+// FairPopper is an interface describing that it can pop jobs of each
+// group separately.
+//
+// This is typically a jobqueue.Impl sub-interface.
+type FairPopper interface {
+	// ReadyGroups returns the groups which have jobs ready to be
+	// grabbed.
+	ReadyGroups(by string) ([]string, error)
+	// PopGroup grabs at most limit jobs of a group in the same order
+	// as Pop().
+	PopGroup(by, group string, limit uint) ([]Job, error)
+}
+
+// fairShare splits pops among groups of jobs by weighted fair queuing:
+// each group has a virtual time advanced by the number of its popped
+// jobs divided by its weight, and the group with the earliest virtual
+// time is served next.
+type fairShare struct {
+	sync.Mutex
+	by      string
+	weights map[string]uint
+	vtime   map[string]float64 // by groups with ready jobs
+}
+
+// maxGroupsPerPop caps the groups served by a pop, each of which takes
+// a PopGroup() call such as a transaction of a database.  The groups
+// with the earliest virtual times are served first, so the others are
+// served by later pops.
+const maxGroupsPerPop = 16
+
+func newFairShare(definition *model.Queue) *fairShare {
+	if definition.FairShare == "" {
+		return nil
+	}
+	return &fairShare{
+		by:      definition.FairShare,
+		weights: definition.FairShareWeights,
+		vtime:   make(map[string]float64),
+	}
+}
+
+func (f *fairShare) weight(group string) float64 {
+	if w, ok := f.weights[group]; ok && w > 0 {
+		return float64(w)
+	}
+	return 1
+}
+
+func (f *fairShare) pop(impl FairPopper, limit uint) ([]Job, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	groups, err := impl.ReadyGroups(f.by)
+	if err != nil {
+		return nil, err
+	}
+	f.activate(groups)
+	groups = f.earliest(groups, maxGroupsPerPop)
+
+	popped := make([]Job, 0, limit)
+	for calls := 0; uint(len(popped)) < limit && len(groups) > 0 && calls < maxGroupsPerPop; {
+		shares := f.allocate(groups, limit-uint(len(popped)))
+
+		// Groups which have fewer jobs than their shares, typically
+		// because of concurrency keys, are left out of this pop.
+		remaining := make([]string, 0, len(groups))
+		for i, group := range groups {
+			if shares[i] == 0 || calls >= maxGroupsPerPop {
+				remaining = append(remaining, group)
+				continue
+			}
+			calls++
+			jobs, err := impl.PopGroup(f.by, group, shares[i])
+			if err != nil {
+				if len(popped) == 0 {
+					return nil, err
+				}
+				// Jobs already grabbed should be dispatched anyway.
+				log.Warn().Msgf("Failed to pop jobs of %s: %s", group, err)
+				return popped, nil
+			}
+			popped = append(popped, jobs...)
+			f.vtime[group] += float64(len(jobs)) / f.weight(group)
+			if uint(len(jobs)) == shares[i] {
+				remaining = append(remaining, group)
+			}
+		}
+		groups = remaining
+	}
+	return popped, nil
+}
+
+// activate forgets groups without ready jobs so that they do not save
+// up their shares while idle, and lets new groups start at the
+// earliest virtual time of the others.  groups are sorted in place.
+func (f *fairShare) activate(groups []string) {
+	sort.Strings(groups)
+
+	ready := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		ready[group] = true
+	}
+	for group := range f.vtime {
+		if !ready[group] {
+			delete(f.vtime, group)
+		}
+	}
+
+	start, started := 0.0, false
+	for _, v := range f.vtime {
+		if !started || v < start {
+			start, started = v, true
+		}
+	}
+	// Keep virtual times small by measuring them from the earliest.
+	for group := range f.vtime {
+		f.vtime[group] -= start
+	}
+	for _, group := range groups {
+		if _, ok := f.vtime[group]; !ok {
+			f.vtime[group] = 0
+		}
+	}
+}
+
+// earliest returns at most n groups with the earliest virtual times in
+// the order of their names.
+func (f *fairShare) earliest(groups []string, n int) []string {
+	if len(groups) <= n {
+		return groups
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return f.vtime[groups[i]] < f.vtime[groups[j]]
+	})
+	groups = groups[:n]
+	sort.Strings(groups)
+	return groups
+}
+
+// allocate splits n pops among groups and returns the share of each
+// group in the same order.
+func (f *fairShare) allocate(groups []string, n uint) []uint {
+	vtime := make([]float64, len(groups))
+	for i, group := range groups {
+		vtime[i] = f.vtime[group]
+	}
+
+	shares := make([]uint, len(groups))
+	for ; n > 0; n-- {
+		next := 0
+		for i := range groups {
+			if vtime[i] < vtime[next] {
+				next = i
+			}
+		}
+		shares[next]++
+		vtime[next] += 1 / f.weight(groups[next])
+	}
+	return shares
+}
@@ -15,12 +15,19 @@ import (
 type jobQueue struct {
 	sync.Mutex
 	queue *queue
+	held  map[string]uint64 // IDs of grabbed jobs by their concurrency keys
+
+	debounced map[string]*job // waiting jobs by their debounce keys
 }
 
 // New creates a jobqueue.Impl which uses in-memory data store.
 func New() jobqueue.Impl {
 	q := make(queue, 0)
-	return &jobQueue{queue: &q}
+	return &jobQueue{
+		queue:     &q,
+		held:      make(map[string]uint64),
+		debounced: make(map[string]*job),
+	}
 }
 
 func (q *jobQueue) Start() {
@@ -41,13 +48,101 @@ func (q *jobQueue) Push(j jobqueue.IncomingJob) (jobqueue.Job, error) {
 	return job, nil
 }
 
+func (q *jobQueue) PushDebounced(j jobqueue.IncomingJob, d *jobqueue.Debounce, admit func() error) (jobqueue.Job, bool, error) {
+	if waiting := q.coalesce(j, d); waiting != nil {
+		return waiting, true, nil
+	}
+	// admit() takes the lock to get the backlog.
+	if err := admit(); err != nil {
+		return nil, false, err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if waiting := q.coalesceLocked(j, d); waiting != nil {
+		return waiting, true, nil
+	}
+	job := newJob(j)
+	job.debounceKey = d.Key
+	heap.Push(q.queue, job)
+	q.debounced[d.Key] = job
+	return job, false, nil
+}
+
+func (q *jobQueue) coalesce(j jobqueue.IncomingJob, d *jobqueue.Debounce) *job {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.coalesceLocked(j, d)
+}
+
+// coalesceLocked coalesces a job into the waiting job with the same
+// debounce key and returns the waiting job, or returns nil if there is
+// no such job.
+func (q *jobQueue) coalesceLocked(j jobqueue.IncomingJob, d *jobqueue.Debounce) *job {
+	waiting, ok := q.debounced[d.Key]
+	if !ok || d.Policy == jobqueue.DebounceFirst {
+		return waiting
+	}
+
+	payload := j.Payload()
+	waiting.payload = &payload
+	nextTry := uint64(time.Now().UnixNano()/int64(time.Millisecond)) + uint64(d.Window)*1000
+	if nextTry > waiting.nextTry {
+		waiting.nextTry = nextTry
+		heap.Fix(q.queue, waiting.index)
+	}
+	return waiting
+}
+
 func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
+	return q.pop(limit, func(*job) bool { return true })
+}
+
+// ReadyGroups returns the groups of jobs ready to be grabbed, which are
+// not waiting for their concurrency keys.
+func (q *jobQueue) ReadyGroups(by string) ([]string, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	ready := make(map[string]bool)
+	for _, j := range *q.queue {
+		if j.NextTry() > now {
+			continue
+		}
+		if key := j.ConcurrencyKey(); key != "" {
+			if _, ok := q.held[key]; ok {
+				continue
+			}
+		}
+		ready[jobqueue.GroupOf(j, by)] = true
+	}
+
+	groups := make([]string, 0, len(ready))
+	for group := range ready {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (q *jobQueue) PopGroup(by, group string, limit uint) ([]jobqueue.Job, error) {
+	return q.pop(limit, func(j *job) bool {
+		return jobqueue.GroupOf(j, by) == group
+	})
+}
+
+// pop grabs at most limit ready jobs accepted by accept() in the order
+// of their next tries.
+func (q *jobQueue) pop(limit uint, accept func(*job) bool) ([]jobqueue.Job, error) {
 	q.Lock()
 	defer q.Unlock()
 
 	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
 	popped := make([]jobqueue.Job, 0, limit)
-	for i := uint(0); i < limit; i++ {
+	var skipped []*job
+	for uint(len(popped)) < limit {
 		if q.queue.Len() <= 0 {
 			break
 		}
@@ -55,13 +150,49 @@ func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 			break
 		}
 
-		popped = append(popped, heap.Pop(q.queue).(*job))
+		j := heap.Pop(q.queue).(*job)
+		if !accept(j) {
+			skipped = append(skipped, j)
+			continue
+		}
+		if key := j.ConcurrencyKey(); key != "" {
+			if _, ok := q.held[key]; ok {
+				skipped = append(skipped, j)
+				continue
+			}
+			q.held[key] = j.id
+		}
+		q.forget(j)
+		popped = append(popped, j)
+	}
+	for _, j := range skipped {
+		heap.Push(q.queue, j)
 	}
 	return popped, nil
 }
 
-func (q *jobQueue) Delete(job jobqueue.Job) {
-	// Do nothing; the job is deleted from the queue on Pop().
+func (q *jobQueue) Delete(completedJob jobqueue.Job) {
+	// The job is deleted from the queue on Pop().
+	q.Lock()
+	defer q.Unlock()
+
+	if j, ok := completedJob.(*job); ok {
+		q.release(j)
+	}
+}
+
+// forget stops coalescing jobs into a job which is no longer waiting.
+func (q *jobQueue) forget(j *job) {
+	if j.debounceKey != "" && q.debounced[j.debounceKey] == j {
+		delete(q.debounced, j.debounceKey)
+	}
+}
+
+// release lets another job with the same concurrency key be grabbed.
+func (q *jobQueue) release(j *job) {
+	if key := j.ConcurrencyKey(); key != "" && q.held[key] == j.id {
+		delete(q.held, key)
+	}
 }
 
 func (q *jobQueue) Update(completedJob jobqueue.Job, next jobqueue.NextInfo) {
@@ -74,6 +205,7 @@ func (q *jobQueue) Update(completedJob jobqueue.Job, next jobqueue.NextInfo) {
 		return
 	}
 
+	q.release(j)
 	j.nextTry = uint64(time.Now().UnixNano()/int64(time.Millisecond)) + next.NextDelay()
 	j.retryCount = next.RetryCount()
 	j.failCount = next.FailCount()
@@ -85,6 +217,31 @@ func (q *jobQueue) IsActive() bool {
 	return true
 }
 
+// Backlog returns the number of jobs waiting in the queue.  Grabbed
+// jobs are not counted since they are no longer in the queue.
+func (q *jobQueue) Backlog() (*jobqueue.Backlog, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	backlog := &jobqueue.Backlog{Length: uint64(q.queue.Len())}
+	if q.queue.Len() > 0 {
+		backlog.Oldest = (*q.queue)[0].NextTry()
+	}
+	return backlog, nil
+}
+
+func (q *jobQueue) DropOldest() (jobqueue.Job, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.queue.Len() <= 0 {
+		return nil, nil
+	}
+	j := heap.Pop(q.queue).(*job)
+	q.forget(j)
+	return j, nil
+}
+
 type job struct {
 	jobqueue.IncomingJob
 	id         uint64
@@ -92,12 +249,27 @@ type job struct {
 	nextTry    uint64
 	retryCount uint
 	failCount  uint
+
+	payload     *string // replaced by a coalesced job if any
+	debounceKey string
+
+	index int // in the queue while waiting
 }
 
 func newJob(j jobqueue.IncomingJob) *job {
 	id := atomic.AddUint64(&lastID, 1)
 	createdAt := uint64(time.Now().UnixNano() / int64(time.Millisecond))
-	return &job{j, id, createdAt, createdAt + j.NextDelay(), j.RetryCount(), 0}
+	nextTry := createdAt + j.NextDelay()
+	if runAt := jobqueue.RunAtOf(j); runAt > 0 {
+		nextTry = runAt
+	}
+	return &job{
+		IncomingJob: j,
+		id:          id,
+		createdAt:   createdAt,
+		nextTry:     nextTry,
+		retryCount:  j.RetryCount(),
+	}
 }
 
 func (j *job) ID() uint64 {
@@ -116,6 +288,13 @@ func (j *job) NextTry() uint64 {
 	return j.nextTry
 }
 
+func (j *job) Payload() string {
+	if j.payload != nil {
+		return *j.payload
+	}
+	return j.IncomingJob.Payload()
+}
+
 func (j *job) RetryCount() uint {
 	return j.retryCount
 }
@@ -124,6 +303,18 @@ func (j *job) FailCount() uint {
 	return j.failCount
 }
 
+func (j *job) Metadata() *jobqueue.Metadata {
+	return jobqueue.MetadataOf(j.IncomingJob)
+}
+
+func (j *job) ConcurrencyKey() string {
+	return jobqueue.ConcurrencyKeyOf(j.IncomingJob)
+}
+
+func (j *job) Tenant() string {
+	return jobqueue.TenantOf(j.IncomingJob)
+}
+
 func (j *job) ToLoggable() logger.LoggableJob {
 	return j
 }
@@ -140,10 +331,14 @@ func (q queue) Less(i, j int) bool {
 
 func (q queue) Swap(i, j int) {
 	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
 }
 
 func (q *queue) Push(x interface{}) {
-	*q = append(*q, x.(*job))
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
 }
 
 func (q *queue) Pop() interface{} {
@@ -18,6 +18,7 @@ type InspectedJob struct {
 	FailCount  uint            `json:"fail_count"`
 	MaxRetries uint            `json:"max_retries"`
 	RetryDelay uint            `json:"retry_delay"`
+	LastResult *Result         `json:"last_result,omitempty"`
 }
 
 // InspectedJobs describes a (page of) job list in a queue.
@@ -42,6 +43,9 @@ type Inspector interface {
 	FindAllGrabbed(limit uint, cursor string, order SortOrder) (*InspectedJobs, error)
 	FindAllWaiting(limit uint, cursor string, order SortOrder) (*InspectedJobs, error)
 	FindAllDeferred(limit uint, cursor string, order SortOrder) (*InspectedJobs, error)
+	// FindAllDeferredBetween finds deferred jobs whose next try is in
+	// [from, to).  A zero from or to means no bound on that side.
+	FindAllDeferredBetween(from, to time.Time, limit uint, cursor string, order SortOrder) (*InspectedJobs, error)
 }
 
 // HasInspector is an interface describing that it has an Inspector.
@@ -85,3 +89,65 @@ type FailureLog interface {
 type HasFailureLog interface {
 	FailureLog() FailureLog
 }
+
+// FinishedJob describes a job which has been finished, either
+// successfully or permanently failed.
+type FinishedJob struct {
+	ID          uint64          `json:"id"`
+	Category    string          `json:"category"`
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Result      *Result         `json:"result"`
+	Attempts    uint            `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	CompletedAt time.Time       `json:"completed_at"`
+}
+
+// Statuses of a finished job
+const (
+	FinishedJobStatusCompleted = "completed"
+	FinishedJobStatusFailed    = "failed"
+)
+
+// History is an interface to look up finished jobs of a queue.
+type History interface {
+	Add(finished Job, result *Result) error
+	Delete(jobID uint64) error
+	Find(jobID uint64) (*FinishedJob, error)
+}
+
+// HasHistory is an interface describing that it has a History.
+//
+// This is typically a JobQueue sub-interface.
+type HasHistory interface {
+	History() History
+}
+
+// Attempt describes a single dispatch of a job.
+type Attempt struct {
+	ID        uint64    `json:"id"`
+	JobID     uint64    `json:"job_id"`
+	Result    *Result   `json:"result"`
+	Node      string    `json:"node"`
+	StartedAt time.Time `json:"started_at"`
+	Elapsed   int64     `json:"elapsed"` // milliseconds
+}
+
+// Attempts describes a list of attempts of a job.
+type Attempts struct {
+	Attempts []Attempt `json:"attempts"`
+}
+
+// AttemptLog is an interface to inspect attempts of jobs in a queue.
+type AttemptLog interface {
+	Add(attempted Job, attempt *Attempt) error
+	FindAll(jobID uint64) (*Attempts, error)
+}
+
+// HasAttemptLog is an interface describing that it has an AttemptLog.
+//
+// This is typically a JobQueue sub-interface.
+type HasAttemptLog interface {
+	AttemptLog() AttemptLog
+}
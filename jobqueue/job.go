@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/coosir/middleman/jobqueue/logger"
+	"github.com/coosir/middleman/model"
 )
 
 // IncomingJob is an interface of incoming jobs.
@@ -31,6 +32,148 @@ type Job interface {
 	ToLoggable() logger.LoggableJob
 }
 
+// Metadata describes optional attributes of a job, which are not
+// needed to grab the job.
+type Metadata struct {
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// Tracing context of the request which pushed the job, which is
+	// propagated to every attempt of the job.
+	TraceParent string `json:"traceparent,omitempty"` // W3C traceparent
+	TraceState  string `json:"tracestate,omitempty"`  // W3C tracestate
+	RequestID   string `json:"request_id,omitempty"`  // X-Request-Id
+
+	// Time in milliseconds after which the job is no longer
+	// dispatched.  0 means that the job never expires.
+	ExpireAt uint64 `json:"expire_at,omitempty"`
+
+	// Destinations allowed by the routing of the job, which are
+	// enforced again on connecting to the URL of the job.
+	Allowlist *model.Allowlist `json:"allowlist,omitempty"`
+}
+
+// TraceContext returns the metadata only with the tracing context or
+// nil if there is no tracing context.
+func (m *Metadata) TraceContext() *Metadata {
+	if m == nil || (m.TraceParent == "" && m.RequestID == "") {
+		return nil
+	}
+	return &Metadata{
+		TraceParent: m.TraceParent,
+		TraceState:  m.TraceState,
+		RequestID:   m.RequestID,
+	}
+}
+
+// HasMetadata is an interface describing that it has Metadata.
+//
+// This is typically an IncomingJob or a Job sub-interface.
+type HasMetadata interface {
+	Metadata() *Metadata
+}
+
+// MetadataOf returns the metadata of a job or nil if the job has
+// none.
+func MetadataOf(job interface{}) *Metadata {
+	if hasMetadata, ok := job.(HasMetadata); ok {
+		return hasMetadata.Metadata()
+	}
+	return nil
+}
+
+// HasRunAt is an interface describing that it is scheduled at an
+// absolute time instead of after NextDelay().
+//
+// This is typically an IncomingJob sub-interface.
+type HasRunAt interface {
+	RunAt() uint64 // milliseconds; 0 means not scheduled
+}
+
+// RunAtOf returns the time in milliseconds when the job is scheduled
+// to be grabbed or 0 if the job is not scheduled at an absolute time.
+func RunAtOf(job interface{}) uint64 {
+	if hasRunAt, ok := job.(HasRunAt); ok {
+		return hasRunAt.RunAt()
+	}
+	return 0
+}
+
+// HasRoutingKey is an interface describing that it has a key to
+// choose the same queue among the targets of a routing for related
+// jobs.
+//
+// This is typically an IncomingJob sub-interface.
+type HasRoutingKey interface {
+	RoutingKey() string
+}
+
+// RoutingKeyOf returns the routing key of a job or an empty string if
+// the job has none.
+func RoutingKeyOf(job interface{}) string {
+	if hasRoutingKey, ok := job.(HasRoutingKey); ok {
+		return hasRoutingKey.RoutingKey()
+	}
+	return ""
+}
+
+// MaxConcurrencyKeyLength is the maximum length in bytes of a
+// concurrency key.
+const MaxConcurrencyKeyLength = 255
+
+// HasConcurrencyKey is an interface describing that it has a key to
+// exclude other jobs with the same key from running at the same time.
+//
+// This is typically an IncomingJob or a Job sub-interface.
+type HasConcurrencyKey interface {
+	ConcurrencyKey() string
+}
+
+// ConcurrencyKeyOf returns the concurrency key of a job or an empty
+// string if the job has none.
+func ConcurrencyKeyOf(job interface{}) string {
+	if hasConcurrencyKey, ok := job.(HasConcurrencyKey); ok {
+		return hasConcurrencyKey.ConcurrencyKey()
+	}
+	return ""
+}
+
+// IsFinished returns if the job is no longer retried after the
+// result.
+func IsFinished(job Job, res *Result) bool {
+	if res.IsFinished() || job.RetryCount() == 0 {
+		return true
+	}
+	return !res.IsSuccess() && expiresBeforeRetry(job)
+}
+
+// FinalResult returns the result of a finished job to be recorded,
+// which is res itself unless the job was going to be retried after its
+// expiration time.
+func FinalResult(job Job, res *Result) *Result {
+	if res.IsFinished() || job.RetryCount() == 0 {
+		return res
+	}
+	return &Result{
+		Status:    ResultStatusExpired,
+		Code:      res.Code,
+		Message:   res.Message,
+		StartedAt: res.StartedAt,
+		Elapsed:   res.Elapsed,
+	}
+}
+
+// IsExpired returns if the job has passed its expiration time at now,
+// in milliseconds.
+func IsExpired(job interface{}, now uint64) bool {
+	m := MetadataOf(job)
+	return m != nil && m.ExpireAt != 0 && m.ExpireAt <= now
+}
+
+func expiresBeforeRetry(job Job) bool {
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	return IsExpired(job, now+uint64(job.RetryDelay())*1000)
+}
+
 // completedJob : implements the following interfaces
 // - Job
 // - logger.LoggableJob
@@ -61,10 +204,6 @@ func (j *completedJob) ToLoggable() logger.LoggableJob {
 	}
 }
 
-func (j *completedJob) canRetry() bool {
-	return j.Job.RetryCount() > 0
-}
-
 type loggableCompletedJob struct {
 	logger.LoggableJob
 	status    string
@@ -97,6 +236,25 @@ func (j *nextJob) FailCount() uint {
 	return j.job.FailCount()
 }
 
+// deferredJob : implements the following interfaces
+// - NextInfo
+type deferredJob struct {
+	job   Job
+	delay uint64
+}
+
+func (j *deferredJob) NextDelay() uint64 {
+	return j.delay
+}
+
+func (j *deferredJob) RetryCount() uint {
+	return j.job.RetryCount()
+}
+
+func (j *deferredJob) FailCount() uint {
+	return j.job.FailCount()
+}
+
 // NextInfo describes information of a retry.
 type NextInfo interface {
 	NextDelay() uint64
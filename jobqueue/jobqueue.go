@@ -1,6 +1,10 @@
 package jobqueue
 
 import (
+	"os"
+	"time"
+
+	"github.com/coosir/middleman/jobqueue/event"
 	"github.com/coosir/middleman/jobqueue/logger"
 	"github.com/coosir/middleman/model"
 
@@ -24,6 +28,13 @@ type JobQueue interface {
 	Push(job IncomingJob) (uint64, error)
 	Pop(limit uint) ([]Job, error)
 	Complete(job Job, res *Result)
+	Defer(job Job, delay uint64)
+
+	// OnFinish sets a function called with the final result of a job
+	// when the job succeeded or will never be dispatched again, either
+	// on completion, on expiration before dispatch or on overflow.  It
+	// should be set before jobs are pushed.
+	OnFinish(fn func(job Job, res *Result))
 
 	Name() string
 
@@ -33,25 +44,49 @@ type JobQueue interface {
 
 	Inspector() (Inspector, bool)
 	FailureLog() (FailureLog, bool)
+	History() (History, bool)
+	AttemptLog() (AttemptLog, bool)
 }
 
 // Start returns a job queue.
 func Start(definition *model.Queue, q Impl) JobQueue {
+	node, _ := os.Hostname()
 	jq := &jobQueue{
-		name:       definition.Name,
-		maxWorkers: definition.MaxWorkers,
-		impl:       q,
-		stats:      newStats(),
+		name:         definition.Name,
+		node:         node,
+		maxWorkers:   definition.MaxWorkers,
+		keepsHistory: definition.HistoryRetention > 0,
+		impl:         q,
+		stats:        newStats(),
+	}
+	if l := newLimit(definition); l != nil {
+		if _, ok := q.(HasBacklog); ok {
+			jq.limit = l
+		} else {
+			log.Warn().Msgf("Queue %s cannot limit its length with this driver", definition.Name)
+		}
+	}
+	if f := newFairShare(definition); f != nil {
+		if _, ok := q.(FairPopper); ok {
+			jq.fairShare = f
+		} else {
+			log.Warn().Msgf("Queue %s cannot share itself fairly with this driver", definition.Name)
+		}
 	}
 	q.Start()
 	return jq
 }
 
 type jobQueue struct {
-	name       string
-	maxWorkers uint
-	impl       Impl
-	stats      *stats
+	name         string
+	node         string
+	maxWorkers   uint
+	keepsHistory bool
+	limit        *limit
+	fairShare    *fairShare
+	impl         Impl
+	stats        *stats
+	onFinish     func(job Job, res *Result)
 }
 
 func (q *jobQueue) Name() string {
@@ -63,34 +98,137 @@ func (q *jobQueue) Stop() <-chan struct{} {
 }
 
 func (q *jobQueue) Push(j IncomingJob) (uint64, error) {
+	if d := DebounceOf(j); d != nil {
+		if debouncer, ok := q.impl.(Debouncer); ok {
+			return q.pushDebounced(debouncer, j, d)
+		}
+	}
+
+	if err := q.admit(); err != nil {
+		return 0, err
+	}
+
 	job, err := q.impl.Push(j)
 	if err != nil {
 		return 0, err
 	}
 
+	return q.pushed(job), nil
+}
+
+func (q *jobQueue) pushDebounced(debouncer Debouncer, j IncomingJob, d *Debounce) (uint64, error) {
+	job, coalesced, err := debouncer.PushDebounced(j, d, q.admit)
+	if err != nil {
+		return 0, err
+	}
+	if !coalesced {
+		return q.pushed(job), nil
+	}
+
+	q.stats.coalesce(1)
+
+	loggableJob := job.ToLoggable()
+	logger.Info(q.name, "coalesce", loggableJob, "Coalesced into a waiting job")
+	return loggableJob.ID(), nil
+}
+
+func (q *jobQueue) pushed(job Job) uint64 {
+
 	q.stats.push(1)
 
 	loggableJob := job.ToLoggable()
 	logger.Info(q.name, "push", loggableJob, "New job accepted")
+	if event.Active() {
+		event.Publish(event.New(event.TypePush, q.name, loggableJob))
+	}
 
-	return loggableJob.ID(), nil
+	return loggableJob.ID()
+}
+
+// admit checks if the queue can accept a new job and makes room for
+// it if the overflow policy allows.  The check fails open if the
+// backlog is unknown.
+func (q *jobQueue) admit() error {
+	if q.limit == nil {
+		return nil
+	}
+
+	impl := q.impl.(HasBacklog)
+	backlog, err := impl.Backlog()
+	if err != nil {
+		log.Warn().Msgf("Cannot get the backlog of %s: %s", q.name, err)
+		return nil
+	}
+	if !q.limit.exceeded(backlog) {
+		return nil
+	}
+
+	q.stats.overflow(1)
+	switch q.limit.policy {
+	case OverflowDropOldest:
+		dropped, err := impl.DropOldest()
+		if err != nil {
+			log.Warn().Msgf("Cannot drop the oldest job of %s: %s", q.name, err)
+		}
+		if dropped != nil {
+			q.fail(dropped, &Result{
+				Status:  ResultStatusDropped,
+				Message: "Dropped to make room for a new job",
+			})
+		}
+		return nil
+	case OverflowSpill:
+		return &OverflowError{Queue: q.name, SpillQueue: q.limit.spillQueue}
+	default:
+		return &OverflowError{Queue: q.name}
+	}
 }
 
 func (q *jobQueue) Pop(limit uint) ([]Job, error) {
-	results, err := q.impl.Pop(limit)
+	var (
+		results []Job
+		err     error
+	)
+	if q.fairShare != nil {
+		results, err = q.fairShare.pop(q.impl.(FairPopper), limit)
+	} else {
+		results, err = q.impl.Pop(limit)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	q.stats.pop(int64(len(results)))
 
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	alive := results[:0]
+	for _, j := range results {
+		if IsExpired(j, now) {
+			q.complete(j, &Result{
+				Status:  ResultStatusExpired,
+				Message: "Expired before being dispatched",
+			})
+			continue
+		}
+		alive = append(alive, j)
+	}
+	results = alive
+
 	for _, j := range results {
 		logger.Debug(q.name, "pop", j.ToLoggable(), "A job grabbed")
+		if event.Active() {
+			event.Publish(event.New(event.TypePop, q.name, j.ToLoggable()))
+		}
 	}
 	return results, nil
 }
 
 func (q *jobQueue) Complete(job Job, res *Result) {
+	q.addAttempt(job, res)
+	q.complete(job, res)
+}
+
+func (q *jobQueue) complete(job Job, res *Result) {
 	var j *completedJob
 	if res.IsSuccess() {
 		j = &completedJob{job, 0}
@@ -102,30 +240,110 @@ func (q *jobQueue) Complete(job Job, res *Result) {
 
 	if res.IsSuccess() {
 		logger.Info(q.name, "complete", loggable, res.Message)
+		q.publish(event.TypeComplete, loggable, res)
 		q.stats.succeed(1)
 		q.stats.complete(1)
 		q.stats.elapsed(logger.Elapsed(loggable))
+		q.addHistory(job, res)
 		q.impl.Delete(job)
-	} else if res.IsPermanentFailure() || !j.canRetry() {
-		logger.Info(q.name, "complete", loggable, res.Message)
-		q.stats.fail(1)
-		q.stats.permanentlyFail(1)
-		q.stats.complete(1)
-		q.stats.elapsed(logger.Elapsed(loggable))
-		if failureLog, ok := q.FailureLog(); ok {
-			err := failureLog.Add(job, res)
-			if err != nil {
-				log.Warn().Msg(err.Error())
-			}
-		}
+		q.finish(job, res)
+	} else if IsFinished(job, res) {
+		q.fail(job, FinalResult(job, res))
 		q.impl.Delete(job)
 	} else {
 		logger.Info(q.name, "retry", loggable, res.Message)
+		q.publish(event.TypeRetry, loggable, res)
 		q.stats.fail(1)
 		q.impl.Update(job, &nextJob{j})
 	}
 }
 
+// fail records a job which failed and will never be dispatched again.
+func (q *jobQueue) fail(job Job, res *Result) {
+	loggable := (&completedJob{job, 1}).ToLoggable()
+	logger.Info(q.name, "complete", loggable, res.Message)
+	switch res.Status {
+	case ResultStatusExpired:
+		q.publish(event.TypeExpired, loggable, res)
+	case ResultStatusDropped:
+		q.publish(event.TypeOverflow, loggable, res)
+	default:
+		q.publish(event.TypePermanentFailure, loggable, res)
+	}
+	q.stats.fail(1)
+	q.stats.permanentlyFail(1)
+	q.stats.complete(1)
+	q.stats.elapsed(logger.Elapsed(loggable))
+	if failureLog, ok := q.FailureLog(); ok {
+		err := failureLog.Add(job, res)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+		}
+	}
+	q.addHistory(job, res)
+	q.finish(job, res)
+}
+
+func (q *jobQueue) OnFinish(fn func(job Job, res *Result)) {
+	q.onFinish = fn
+}
+
+func (q *jobQueue) finish(job Job, res *Result) {
+	if q.onFinish != nil {
+		q.onFinish(job, res)
+	}
+}
+
+// Defer puts a grabbed job back into the queue to be grabbed again
+// after delay in milliseconds.  The job is not counted as a failure.
+func (q *jobQueue) Defer(job Job, delay uint64) {
+	logger.Debug(q.name, "defer", job.ToLoggable(), "A job deferred")
+	q.impl.Update(job, &deferredJob{job, delay})
+}
+
+func (q *jobQueue) publish(typ string, j logger.LoggableJob, res *Result) {
+	if !event.Active() {
+		return
+	}
+	e := event.New(typ, q.name, j)
+	e.Status = res.Status
+	e.Code = res.Code
+	e.Message = res.Message
+	event.Publish(e)
+}
+
+func (q *jobQueue) addAttempt(job Job, res *Result) {
+	attemptLog, ok := q.AttemptLog()
+	if !ok {
+		return
+	}
+
+	startedAt := res.StartedAt
+	if startedAt.IsZero() {
+		startedAt = time.Now()
+	}
+	err := attemptLog.Add(job, &Attempt{
+		Result:    res,
+		Node:      q.node,
+		StartedAt: startedAt,
+		Elapsed:   int64(res.Elapsed / time.Millisecond),
+	})
+	if err != nil {
+		log.Warn().Msg(err.Error())
+	}
+}
+
+func (q *jobQueue) addHistory(job Job, res *Result) {
+	if !q.keepsHistory {
+		return
+	}
+	if history, ok := q.History(); ok {
+		if err := history.Add(job, res); err != nil {
+			log.Warn().Msg(err.Error())
+		}
+	}
+}
+
 func (q *jobQueue) IsActive() bool {
 	return q.impl.IsActive()
 }
@@ -155,6 +373,20 @@ func (q *jobQueue) FailureLog() (FailureLog, bool) {
 	return nil, false
 }
 
+func (q *jobQueue) History() (History, bool) {
+	if hasHistory, ok := q.impl.(HasHistory); ok {
+		return hasHistory.History(), ok
+	}
+	return nil, false
+}
+
+func (q *jobQueue) AttemptLog() (AttemptLog, bool) {
+	if hasAttemptLog, ok := q.impl.(HasAttemptLog); ok {
+		return hasAttemptLog.AttemptLog(), ok
+	}
+	return nil, false
+}
+
 // InactiveError is an error returned when Pop() is called on an
 // inactive queue.
 type InactiveError struct{}
@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/coosir/middleman/config"
 	"github.com/coosir/middleman/jobqueue"
 	"github.com/coosir/middleman/jobqueue/factory"
 	"github.com/coosir/middleman/model"
@@ -455,6 +457,569 @@ func TestInspecting(t *testing.T) {
 	}()
 }
 
+func TestHistory(t *testing.T) {
+	queueName := "jobqueue_history_test_queue"
+
+	jq := start(&model.Queue{Name: queueName, MaxWorkers: 10, HistoryRetention: 60})
+	defer func() { <-jq.Stop() }()
+
+	h, ok := jq.History()
+	if test.If("driver", "in-memory") {
+		if h != nil || ok {
+			t.Error("Implemented?")
+		}
+		return
+	}
+	if !ok {
+		t.Fatal("Cannot get the history")
+	}
+
+	jobs := []incomingJob{
+		{url: "job0", payload: `{"foo":1}`},
+		{url: "job1", retryCount: 1},
+		{url: "job2"},
+	}
+	ids := make([]uint64, len(jobs))
+	for i := range jobs {
+		id, err := jq.Push(&jobs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	popped, err := jq.Pop(3)
+	if err != nil || len(popped) != 3 {
+		t.Fatalf("Cannot pop jobs: %v", err)
+	}
+	results := map[uint64]*jobqueue.Result{
+		ids[0]: {Status: jobqueue.ResultStatusSuccess, Message: "done"},
+		ids[1]: {Status: jobqueue.ResultStatusFailure, Message: "retry"},
+		ids[2]: {Status: jobqueue.ResultStatusPermanentFailure, Message: "fail"},
+	}
+	for _, j := range popped {
+		jq.Complete(j, results[j.ToLoggable().ID()])
+	}
+
+	func() {
+		j, err := h.Find(ids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status != jobqueue.FinishedJobStatusCompleted || j.Attempts != 1 || j.Result.Message != "done" {
+			t.Errorf("Wrong finished job: %v", j)
+		}
+		if string(j.Payload) != jobs[0].payload {
+			t.Errorf("Wrong payload: %s", string(j.Payload))
+		}
+	}()
+
+	func() {
+		if _, err := h.Find(ids[1]); err != sql.ErrNoRows {
+			t.Error("A job to be retried should not be finished")
+		}
+	}()
+
+	func() {
+		j, err := h.Find(ids[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status != jobqueue.FinishedJobStatusFailed || j.Result.Message != "fail" {
+			t.Errorf("Wrong finished job: %v", j)
+		}
+
+		if err := h.Delete(ids[2]); err != nil {
+			t.Error(err)
+		}
+		if _, err := h.Find(ids[2]); err != sql.ErrNoRows {
+			t.Error("Deleted job should not be found")
+		}
+	}()
+
+	func() {
+		jq := start(&model.Queue{Name: queueName + "_disabled", MaxWorkers: 10})
+		defer func() { <-jq.Stop() }()
+
+		id, err := jq.Push(&incomingJob{url: "job"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		popped, err := jq.Pop(1)
+		if err != nil || len(popped) != 1 {
+			t.Fatalf("Cannot pop jobs: %v", err)
+		}
+		jq.Complete(popped[0], &jobqueue.Result{Status: jobqueue.ResultStatusSuccess})
+
+		h, _ := jq.History()
+		if _, err := h.Find(id); err != sql.ErrNoRows {
+			t.Error("A job should not be kept without retention")
+		}
+	}()
+}
+
+func TestAttemptLog(t *testing.T) {
+	queueName := "jobqueue_attempt_log_test_queue"
+
+	jq := start(&model.Queue{Name: queueName, MaxWorkers: 10})
+	defer func() { <-jq.Stop() }()
+
+	l, ok := jq.AttemptLog()
+	if test.If("driver", "in-memory") {
+		if l != nil || ok {
+			t.Error("Implemented?")
+		}
+		return
+	}
+	if !ok {
+		t.Fatal("Cannot get the attempt log")
+	}
+
+	maxAttempts := 3
+	config.Locally("attempt_log_max_per_job", strconv.Itoa(maxAttempts), func() {
+		jq := start(&model.Queue{Name: queueName + "_capped", MaxWorkers: 10})
+		defer func() { <-jq.Stop() }()
+
+		l, _ := jq.AttemptLog()
+		ins, _ := jq.Inspector()
+
+		id, err := jq.Push(&incomingJob{url: "job", retryCount: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for n := 0; n < maxAttempts+2; n++ {
+			popped, err := jq.Pop(1)
+			if err != nil || len(popped) != 1 {
+				t.Fatalf("Cannot pop a job: %v", err)
+			}
+			jq.Complete(popped[0], &jobqueue.Result{
+				Status:    jobqueue.ResultStatusFailure,
+				Code:      500,
+				Message:   fmt.Sprintf("attempt%d", n),
+				StartedAt: time.Now(),
+				Elapsed:   10 * time.Millisecond,
+			})
+		}
+
+		r, err := l.FindAll(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Attempts) != maxAttempts {
+			t.Errorf("The number of attempts should be capped: %d", len(r.Attempts))
+		}
+		last := r.Attempts[len(r.Attempts)-1]
+		if last.Result.Message != fmt.Sprintf("attempt%d", maxAttempts+1) || last.Elapsed != 10 {
+			t.Errorf("Wrong attempt: %v", last)
+		}
+		if _, err := json.Marshal(r); err != nil {
+			t.Error(err)
+		}
+
+		j, err := ins.Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.LastResult == nil || j.LastResult.Message != last.Result.Message {
+			t.Errorf("Wrong last result: %v", j.LastResult)
+		}
+
+		if err := ins.Delete(id); err != nil {
+			t.Error(err)
+		}
+		r, err = l.FindAll(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Attempts) != 0 {
+			t.Error("Attempts of a deleted job should be removed")
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	queueName := "jobqueue_metadata_test_queue"
+
+	jq := start(&model.Queue{Name: queueName, MaxWorkers: 10})
+	defer func() { <-jq.Stop() }()
+
+	metadata := &jobqueue.Metadata{
+		CallbackURL: "http://localhost/callback",
+		TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		TraceState:  "congo=t61rcWkgMzE",
+		RequestID:   "request1",
+	}
+	if _, err := jq.Push(&incomingJob{url: "without"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jq.Push(&incomingJob{url: "with", metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+
+	popped, err := jq.Pop(2)
+	if err != nil || len(popped) != 2 {
+		t.Fatalf("Cannot pop jobs: %v", err)
+	}
+	for _, j := range popped {
+		m := jobqueue.MetadataOf(j)
+		switch j.URL() {
+		case "with":
+			if m == nil || *m != *metadata {
+				t.Errorf("Wrong metadata: %v", m)
+			}
+		case "without":
+			if m != nil {
+				t.Errorf("A job without metadata should have none: %v", m)
+			}
+		}
+		jq.Complete(j, &jobqueue.Result{Status: jobqueue.ResultStatusSuccess})
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	func() {
+		jq := start(&model.Queue{
+			Name:           "jobqueue_overflow_reject_test_queue",
+			MaxWorkers:     10,
+			MaxLength:      2,
+			OverflowPolicy: jobqueue.OverflowReject,
+		})
+		defer func() { <-jq.Stop() }()
+
+		for i := 0; i < 2; i++ {
+			if _, err := jq.Push(&incomingJob{url: "job", nextDelay: 60000}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := jq.Push(&incomingJob{url: "job", nextDelay: 60000})
+		if _, ok := err.(*jobqueue.OverflowError); !ok {
+			t.Errorf("Pushing to a full queue should be rejected: %v", err)
+		}
+		if n := jq.Stats().TotalOverflows; n != 1 {
+			t.Errorf("Wrong number of overflows: %d", n)
+		}
+	}()
+
+	func() {
+		jq := start(&model.Queue{
+			Name:           "jobqueue_overflow_drop_test_queue",
+			MaxWorkers:     10,
+			MaxLength:      2,
+			OverflowPolicy: jobqueue.OverflowDropOldest,
+		})
+		defer func() { <-jq.Stop() }()
+
+		var dropped []uint64
+		jq.OnFinish(func(j jobqueue.Job, res *jobqueue.Result) {
+			if res.Status == jobqueue.ResultStatusDropped {
+				dropped = append(dropped, j.ToLoggable().ID())
+			}
+		})
+
+		var ids []uint64
+		for i := 0; i < 3; i++ {
+			id, err := jq.Push(&incomingJob{url: "job", nextDelay: uint64(60000 + i*1000)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		if len(dropped) != 1 || dropped[0] != ids[0] {
+			t.Errorf("The dropped job should be finished: %v", dropped)
+		}
+		if n := jq.Stats().TotalPermanentFailures; n != 1 {
+			t.Errorf("The dropped job should permanently fail: %d", n)
+		}
+
+		if failureLog, ok := jq.FailureLog(); ok {
+			failed, err := failureLog.FindAll(10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(failed.FailedJobs) != 1 || failed.FailedJobs[0].Result.Status != jobqueue.ResultStatusDropped {
+				t.Errorf("The dropped job should be logged: %v", failed.FailedJobs)
+			}
+			for _, f := range failed.FailedJobs {
+				failureLog.Delete(f.ID)
+			}
+		}
+
+		ins, ok := jq.Inspector()
+		if !ok {
+			return
+		}
+		if _, err := ins.Find(ids[0]); err == nil {
+			t.Error("The oldest job should be dropped")
+		}
+		for _, id := range ids[1:] {
+			if _, err := ins.Find(id); err != nil {
+				t.Errorf("Job %d should remain: %s", id, err)
+			}
+			ins.Delete(id)
+		}
+	}()
+}
+
+func TestDebounce(t *testing.T) {
+	jq := start(&model.Queue{
+		Name:           "jobqueue_debounce_test_queue",
+		MaxWorkers:     10,
+		MaxLength:      1,
+		OverflowPolicy: jobqueue.OverflowReject,
+	})
+	defer func() { <-jq.Stop() }()
+
+	debounce := &jobqueue.Debounce{Key: "doc:1", Window: 60, Policy: jobqueue.DebounceLast}
+	id, err := jq.Push(&incomingJob{url: "job", payload: "1", nextDelay: 60000, debounce: debounce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Coalescing into a waiting job does not overflow the queue.
+	coalesced, err := jq.Push(&incomingJob{url: "job", payload: "2", nextDelay: 60000, debounce: debounce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coalesced != id {
+		t.Errorf("A coalesced job should have the ID of the waiting job: %d != %d", coalesced, id)
+	}
+	if stats := jq.Stats(); stats.TotalPushes != 1 || stats.TotalCoalesces != 1 {
+		t.Errorf("Wrong stats: %+v", stats)
+	}
+
+	ins, ok := jq.Inspector()
+	if !ok {
+		return
+	}
+	j, err := ins.Find(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j.Payload) != "2" {
+		t.Errorf("The payload should be replaced: %s", j.Payload)
+	}
+	ins.Delete(id)
+}
+
+func TestFairShare(t *testing.T) {
+	jq := start(&model.Queue{
+		Name:             "jobqueue_fair_share_test_queue",
+		MaxWorkers:       10,
+		FairShare:        jobqueue.FairShareCategory,
+		FairShareWeights: map[string]uint{"light": 2},
+	})
+	defer func() { <-jq.Stop() }()
+
+	for i := 0; i < 6; i++ {
+		jq.Push(&incomingJob{category: "heavy", url: "job"})
+	}
+	for i := 0; i < 3; i++ {
+		jq.Push(&incomingJob{category: "light", url: "job"})
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	count := func(limit uint) map[string]int {
+		jobs, err := jq.Pop(limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]int)
+		for _, j := range jobs {
+			counts[j.ToLoggable().Category()]++
+			jq.Complete(j, &jobqueue.Result{Status: jobqueue.ResultStatusSuccess})
+		}
+		return counts
+	}
+
+	// The light category gets twice as many pops as the heavy one
+	// although it has been pushed later.
+	if counts := count(3); counts["heavy"] != 1 || counts["light"] != 2 {
+		t.Errorf("Pops should be shared by weights: %v", counts)
+	}
+	// An exhausted group leaves its share to the others.
+	if counts := count(10); counts["heavy"] != 5 || counts["light"] != 1 {
+		t.Errorf("Pops should be shared by weights: %v", counts)
+	}
+}
+
+func TestFairShareManyGroups(t *testing.T) {
+	jq := start(&model.Queue{
+		Name:       "jobqueue_fair_share_many_groups_test_queue",
+		MaxWorkers: 100,
+		FairShare:  jobqueue.FairShareCategory,
+	})
+	defer func() { <-jq.Stop() }()
+
+	const groups = 50
+	for i := 0; i < groups; i++ {
+		jq.Push(&incomingJob{category: fmt.Sprintf("group%d", i), url: "job"})
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	popped, err := jq.Pop(groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(popped) == 0 || len(popped) >= groups {
+		t.Errorf("A pop should serve a limited number of groups: %d", len(popped))
+	}
+	total := len(popped)
+	for total < groups {
+		jobs, err := jq.Pop(groups)
+		if err != nil || len(jobs) == 0 {
+			t.Fatalf("The other groups should be served by later pops: %d, %v", total, err)
+		}
+		total += len(jobs)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	jq := start(&model.Queue{Name: "jobqueue_defer_test_queue", MaxWorkers: 10})
+	defer func() { <-jq.Stop() }()
+
+	if _, err := jq.Push(&incomingJob{url: "job", retryCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	popped, err := jq.Pop(1)
+	if err != nil || len(popped) != 1 {
+		t.Fatalf("A job should be popped: %v, %v", popped, err)
+	}
+
+	jq.Defer(popped[0], 100)
+	if popped, _ := jq.Pop(1); len(popped) != 0 {
+		t.Errorf("A deferred job should not be popped before its delay: %v", popped)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	popped, err = jq.Pop(1)
+	if err != nil || len(popped) != 1 {
+		t.Fatalf("A deferred job should be popped after its delay: %v, %v", popped, err)
+	}
+	if popped[0].RetryCount() != 1 || popped[0].FailCount() != 0 {
+		t.Errorf("Deferring should not count a retry: %d, %d", popped[0].RetryCount(), popped[0].FailCount())
+	}
+	if stats := jq.Stats(); stats.TotalFailures != 0 {
+		t.Errorf("Deferring should not count a failure: %+v", stats)
+	}
+	jq.Complete(popped[0], &jobqueue.Result{Status: jobqueue.ResultStatusSuccess})
+}
+
+func TestExpiration(t *testing.T) {
+	queueName := "jobqueue_expiration_test_queue"
+
+	jq := start(&model.Queue{Name: queueName, MaxWorkers: 10})
+	defer func() { <-jq.Stop() }()
+
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	expired := &incomingJob{url: "expired", metadata: &jobqueue.Metadata{ExpireAt: now - 1}}
+	alive := &incomingJob{url: "alive", retryCount: 3, retryDelay: 10, metadata: &jobqueue.Metadata{ExpireAt: now + 5000}}
+	for _, j := range []*incomingJob{expired, alive} {
+		if _, err := jq.Push(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popped, err := jq.Pop(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(popped) != 1 || popped[0].URL() != "alive" {
+		t.Fatalf("Only an alive job should be popped: %v", popped)
+	}
+	if n := jq.Stats().TotalPermanentFailures; n != 1 {
+		t.Errorf("An expired job should permanently fail: %d", n)
+	}
+
+	// The next try would be after the expiration time
+	res := &jobqueue.Result{Status: jobqueue.ResultStatusFailure, Message: "failed"}
+	if !jobqueue.IsFinished(popped[0], res) {
+		t.Error("A job should not be retried after its expiration time")
+	}
+	if r := jobqueue.FinalResult(popped[0], res); r.Status != jobqueue.ResultStatusExpired || r.Message != "failed" {
+		t.Errorf("Wrong final result: %v", r)
+	}
+	jq.Complete(popped[0], res)
+	if n := jq.Stats().TotalPermanentFailures; n != 2 {
+		t.Errorf("An expiring job should permanently fail: %d", n)
+	}
+
+	failureLog, ok := jq.FailureLog()
+	if !ok {
+		return
+	}
+	failed, err := failureLog.FindAll(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed.FailedJobs) != 2 {
+		t.Fatalf("Expired jobs should be logged: %v", failed.FailedJobs)
+	}
+	for _, f := range failed.FailedJobs {
+		if f.Result.Status != jobqueue.ResultStatusExpired {
+			t.Errorf("Wrong status: %s", f.Result.Status)
+		}
+		failureLog.Delete(f.ID)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	queueName := "jobqueue_schedule_test_queue"
+
+	jq := start(&model.Queue{Name: queueName, MaxWorkers: 10})
+	defer func() { <-jq.Stop() }()
+
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	jobs := []*incomingJob{
+		{url: "past", runAt: now - 1000},
+		{url: "future", runAt: now + 600000},
+		{url: "delayed", nextDelay: 600000, runAt: now - 2000}, // run_at wins
+	}
+	for _, j := range jobs {
+		if _, err := jq.Push(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popped, err := jq.Pop(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(popped) != 2 {
+		t.Fatalf("Only jobs scheduled in the past should be popped: %v", popped)
+	}
+	for _, j := range popped {
+		if j.URL() == "future" {
+			t.Errorf("A job scheduled in the future should not be popped")
+		}
+		jq.Complete(j, &jobqueue.Result{Status: jobqueue.ResultStatusSuccess})
+	}
+
+	ins, ok := jq.Inspector()
+	if !ok {
+		return
+	}
+	from := time.Unix(0, int64(now+500000)*int64(time.Millisecond))
+	r, err := ins.FindAllDeferredBetween(from, time.Time{}, 10, "", jobqueue.Asc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Jobs) != 1 || r.Jobs[0].URL != "future" || uint64(r.Jobs[0].NextTry.UnixNano()/int64(time.Millisecond)) != now+600000 {
+		t.Errorf("The scheduled job should be deferred at run_at: %v", r.Jobs)
+	}
+	r, err = ins.FindAllDeferredBetween(time.Time{}, from, 10, "", jobqueue.Desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Jobs) != 0 {
+		t.Errorf("No job should be deferred before the window: %v", r.Jobs)
+	}
+
+	r, _ = ins.FindAllDeferred(10, "", jobqueue.Asc)
+	for _, j := range r.Jobs {
+		ins.Delete(j.ID)
+	}
+}
+
 func TestNodeInfo(t *testing.T) {
 	queueName := "jobqueue_node_info_test_queue"
 
@@ -576,8 +1141,12 @@ type incomingJob struct {
 	url        string
 	payload    string
 	nextDelay  uint64
+	runAt      uint64
 	retryDelay uint
 	retryCount uint
+	metadata   *jobqueue.Metadata
+	debounce   *jobqueue.Debounce
+	tenant     string
 }
 
 func (job *incomingJob) Category() string {
@@ -596,6 +1165,10 @@ func (job *incomingJob) NextDelay() uint64 {
 	return job.nextDelay
 }
 
+func (job *incomingJob) RunAt() uint64 {
+	return job.runAt
+}
+
 func (job *incomingJob) NextTry() uint64 {
 	return uint64(0)
 }
@@ -611,3 +1184,15 @@ func (job *incomingJob) RetryDelay() uint {
 func (job *incomingJob) Timeout() uint {
 	return uint(0)
 }
+
+func (job *incomingJob) Metadata() *jobqueue.Metadata {
+	return job.metadata
+}
+
+func (job *incomingJob) Debounce() *jobqueue.Debounce {
+	return job.debounce
+}
+
+func (job *incomingJob) Tenant() string {
+	return job.tenant
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/coosir/middleman/config"
@@ -21,6 +22,7 @@ var (
 		l := zerolog.Nop()
 		return &l
 	}()
+	level = int32(zerolog.InfoLevel)
 )
 
 // Init initializes global parameters of logger by configuration values.
@@ -39,12 +41,23 @@ func Init() {
 		Writer = w
 	}
 
-	level := logwriter.ParseLevel(config.Get("queue_log_level"), zerolog.InfoLevel)
+	SetLevel(logwriter.ParseLevel(config.Get("queue_log_level"), zerolog.InfoLevel))
 
-	l := zerolog.New(Writer).With().Logger().Level(level)
+	l := zerolog.New(Writer).With().Logger()
 	logger = &l
 }
 
+// SetLevel changes the level of the job queue logs.
+//
+// This function is goroutine safe.
+func SetLevel(l zerolog.Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+func enabled(l zerolog.Level) bool {
+	return l >= zerolog.Level(atomic.LoadInt32(&level))
+}
+
 func put(event *zerolog.Event, queue string, action string, j LoggableJob, msg string) {
 	created := int64(j.CreatedAt())
 	elapsed := Elapsed(j)
@@ -70,11 +83,17 @@ func put(event *zerolog.Event, queue string, action string, j LoggableJob, msg s
 
 // Info writes an INFO level log entry of a job action.
 func Info(queue string, action string, j LoggableJob, msg string) {
+	if !enabled(zerolog.InfoLevel) {
+		return
+	}
 	put(logger.Info(), queue, action, j, msg)
 }
 
 // Debug writes a DEBUG level log entry of a job action.
 func Debug(queue string, action string, j LoggableJob, msg string) {
+	if !enabled(zerolog.DebugLevel) {
+		return
+	}
 	put(logger.Debug(), queue, action, j, msg)
 }
 
@@ -0,0 +1,139 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/coosir/middleman/jobqueue"
+)
+
+type attemptLog struct {
+	db          *sql.DB
+	sql         *sqls
+	maxAttempts uint
+}
+
+func (l *attemptLog) Add(attempted jobqueue.Job, attempt *jobqueue.Attempt) error {
+	log := log.With().Str("method", "attemptLog.Add").Logger()
+
+	if l.maxAttempts == 0 {
+		return nil
+	}
+
+	j, ok := attempted.(*job)
+	if !ok {
+		return fmt.Errorf("Invalid job structure: %v", attempted)
+	}
+
+	res, err := json.Marshal(attempt.Result)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.db.Exec(
+		l.sql.insertAttempt,
+		j.id,
+		res,
+		attempt.Node,
+		attempt.StartedAt.UnixNano()/int64(time.Millisecond),
+		attempt.Elapsed,
+	); err != nil {
+		log.Debug().Msgf("Failed to insert an attempt: %s", err)
+		return err
+	}
+
+	if j.FailCount()+1 <= l.maxAttempts {
+		return nil
+	}
+	if _, err := l.db.Exec(l.sql.truncateAttempts, j.id, j.id, l.maxAttempts); err != nil {
+		log.Debug().Msgf("Failed to truncate attempts: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (l *attemptLog) FindAll(jobID uint64) (*jobqueue.Attempts, error) {
+	attempts, err := l.findAll([]interface{}{jobID})
+	if err != nil {
+		return nil, err
+	}
+	return &jobqueue.Attempts{Attempts: attempts}, nil
+}
+
+// lastResults returns the results of the last attempts of jobs.
+func (l *attemptLog) lastResults(jobIDs []interface{}) (map[uint64]*jobqueue.Result, error) {
+	if len(jobIDs) == 0 {
+		return map[uint64]*jobqueue.Result{}, nil
+	}
+	attempts, err := l.query(
+		l.sql.lastAttempts+"("+placeholders(len(jobIDs))+") GROUP BY job_id)",
+		jobIDs...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[uint64]*jobqueue.Result, len(jobIDs))
+	for _, a := range attempts {
+		results[a.JobID] = a.Result
+	}
+	return results, nil
+}
+
+func (l *attemptLog) findAll(jobIDs []interface{}) ([]jobqueue.Attempt, error) {
+	if len(jobIDs) == 0 {
+		return make([]jobqueue.Attempt, 0), nil
+	}
+	return l.query(
+		l.sql.attempts+"("+placeholders(len(jobIDs))+") ORDER BY attempt_id ASC",
+		jobIDs...,
+	)
+}
+
+func (l *attemptLog) query(query string, args ...interface{}) ([]jobqueue.Attempt, error) {
+	attempts := make([]jobqueue.Attempt, 0)
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a jobqueue.Attempt
+		var result []byte
+		var startedAt uint64
+		if err := rows.Scan(&(a.ID), &(a.JobID), &result, &(a.Node), &startedAt, &(a.Elapsed)); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(result, &(a.Result)); err != nil {
+			return nil, err
+		}
+
+		secInMillisec := int64(time.Second / time.Millisecond)
+		a.StartedAt = time.Unix(int64(startedAt)/secInMillisec, int64(startedAt)%secInMillisec*int64(time.Millisecond))
+
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func (l *attemptLog) delete(jobIDs ...interface{}) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+	_, err := l.db.Exec(l.sql.deleteAttempts+"("+placeholders(len(jobIDs))+")", jobIDs...)
+	return err
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,126 @@
+package mysql
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/coosir/middleman/jobqueue"
+)
+
+// The interval at which the cached backlog is refreshed from the
+// table.  Pushes, pops and retries on this node are reflected in
+// between.  Only jobs waiting to be grabbed are counted as the
+// in-memory driver does.
+const backlogRefreshInterval = 5 * time.Second
+
+// backlog is a cached backlog of a queue, which saves `COUNT(*)` on
+// every push.
+type backlog struct {
+	sync.Mutex
+	length int64
+	oldest uint64
+}
+
+func (b *backlog) add(n int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.length += n
+	if b.length < 0 {
+		b.length = 0
+	}
+}
+
+func (b *backlog) get() *jobqueue.Backlog {
+	b.Lock()
+	defer b.Unlock()
+	return &jobqueue.Backlog{Length: uint64(b.length), Oldest: b.oldest}
+}
+
+func (b *backlog) refresh(db *sql.DB, s *sqls) error {
+	var length int64
+	if err := db.QueryRow(s.countJobs).Scan(&length); err != nil {
+		return err
+	}
+
+	var id, oldest uint64
+	err := db.QueryRow(s.oldestJob).Scan(&id, &oldest)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.length = length
+	b.oldest = oldest
+	return nil
+}
+
+func (q *jobQueue) Backlog() (*jobqueue.Backlog, error) {
+	return q.backlog.get(), nil
+}
+
+func (q *jobQueue) DropOldest() (jobqueue.Job, error) {
+	var id, nextTry uint64
+	err := q.db.QueryRow(q.sql.oldestJob).Scan(&id, &nextTry)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The job is read before it is dropped so that it is recorded as
+	// a failure.
+	var j job
+	if err := scanJob(q.db.QueryRow(q.sql.grabbed+"(?)", "claimed", id), &j); err == sql.ErrNoRows {
+		return nil, nil // grabbed in the meantime
+	} else if err != nil {
+		return nil, err
+	}
+	metadata, err := findMetadata(q.db, q.sql, []interface{}{id})
+	if err != nil {
+		return nil, err
+	}
+	j.metadata = metadata[id]
+
+	r, err := q.db.Exec(q.sql.dropJob, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := r.RowsAffected(); err != nil || n == 0 {
+		return nil, err // grabbed in the meantime
+	}
+	q.backlog.add(-1)
+
+	if err := deleteMetadata(q.db, q.sql, id); err != nil {
+		return &j, err
+	}
+	if err := deleteConcurrencyKeys(q.db, q.sql, id); err != nil {
+		return &j, err
+	}
+	if err := deleteDebounceKeys(q.db, q.sql, id); err != nil {
+		return &j, err
+	}
+	return &j, (&attemptLog{db: q.db, sql: q.sql}).delete(id)
+}
+
+// refreshBacklog periodically refreshes the cached backlog until the
+// queue stops.
+func (q *jobQueue) refreshBacklog() {
+	log := q.logger.With().Str("method", "refreshBacklog").Logger()
+
+	ticker := time.NewTicker(backlogRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := q.backlog.refresh(q.db, q.sql); err != nil {
+			log.Error().Msgf("Failed to refresh the backlog: %s", err)
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
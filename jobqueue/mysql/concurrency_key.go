@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+// holdConcurrencyKeys lets jobs hold their concurrency keys in the
+// order of the jobs, and returns the jobs except ones whose keys are
+// held by other jobs.
+func holdConcurrencyKeys(tx *sql.Tx, s *sqls, jobs []*job) ([]*job, error) {
+	if len(jobs) == 0 {
+		return jobs, nil
+	}
+
+	ids := make([]interface{}, 0, len(jobs))
+	for _, j := range jobs {
+		ids = append(ids, j.id)
+	}
+	type concurrencyKey struct {
+		key  string
+		held bool
+	}
+	keys := make(map[uint64]concurrencyKey)
+	if err := func() error {
+		rows, err := tx.Query(s.keys+"("+placeholders(len(ids))+")", ids...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id uint64
+			var k concurrencyKey
+			if err := rows.Scan(&id, &(k.key), &(k.held)); err != nil {
+				return err
+			}
+			keys[id] = k
+		}
+		return rows.Err()
+	}(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return jobs, nil
+	}
+
+	holders := jobs[:0]
+	for _, j := range jobs {
+		k, ok := keys[j.id]
+		if ok && !k.held { // held already if the job was not released
+			r, err := tx.Exec(s.holdKey, j.id)
+			if err != nil {
+				return nil, err
+			}
+			n, err := r.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 { // held by another job
+				continue
+			}
+		}
+		j.concurrencyKey = k.key
+		holders = append(holders, j)
+	}
+	return holders, nil
+}
+
+func releaseConcurrencyKeys(db *sql.DB, s *sqls, jobIDs ...interface{}) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+	_, err := db.Exec(s.releaseKeys+"("+placeholders(len(jobIDs))+")", jobIDs...)
+	return err
+}
+
+func deleteConcurrencyKeys(db *sql.DB, s *sqls, jobIDs ...interface{}) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+	_, err := db.Exec(s.deleteKeys+"("+placeholders(len(jobIDs))+")", jobIDs...)
+	return err
+}
@@ -0,0 +1,13 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+func deleteDebounceKeys(db *sql.DB, s *sqls, jobIDs ...interface{}) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+	_, err := db.Exec(s.deleteDebounceKeys+"("+placeholders(len(jobIDs))+")", jobIDs...)
+	return err
+}
@@ -0,0 +1,93 @@
+package mysql
+
+import (
+	"github.com/coosir/middleman/jobqueue"
+)
+
+// upgradeJobqueue adds an index to pop jobs of each group to the queue
+// table if the queue is shared fairly, and the tenant column if it is
+// shared among tenants and the table was created without it.  Other
+// queue tables are left as they are.
+func (q *jobQueue) upgradeJobqueue() error {
+	switch q.fairShare {
+	case jobqueue.FairShareCategory:
+		return q.ensure(q.sql.hasIndex, "fair_category", q.sql.addFairCategory)
+	case jobqueue.FairShareTenant:
+		if err := q.ensure(q.sql.hasColumn, "tenant", q.sql.addTenant); err != nil {
+			return err
+		}
+		return q.ensure(q.sql.hasIndex, "fair_tenant", q.sql.addFairTenant)
+	}
+	return nil
+}
+
+// ensure alters the queue table unless it already has the named column
+// or index.  Another node may alter the table at the same time, which
+// is not an error.
+func (q *jobQueue) ensure(has, name, alter string) error {
+	exists := func() (bool, error) {
+		var n int
+		err := q.db.QueryRow(has, name).Scan(&n)
+		return n > 0, err
+	}
+
+	if ok, err := exists(); err != nil || ok {
+		return err
+	}
+	q.logger.Info().Msgf("Adding %s to the queue table...", name)
+	if _, err := q.db.Exec(alter); err != nil {
+		if ok, _ := exists(); ok {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (q *jobQueue) ReadyGroups(by string) ([]string, error) {
+	log := q.logger.With().Str("method", "ReadyGroups").Logger()
+
+	if !q.IsActive() {
+		return nil, &jobqueue.InactiveError{}
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.dbPop == nil {
+		return nil, &jobqueue.ConnectionClosedError{}
+	}
+
+	query := q.sql.readyCategories
+	if by == jobqueue.FairShareTenant {
+		query = q.sql.readyTenants
+	}
+	rows, err := q.dbPop.Query(query)
+	if err != nil {
+		log.Debug().Msgf("Failed to select ready groups: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]string, 0)
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			log.Debug().Msgf("Failed to scan ready groups: %s", err)
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		log.Debug().Msgf("Failed to read ready groups: %s", err)
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (q *jobQueue) PopGroup(by, group string, limit uint) ([]jobqueue.Job, error) {
+	query := q.sql.grabCategory
+	if by == jobqueue.FairShareTenant {
+		query = q.sql.grabTenant
+	}
+	return q.pop(query, limit, group)
+}
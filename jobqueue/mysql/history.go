@@ -0,0 +1,141 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/coosir/middleman/jobqueue"
+)
+
+// The maximum number of finished jobs deleted at once by pruning.
+const historyPruneLimit = 1000
+
+type history struct {
+	db  *sql.DB
+	sql *sqls
+}
+
+func (h *history) Add(finished jobqueue.Job, result *jobqueue.Result) error {
+	log := log.With().Str("method", "history.Add").Logger()
+
+	j, ok := finished.(*job)
+	if !ok {
+		return fmt.Errorf("Invalid job structure: %v", finished)
+	}
+
+	res, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	status := jobqueue.FinishedJobStatusCompleted
+	if !result.IsSuccess() {
+		status = jobqueue.FinishedJobStatusFailed
+	}
+
+	if _, err := h.db.Exec(
+		h.sql.insertHistory,
+		j.id,
+		j.Category(),
+		finished.URL(),
+		finished.Payload(),
+		status,
+		res,
+		finished.FailCount()+1,
+		j.CreatedAt(),
+		time.Now().UnixNano()/int64(time.Millisecond),
+	); err != nil {
+		log.Debug().Msgf("Failed to insert a finished job: %s", err)
+	}
+
+	return err
+}
+
+func (h *history) Delete(jobID uint64) error {
+	if _, err := h.db.Exec(h.sql.pruneHistory+"(?)", jobID); err != nil {
+		return err
+	}
+	return h.attempts().delete(jobID)
+}
+
+func (h *history) Find(jobID uint64) (*jobqueue.FinishedJob, error) {
+	var j jobqueue.FinishedJob
+	var result []byte
+	var createdAt uint64
+	var completedAt uint64
+
+	if err := h.db.QueryRow(h.sql.history, jobID).Scan(&(j.ID), &(j.Category), &(j.URL), &(j.Payload), &(j.Status), &result, &(j.Attempts), &createdAt, &completedAt); err != nil {
+		return nil, err
+	}
+	if _, err := json.Marshal(j.Payload); err != nil {
+		payload, _ := json.Marshal(string(j.Payload))
+		j.Payload = json.RawMessage(payload)
+	}
+
+	if err := json.Unmarshal(result, &(j.Result)); err != nil {
+		return nil, err
+	}
+
+	secInMillisec := int64(time.Second / time.Millisecond)
+	j.CreatedAt = time.Unix(int64(createdAt)/secInMillisec, int64(createdAt)%secInMillisec*int64(time.Millisecond))
+	j.CompletedAt = time.Unix(int64(completedAt)/secInMillisec, int64(completedAt)%secInMillisec*int64(time.Millisecond))
+
+	return &j, nil
+}
+
+// prune deletes finished jobs completed before the time and their
+// attempts.
+func (h *history) prune(before time.Time) (int64, error) {
+	var pruned int64
+	for {
+		ids, err := h.expired(before)
+		if err != nil {
+			return pruned, err
+		}
+		if len(ids) == 0 {
+			return pruned, nil
+		}
+
+		if err := h.attempts().delete(ids...); err != nil {
+			return pruned, err
+		}
+		if _, err := h.db.Exec(h.sql.pruneHistory+"("+placeholders(len(ids))+")", ids...); err != nil {
+			return pruned, err
+		}
+		pruned += int64(len(ids))
+
+		if len(ids) < historyPruneLimit {
+			return pruned, nil
+		}
+	}
+}
+
+func (h *history) expired(before time.Time) ([]interface{}, error) {
+	rows, err := h.db.Query(
+		h.sql.expiredHistory+strconv.Itoa(historyPruneLimit),
+		before.UnixNano()/int64(time.Millisecond),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]interface{}, 0, historyPruneLimit)
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (h *history) attempts() *attemptLog {
+	return &attemptLog{db: h.db, sql: h.sql}
+}
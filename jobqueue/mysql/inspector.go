@@ -24,8 +24,19 @@ type inspector struct {
 }
 
 func (i *inspector) Delete(jobID uint64) error {
-	_, err := i.db.Exec(i.sql.deleteJob, jobID)
-	return err
+	if _, err := i.db.Exec(i.sql.deleteJob, jobID); err != nil {
+		return err
+	}
+	if err := deleteMetadata(i.db, i.sql, jobID); err != nil {
+		return err
+	}
+	if err := deleteConcurrencyKeys(i.db, i.sql, jobID); err != nil {
+		return err
+	}
+	if err := deleteDebounceKeys(i.db, i.sql, jobID); err != nil {
+		return err
+	}
+	return i.attempts().delete(jobID)
 }
 
 func (i *inspector) Find(jobID uint64) (*jobqueue.InspectedJob, error) {
@@ -33,6 +44,13 @@ func (i *inspector) Find(jobID uint64) (*jobqueue.InspectedJob, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	lastResults, err := i.attempts().lastResults([]interface{}{jobID})
+	if err != nil {
+		return nil, err
+	}
+	j.LastResult = lastResults[jobID]
+
 	return j, nil
 }
 
@@ -53,11 +71,27 @@ func (i *inspector) FindAllWaiting(limit uint, cursor string, order jobqueue.Sor
 }
 
 func (i *inspector) FindAllDeferred(limit uint, cursor string, order jobqueue.SortOrder) (*jobqueue.InspectedJobs, error) {
+	return i.FindAllDeferredBetween(time.Time{}, time.Time{}, limit, cursor, order)
+}
+
+func (i *inspector) FindAllDeferredBetween(from, to time.Time, limit uint, cursor string, order jobqueue.SortOrder) (*jobqueue.InspectedJobs, error) {
 	var minTime = time.Now().UnixNano() / int64(time.Millisecond)
+	if t := from.UnixNano() / int64(time.Millisecond); !from.IsZero() && t > minTime {
+		minTime = t
+	}
+	var maxTime int64 = math.MaxInt64
+	if !to.IsZero() {
+		maxTime = to.UnixNano() / int64(time.Millisecond)
+	}
+	if maxTime <= minTime { // empty window
+		return &jobqueue.InspectedJobs{Jobs: []jobqueue.InspectedJob{}}, nil
+	}
+
 	if order == jobqueue.Asc {
-		return i.findAllAsc("claimed", minTime, math.MaxInt64, limit, cursor)
+		return i.findAllAsc("claimed", minTime, maxTime, limit, cursor)
 	}
-	return i.findAllDesc("claimed", minTime, 0, limit, cursor)
+	// findAllDesc finds jobs in (minTime, maxTime]
+	return i.findAllDesc("claimed", minTime-1, maxTime-1, limit, cursor)
 }
 
 func (i *inspector) findAllAsc(status string, minTime int64, maxTime int64, limit uint, cursor string) (*jobqueue.InspectedJobs, error) {
@@ -131,6 +165,14 @@ func (i *inspector) findAllAsc(status string, minTime int64, maxTime int64, limi
 		return nil, err
 	}
 
+	lastResults, err := i.attempts().lastResults(ids)
+	if err != nil {
+		return nil, err
+	}
+	for k := range results {
+		results[k].LastResult = lastResults[results[k].ID]
+	}
+
 	// Emulate `ORDER BY next_try ASC, job_id ASC`, which causes
 	// `using filesort` together with `SELECT ~ WHERE ~ IN`.
 	sort.Slice(results, func(i, j int) bool {
@@ -232,6 +274,14 @@ func (i *inspector) findAllDesc(status string, minTime int64, maxTime int64, lim
 		return nil, err
 	}
 
+	lastResults, err := i.attempts().lastResults(ids)
+	if err != nil {
+		return nil, err
+	}
+	for k := range results {
+		results[k].LastResult = lastResults[results[k].ID]
+	}
+
 	// Emulate `ORDER BY next_try DESC, job_id DESC`, which causes
 	// `using filesort` together with `SELECT ~ WHERE ~ IN`.
 	sort.Slice(results, func(i, j int) bool {
@@ -282,3 +332,7 @@ func (i *inspector) scan(s scanner) (*jobqueue.InspectedJob, error) {
 
 	return &j, nil
 }
+
+func (i *inspector) attempts() *attemptLog {
+	return &attemptLog{db: i.db, sql: i.sql}
+}
@@ -37,6 +37,9 @@ func (j *incomingJob) NextDelay() uint64 {
 }
 
 func (j *incomingJob) NextTry() uint64 {
+	if runAt := jobqueue.RunAtOf(j.IncomingJob); runAt > 0 {
+		return runAt
+	}
 	nowMillisecond := uint64(time.Now().UnixNano() / int64(time.Millisecond))
 	return nowMillisecond + j.NextDelay()
 }
@@ -60,6 +63,9 @@ type job struct {
 	retryDelay uint   // seconds
 	retryCount uint
 	failCount  uint
+	metadata   *jobqueue.Metadata
+
+	concurrencyKey string // set while the job holds it
 }
 
 func (j *job) ID() uint64 {
@@ -106,6 +112,14 @@ func (j *job) CreatedAt() uint64 {
 	return j.createdAt
 }
 
+func (j *job) Metadata() *jobqueue.Metadata {
+	return j.metadata
+}
+
+func (j *job) ConcurrencyKey() string {
+	return j.concurrencyKey
+}
+
 func (j *job) ToLoggable() logger.LoggableJob {
 	return j
 }
@@ -29,15 +29,24 @@ func Dsn() string {
 	return config.Get("mysql_dsn")
 }
 
+// The interval at which expired finished jobs are pruned.
+const historyPruneInterval = 1 * time.Minute
+
 type jobQueue struct {
-	name    string
-	dsn     string
-	sql     *sqls
-	db      *sql.DB
-	dbPop   *sql.DB
-	mu      sync.RWMutex
-	stopped uint32
-	logger  zerolog.Logger
+	name        string
+	dsn         string
+	sql         *sqls
+	db          *sql.DB
+	dbPop       *sql.DB
+	mu          sync.RWMutex
+	stopped     uint32
+	retention   time.Duration
+	maxAttempts uint
+	stop        chan struct{}
+	limited     bool
+	fairShare   string
+	backlog     backlog
+	logger      zerolog.Logger
 }
 
 // New creates a jobqueue.Impl which uses MySQL as a data store.
@@ -47,11 +56,20 @@ func New(definition *model.Queue, dsn string) jobqueue.Impl {
 
 func newJobQueue(definition *model.Queue, dsn string) *jobQueue {
 	tableName := newTableName(definition)
+	maxAttempts, err := strconv.ParseUint(config.Get("attempt_log_max_per_job"), 10, 32)
+	if err != nil {
+		maxAttempts = 0
+	}
 	return &jobQueue{
-		name:   definition.Name,
-		dsn:    dsn,
-		sql:    tableName.makeQueries(),
-		logger: log.With().Str("queue", definition.Name).Logger(),
+		name:        definition.Name,
+		dsn:         dsn,
+		sql:         tableName.makeQueries(),
+		retention:   time.Duration(definition.HistoryRetention) * time.Second,
+		maxAttempts: uint(maxAttempts),
+		stop:        make(chan struct{}),
+		limited:     definition.MaxLength > 0 || definition.MaxAge > 0,
+		fairShare:   definition.FairShare,
+		logger:      log.With().Str("queue", definition.Name).Logger(),
 	}
 }
 
@@ -82,16 +100,53 @@ func (q *jobQueue) Start() {
 		log.Panic().Msgf("Failed to create queue table: %s", err)
 	}
 
+	if err := q.upgradeJobqueue(); err != nil {
+		log.Panic().Msgf("Failed to upgrade queue table: %s", err)
+	}
+
 	_, err = q.db.Exec(q.sql.createFailure)
 	if err != nil {
 		log.Panic().Msgf("Failed to create queue failure log table: %s", err)
 	}
 
+	_, err = q.db.Exec(q.sql.createHistory)
+	if err != nil {
+		log.Panic().Msgf("Failed to create queue history table: %s", err)
+	}
+
+	_, err = q.db.Exec(q.sql.createAttempt)
+	if err != nil {
+		log.Panic().Msgf("Failed to create queue attempt log table: %s", err)
+	}
+
+	_, err = q.db.Exec(q.sql.createMetadata)
+	if err != nil {
+		log.Panic().Msgf("Failed to create queue metadata table: %s", err)
+	}
+
+	_, err = q.db.Exec(q.sql.createKey)
+	if err != nil {
+		log.Panic().Msgf("Failed to create queue concurrency key table: %s", err)
+	}
+
+	_, err = q.db.Exec(q.sql.createDebounce)
+	if err != nil {
+		log.Panic().Msgf("Failed to create queue debounce table: %s", err)
+	}
+
 	q.connect()
+
+	if q.retention > 0 {
+		go q.pruneHistory()
+	}
+	if q.limited {
+		go q.refreshBacklog()
+	}
 }
 
 func (q *jobQueue) Stop() <-chan struct{} {
 	atomic.StoreUint32(&q.stopped, 1)
+	close(q.stop)
 
 	stopped := make(chan struct{})
 	go func() {
@@ -110,10 +165,82 @@ func (q *jobQueue) Push(j jobqueue.IncomingJob) (jobqueue.Job, error) {
 	log := q.logger.With().Str("method", "Push").Logger()
 
 	job := &incomingJob{j, 0}
+	query, args := q.insertQuery(job)
+
+	var err error
+	if jobqueue.ConcurrencyKeyOf(j) == "" && jobqueue.MetadataOf(j) == nil {
+		job.id, err = insertJob(q.db, q.sql, j, query, args...)
+	} else {
+		// Insert the job together with its concurrency key and its
+		// metadata so that the job is never grabbed without them.
+		err = q.inTx(func(tx *sql.Tx) error {
+			job.id, err = insertJob(tx, q.sql, j, query, args...)
+			return err
+		})
+	}
+	if err != nil {
+		log.Debug().Msgf("Failed to insert a job: %s", err)
+		return nil, err
+	}
+	q.backlog.add(1)
+
+	return job, nil
+}
+
+func (q *jobQueue) PushDebounced(j jobqueue.IncomingJob, d *jobqueue.Debounce, admit func() error) (jobqueue.Job, bool, error) {
+	log := q.logger.With().Str("method", "PushDebounced").Logger()
+
+	job := &incomingJob{j, 0}
+	coalesced := false
+	if err := q.inTx(func(tx *sql.Tx) error {
+		// Lock the debounce key to serialize pushes with the same key.
+		if _, err := tx.Exec(q.sql.lockDebounceKey, d.Key); err != nil {
+			return err
+		}
+
+		err := tx.QueryRow(q.sql.debouncedJob, d.Key).Scan(&(job.id))
+		if err == nil {
+			coalesced = true
+			if d.Policy == jobqueue.DebounceFirst {
+				return nil
+			}
+			_, err = tx.Exec(q.sql.coalesceJob, job.Payload(), uint64(d.Window)*1000, job.id)
+			return err
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		if err := admit(); err != nil {
+			return err
+		}
+		query, args := q.insertQuery(job)
+		if job.id, err = insertJob(tx, q.sql, j, query, args...); err != nil {
+			return err
+		}
+		_, err = tx.Exec(q.sql.debounceJob, job.id, d.Key)
+		return err
+	}); err != nil {
+		if _, ok := err.(*jobqueue.OverflowError); !ok {
+			log.Debug().Msgf("Failed to push a debounced job: %s", err)
+		}
+		return nil, false, err
+	}
+	if coalesced {
+		return job, true, nil
+	}
+	q.backlog.add(1)
+
+	return job, false, nil
+}
 
-	r, err := q.db.Exec(
-		q.sql.insertJob,
-		job.NextDelay(),
+// insertQuery returns a query and its arguments to insert a job.
+func (q *jobQueue) insertQuery(job *incomingJob) (string, []interface{}) {
+	query, next := q.sql.insertJob, job.NextDelay()
+	if runAt := jobqueue.RunAtOf(job.IncomingJob); runAt > 0 {
+		query, next = q.sql.insertScheduledJob, runAt
+	}
+	args := []interface{}{
+		next,
 		job.RetryCount(),
 		job.RetryDelay(),
 		job.FailCount(),
@@ -121,23 +248,60 @@ func (q *jobQueue) Push(j jobqueue.IncomingJob) (jobqueue.Job, error) {
 		job.URL(),
 		job.Payload(),
 		job.Timeout(),
-	)
+	}
+	if q.fairShare == jobqueue.FairShareTenant {
+		args = append(args, jobqueue.TenantOf(job.IncomingJob))
+	}
+	return query, args
+}
+
+func (q *jobQueue) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := q.db.Begin()
 	if err != nil {
-		log.Debug().Msgf("Failed to insert a job: %s", err)
-		return nil, err
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := f(tx); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
 
+// insertJob inserts a job by a query and its arguments, and the
+// concurrency key and the metadata of the job if any.  They should be
+// inserted in a transaction if the job has either of them.
+func insertJob(e execer, s *sqls, j jobqueue.IncomingJob, query string, args ...interface{}) (uint64, error) {
+	r, err := e.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
 	id, err := r.LastInsertId()
 	if err != nil {
-		log.Debug().Msgf("Cannot get the last insert ID of the new job: %s", err)
-		return nil, err
+		return 0, err
 	}
-	job.id = uint64(id)
-
-	return job, nil
+	if key := jobqueue.ConcurrencyKeyOf(j); key != "" {
+		if _, err := e.Exec(s.insertKey, id, key); err != nil {
+			return 0, err
+		}
+	}
+	if err := insertMetadata(e, s, uint64(id), jobqueue.MetadataOf(j)); err != nil {
+		return 0, err
+	}
+	return uint64(id), nil
 }
 
 func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
+	return q.pop(q.sql.grab, limit)
+}
+
+// pop grabs at most limit jobs preselected by a query, which is
+// followed by the limit, and its arguments.
+func (q *jobQueue) pop(query string, limit uint, args ...interface{}) ([]jobqueue.Job, error) {
 	log := q.logger.With().Str("method", "Pop").Logger()
 
 	if !q.IsActive() {
@@ -156,7 +320,7 @@ func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 	// 1. Pre-SELECT jobs to grab.  We should not `SELECT ~ FOR UPDATE`
 	// here because it blocks `Push()` due to a gap lock.
 	if err := func() error {
-		rows, err := q.dbPop.Query(q.sql.grab + strconv.FormatUint(uint64(limit), 10))
+		rows, err := q.dbPop.Query(query+strconv.FormatUint(uint64(limit), 10), args...)
 		if err != nil {
 			log.Debug().Msgf("Failed to preselect jobs: %s", err)
 			return err
@@ -184,7 +348,7 @@ func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 		return []jobqueue.Job{}, nil
 	}
 
-	results := make([]jobqueue.Job, 0, limit)
+	grabbed := make([]*job, 0, limit)
 	ctx := context.Background()
 	tx, err := q.dbPop.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelReadCommitted, // to avoid gap locks
@@ -206,16 +370,15 @@ func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 		}
 		defer rows.Close()
 
-		for i := 0; rows.Next(); i++ {
+		for rows.Next() {
 			var j job
-			if err := rows.Scan(&(j.id), &(j.category), &(j.url), &(j.payload), &(j.nextTry), &(j.status), &(j.createdAt), &(j.retryCount), &(j.retryDelay), &(j.failCount), &(j.timeout)); err != nil {
+			if err := scanJob(rows, &j); err != nil {
 				log.Debug().Msgf("Failed to scan selected jobs: %s", err)
 				return err
 			}
 			j.status = "grabbed"
 
-			ids[i] = j.id
-			results = append(results, &j)
+			grabbed = append(grabbed, &j)
 		}
 		if err := rows.Err(); err != nil {
 			log.Debug().Msgf("Failed to read selected jobs: %s", err)
@@ -227,17 +390,36 @@ func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 		return nil, err
 	}
 
+	// Emulate `ORDER BY next_try ASC`, which causes `using filesort`
+	// together with `SELECT ~ WHERE ~ IN`.  Earlier jobs should win
+	// their concurrency keys.
+	sort.Slice(grabbed, func(i, j int) bool {
+		return grabbed[i].nextTry < grabbed[j].nextTry
+	})
+
+	// 3. Hold the concurrency keys of jobs, leaving jobs whose keys
+	// are held by others claimed.
+	grabbed, err = holdConcurrencyKeys(tx, q.sql, grabbed)
+	if err != nil {
+		log.Debug().Msgf("Failed to hold concurrency keys of jobs: %s", err)
+		tx.Rollback()
+		return nil, err
+	}
+
 	// The number of jobs may reduce when they are grabbed in another
 	// thread right after the preselection.  This is unlikely to
 	// happen to a single dispatcher, though.
-	if len(results) <= 0 {
+	if len(grabbed) <= 0 {
 		tx.Rollback()
-		return results, nil
+		return []jobqueue.Job{}, nil
+	}
+	placeholders = placeholders[:len(grabbed)]
+	ids = ids[:len(grabbed)]
+	for i, j := range grabbed {
+		ids[i] = j.id
 	}
-	placeholders = placeholders[:len(results)]
-	ids = ids[:len(results)]
 
-	// 3. UPDATE the status of jobs.
+	// 4. UPDATE the status of jobs.
 	if err := func() error {
 		_, err = tx.Exec(
 			q.sql.launch+"("+strings.Join(placeholders, ",")+")",
@@ -254,12 +436,23 @@ func (q *jobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 	}
 
 	tx.Commit()
+	q.backlog.add(-int64(len(grabbed)))
 
-	// Emulate `ORDER BY next_try ASC`, which causes `using filesort`
-	// together with `SELECT ~ WHERE ~ IN`.
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].(*job).nextTry < results[j].(*job).nextTry
-	})
+	// Grabbed jobs are no longer waiting to be coalesced.  This is out
+	// of the transaction to avoid a deadlock with PushDebounced().
+	if err := deleteDebounceKeys(q.db, q.sql, ids...); err != nil {
+		log.Error().Msgf("Failed to delete debounce keys of jobs: %s", err)
+	}
+
+	metadata, err := findMetadata(q.db, q.sql, ids)
+	if err != nil {
+		log.Error().Msgf("Failed to select metadata of jobs: %s", err)
+	}
+	results := make([]jobqueue.Job, 0, len(grabbed))
+	for _, j := range grabbed {
+		j.metadata = metadata[j.id]
+		results = append(results, j)
+	}
 
 	return results, nil
 }
@@ -276,6 +469,24 @@ func (q *jobQueue) Delete(completedJob jobqueue.Job) {
 	if _, err := q.db.Exec(q.sql.deleteJob, j.id); err != nil {
 		log.Error().Msgf("Failed to delete a job: %s", err)
 	}
+	if j.metadata != nil {
+		if err := deleteMetadata(q.db, q.sql, j.id); err != nil {
+			log.Error().Msgf("Failed to delete metadata of a job: %s", err)
+		}
+	}
+	if j.concurrencyKey != "" {
+		if err := deleteConcurrencyKeys(q.db, q.sql, j.id); err != nil {
+			log.Error().Msgf("Failed to delete the concurrency key of a job: %s", err)
+		}
+	}
+
+	// Attempts of a finished job are kept together with the history.
+	if q.retention == 0 {
+		attempts := &attemptLog{db: q.db, sql: q.sql}
+		if err := attempts.delete(j.id); err != nil {
+			log.Error().Msgf("Failed to delete attempts of a job: %s", err)
+		}
+	}
 }
 
 func (q *jobQueue) Update(completedJob jobqueue.Job, next jobqueue.NextInfo) {
@@ -295,6 +506,13 @@ func (q *jobQueue) Update(completedJob jobqueue.Job, next jobqueue.NextInfo) {
 		j.id,
 	); err != nil {
 		log.Error().Msgf("Failed to update a job: %s", err)
+	} else {
+		q.backlog.add(1)
+	}
+	if j.concurrencyKey != "" {
+		if err := releaseConcurrencyKeys(q.db, q.sql, j.id); err != nil {
+			log.Error().Msgf("Failed to release the concurrency key of a job: %s", err)
+		}
 	}
 }
 
@@ -354,6 +572,10 @@ func (q *jobQueue) Recover() {
 				log.Error().Msgf("Failed to recover orphan jobs: %s", err)
 				return err
 			}
+			if err := releaseConcurrencyKeys(q.dbPop, q.sql, ids...); err != nil {
+				log.Error().Msgf("Failed to release concurrency keys of orphan jobs: %s", err)
+				return err
+			}
 			return nil
 		}(); err != nil {
 			return
@@ -369,6 +591,39 @@ func (q *jobQueue) FailureLog() jobqueue.FailureLog {
 	return &failureLog{db: q.db, sql: q.sql}
 }
 
+func (q *jobQueue) History() jobqueue.History {
+	return &history{db: q.db, sql: q.sql}
+}
+
+func (q *jobQueue) AttemptLog() jobqueue.AttemptLog {
+	return &attemptLog{db: q.db, sql: q.sql, maxAttempts: q.maxAttempts}
+}
+
+// pruneHistory periodically deletes finished jobs older than the
+// retention period until the queue stops.
+func (q *jobQueue) pruneHistory() {
+	log := q.logger.With().Str("method", "pruneHistory").Logger()
+
+	h := &history{db: q.db, sql: q.sql}
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		pruned, err := h.prune(time.Now().Add(-q.retention))
+		if err != nil {
+			log.Error().Msgf("Failed to prune finished jobs: %s", err)
+		} else if pruned > 0 {
+			log.Debug().Msgf("Pruned %d finished job(s)", pruned)
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (q *jobQueue) Node() (*jobqueue.Node, error) {
 	query := `
 		SELECT ID, HOST FROM information_schema.processlist
@@ -415,3 +670,8 @@ func (q *jobQueue) disconnect() {
 		q.dbPop = nil
 	}
 }
+
+// scanJob scans a row of the grabbed query into a job.
+func scanJob(row scanner, j *job) error {
+	return row.Scan(&(j.id), &(j.category), &(j.url), &(j.payload), &(j.nextTry), &(j.status), &(j.createdAt), &(j.retryCount), &(j.retryDelay), &(j.failCount), &(j.timeout))
+}
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/coosir/middleman/jobqueue"
+)
+
+func insertMetadata(e execer, s *sqls, jobID uint64, metadata *jobqueue.Metadata) error {
+	if metadata == nil {
+		return nil
+	}
+
+	m, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Exec(s.insertMetadata, jobID, m)
+	return err
+}
+
+// findMetadata returns the metadata of jobs which have one.
+func findMetadata(db *sql.DB, s *sqls, jobIDs []interface{}) (map[uint64]*jobqueue.Metadata, error) {
+	if len(jobIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(s.metadata+"("+placeholders(len(jobIDs))+")", jobIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadataByID := make(map[uint64]*jobqueue.Metadata, len(jobIDs))
+	for rows.Next() {
+		var id uint64
+		var m []byte
+		if err := rows.Scan(&id, &m); err != nil {
+			return nil, err
+		}
+
+		var metadata jobqueue.Metadata
+		if err := json.Unmarshal(m, &metadata); err != nil {
+			return nil, err
+		}
+		metadataByID[id] = &metadata
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return metadataByID, nil
+}
+
+func deleteMetadata(db *sql.DB, s *sqls, jobIDs ...interface{}) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+	_, err := db.Exec(s.deleteMetadata+"("+placeholders(len(jobIDs))+")", jobIDs...)
+	return err
+}
@@ -53,6 +53,22 @@ func (q *primaryBackupJobQueue) Pop(limit uint) ([]jobqueue.Job, error) {
 	return q.jobQueue.Pop(limit)
 }
 
+func (q *primaryBackupJobQueue) ReadyGroups(by string) ([]string, error) {
+	if !q.IsActive() {
+		return nil, &jobqueue.InactiveError{}
+	}
+
+	return q.jobQueue.ReadyGroups(by)
+}
+
+func (q *primaryBackupJobQueue) PopGroup(by, group string, limit uint) ([]jobqueue.Job, error) {
+	if !q.IsActive() {
+		return nil, &jobqueue.InactiveError{}
+	}
+
+	return q.jobQueue.PopGroup(by, group, limit)
+}
+
 func (q *primaryBackupJobQueue) Node() (*jobqueue.Node, error) {
 	query := `
 		SELECT ID, HOST FROM information_schema.processlist
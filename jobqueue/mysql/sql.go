@@ -8,6 +8,7 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/coosir/middleman/jobqueue"
 	"github.com/coosir/middleman/model"
 )
 
@@ -17,6 +18,14 @@ func newTableName(definition *model.Queue) *tableName {
 	return &tableName{
 		JobQueue: strings.Join([]string{"middleman_jq(", name, ")"}, ""),
 		Failure:  strings.Join([]string{"middleman_jq_fail(", name, ")"}, ""),
+		History:  strings.Join([]string{"middleman_jq_history(", name, ")"}, ""),
+		Attempt:  strings.Join([]string{"middleman_jq_attempt(", name, ")"}, ""),
+		Metadata: strings.Join([]string{"middleman_jq_meta(", name, ")"}, ""),
+
+		ConcurrencyKey: strings.Join([]string{"middleman_jq_key(", name, ")"}, ""),
+		Debounce:       strings.Join([]string{"middleman_jq_debounce(", name, ")"}, ""),
+
+		Tenant: definition.FairShare == jobqueue.FairShareTenant,
 	}
 }
 
@@ -24,16 +33,33 @@ type tableName struct {
 	JobQueue string
 	Payload  string
 	Failure  string
+	History  string
+	Attempt  string
+	Metadata string
+
+	ConcurrencyKey string
+	Debounce       string
+
+	// The job list table has the tenant column only for the queue
+	// shared fairly among tenants, so that nodes of older versions
+	// can still insert jobs into the others.
+	Tenant bool
 }
 
 func (tn *tableName) makeQueries() *sqls {
 	return &sqls{
 		createJobqueue:     tn.makeQuery(tmplCreateJobqueue),
 		createFailure:      tn.makeQuery(tmplCreateFailure),
+		createHistory:      tn.makeQuery(tmplCreateHistory),
+		createAttempt:      tn.makeQuery(tmplCreateAttempt),
+		createMetadata:     tn.makeQuery(tmplCreateMetadata),
+		createKey:          tn.makeQuery(tmplCreateKey),
+		createDebounce:     tn.makeQuery(tmplCreateDebounce),
 		grab:               tn.makeQuery(tmplGrabJobs),
 		grabbed:            tn.makeQuery(tmplGrabbedJobs),
 		launch:             tn.makeQuery(tmplLaunchJobs),
 		insertJob:          tn.makeQuery(tmplInsertJob),
+		insertScheduledJob: tn.makeQuery(tmplInsertScheduledJob),
 		insertFailedJob:    tn.makeQuery(tmplInsertFailedJob),
 		deleteFailedJob:    tn.makeQuery(tmplDeleteFailedJob),
 		deleteJob:          tn.makeQuery(tmplDeleteJob),
@@ -46,6 +72,40 @@ func (tn *tableName) makeQueries() *sqls {
 		failedJob:          tn.makeQuery(tmplFailedJob),
 		failedJobs:         tn.makeQuery(tmplFailedJobs),
 		recentlyFailedJobs: tn.makeQuery(tmplRecentlyFailedJobs),
+		insertHistory:      tn.makeQuery(tmplInsertHistory),
+		history:            tn.makeQuery(tmplHistory),
+		pruneHistory:       tn.makeQuery(tmplPruneHistory),
+		expiredHistory:     tn.makeQuery(tmplExpiredHistory),
+		insertAttempt:      tn.makeQuery(tmplInsertAttempt),
+		truncateAttempts:   tn.makeQuery(tmplTruncateAttempts),
+		deleteAttempts:     tn.makeQuery(tmplDeleteAttempts),
+		attempts:           tn.makeQuery(tmplAttempts),
+		lastAttempts:       tn.makeQuery(tmplLastAttempts),
+		insertMetadata:     tn.makeQuery(tmplInsertMetadata),
+		deleteMetadata:     tn.makeQuery(tmplDeleteMetadata),
+		metadata:           tn.makeQuery(tmplMetadata),
+		countJobs:          tn.makeQuery(tmplCountJobs),
+		oldestJob:          tn.makeQuery(tmplOldestJob),
+		dropJob:            tn.makeQuery(tmplDropJob),
+		insertKey:          tn.makeQuery(tmplInsertKey),
+		keys:               tn.makeQuery(tmplKeys),
+		holdKey:            tn.makeQuery(tmplHoldKey),
+		releaseKeys:        tn.makeQuery(tmplReleaseKeys),
+		deleteKeys:         tn.makeQuery(tmplDeleteKeys),
+		lockDebounceKey:    tn.makeQuery(tmplLockDebounceKey),
+		debouncedJob:       tn.makeQuery(tmplDebouncedJob),
+		coalesceJob:        tn.makeQuery(tmplCoalesceJob),
+		debounceJob:        tn.makeQuery(tmplDebounceJob),
+		deleteDebounceKeys: tn.makeQuery(tmplDeleteDebounceKeys),
+		addTenant:          tn.makeQuery(tmplAddTenant),
+		addFairCategory:    tn.makeQuery(tmplAddFairCategory),
+		addFairTenant:      tn.makeQuery(tmplAddFairTenant),
+		hasColumn:          tn.makeQuery(tmplHasColumn),
+		hasIndex:           tn.makeQuery(tmplHasIndex),
+		readyCategories:    tn.makeQuery(tmplReadyCategories),
+		readyTenants:       tn.makeQuery(tmplReadyTenants),
+		grabCategory:       tn.makeQuery(tmplGrabCategoryJobs),
+		grabTenant:         tn.makeQuery(tmplGrabTenantJobs),
 	}
 }
 
@@ -58,10 +118,16 @@ func (tn *tableName) makeQuery(tmpl *template.Template) string {
 type sqls struct {
 	createJobqueue     string
 	createFailure      string
+	createHistory      string
+	createAttempt      string
+	createMetadata     string
+	createKey          string
+	createDebounce     string
 	grab               string
 	grabbed            string
 	launch             string
 	insertJob          string
+	insertScheduledJob string
 	insertFailedJob    string
 	deleteFailedJob    string
 	deleteJob          string
@@ -74,16 +140,56 @@ type sqls struct {
 	failedJob          string
 	failedJobs         string
 	recentlyFailedJobs string
+	insertHistory      string
+	history            string
+	pruneHistory       string
+	expiredHistory     string
+	insertAttempt      string
+	truncateAttempts   string
+	deleteAttempts     string
+	attempts           string
+	lastAttempts       string
+	insertMetadata     string
+	deleteMetadata     string
+	metadata           string
+	countJobs          string
+	oldestJob          string
+	dropJob            string
+	insertKey          string
+	keys               string
+	holdKey            string
+	releaseKeys        string
+	deleteKeys         string
+	lockDebounceKey    string
+	debouncedJob       string
+	coalesceJob        string
+	debounceJob        string
+	deleteDebounceKeys string
+	addTenant          string
+	addFairCategory    string
+	addFairTenant      string
+	hasColumn          string
+	hasIndex           string
+	readyCategories    string
+	readyTenants       string
+	grabCategory       string
+	grabTenant         string
 }
 
 var (
 	invalidTablenameChars  *regexp.Regexp
 	tmplCreateJobqueue     *template.Template
 	tmplCreateFailure      *template.Template
+	tmplCreateHistory      *template.Template
+	tmplCreateAttempt      *template.Template
+	tmplCreateMetadata     *template.Template
+	tmplCreateKey          *template.Template
+	tmplCreateDebounce     *template.Template
 	tmplGrabJobs           *template.Template
 	tmplGrabbedJobs        *template.Template
 	tmplLaunchJobs         *template.Template
 	tmplInsertJob          *template.Template
+	tmplInsertScheduledJob *template.Template
 	tmplInsertFailedJob    *template.Template
 	tmplDeleteFailedJob    *template.Template
 	tmplDeleteJob          *template.Template
@@ -96,6 +202,40 @@ var (
 	tmplFailedJob          *template.Template
 	tmplFailedJobs         *template.Template
 	tmplRecentlyFailedJobs *template.Template
+	tmplInsertHistory      *template.Template
+	tmplHistory            *template.Template
+	tmplPruneHistory       *template.Template
+	tmplExpiredHistory     *template.Template
+	tmplInsertAttempt      *template.Template
+	tmplTruncateAttempts   *template.Template
+	tmplDeleteAttempts     *template.Template
+	tmplAttempts           *template.Template
+	tmplLastAttempts       *template.Template
+	tmplInsertMetadata     *template.Template
+	tmplDeleteMetadata     *template.Template
+	tmplMetadata           *template.Template
+	tmplCountJobs          *template.Template
+	tmplOldestJob          *template.Template
+	tmplDropJob            *template.Template
+	tmplInsertKey          *template.Template
+	tmplKeys               *template.Template
+	tmplHoldKey            *template.Template
+	tmplReleaseKeys        *template.Template
+	tmplDeleteKeys         *template.Template
+	tmplLockDebounceKey    *template.Template
+	tmplDebouncedJob       *template.Template
+	tmplCoalesceJob        *template.Template
+	tmplDebounceJob        *template.Template
+	tmplDeleteDebounceKeys *template.Template
+	tmplAddTenant          *template.Template
+	tmplAddFairCategory    *template.Template
+	tmplAddFairTenant      *template.Template
+	tmplHasColumn          *template.Template
+	tmplHasIndex           *template.Template
+	tmplReadyCategories    *template.Template
+	tmplReadyTenants       *template.Template
+	tmplGrabCategoryJobs   *template.Template
+	tmplGrabTenantJobs     *template.Template
 )
 
 func mustLoadTemplate(name string) *template.Template {
@@ -115,10 +255,16 @@ func init() {
 	invalidTablenameChars = regexp.MustCompile("[^0-9a-z_]")
 	tmplCreateJobqueue = mustLoadTemplate("schema/job_queue")
 	tmplCreateFailure = mustLoadTemplate("schema/job_failure")
+	tmplCreateHistory = mustLoadTemplate("schema/job_history")
+	tmplCreateAttempt = mustLoadTemplate("schema/job_attempt")
+	tmplCreateMetadata = mustLoadTemplate("schema/job_metadata")
+	tmplCreateKey = mustLoadTemplate("schema/job_concurrency_key")
+	tmplCreateDebounce = mustLoadTemplate("schema/job_debounce")
 	tmplGrabJobs = mustLoadTemplate("query/grab_jobs")
 	tmplGrabbedJobs = mustLoadTemplate("query/grabbed_jobs")
 	tmplLaunchJobs = mustLoadTemplate("query/launch_jobs")
 	tmplInsertJob = mustLoadTemplate("query/insert_job")
+	tmplInsertScheduledJob = mustLoadTemplate("query/insert_scheduled_job")
 	tmplInsertFailedJob = mustLoadTemplate("query/insert_failed_job")
 	tmplDeleteFailedJob = mustLoadTemplate("query/delete_failed_job")
 	tmplDeleteJob = mustLoadTemplate("query/delete_job")
@@ -131,4 +277,38 @@ func init() {
 	tmplFailedJob = mustLoadTemplate("query/failed_job")
 	tmplFailedJobs = mustLoadTemplate("query/failed_jobs")
 	tmplRecentlyFailedJobs = mustLoadTemplate("query/recently_failed_jobs")
+	tmplInsertHistory = mustLoadTemplate("query/insert_history")
+	tmplHistory = mustLoadTemplate("query/history")
+	tmplPruneHistory = mustLoadTemplate("query/prune_history")
+	tmplExpiredHistory = mustLoadTemplate("query/expired_history")
+	tmplInsertAttempt = mustLoadTemplate("query/insert_attempt")
+	tmplTruncateAttempts = mustLoadTemplate("query/truncate_attempts")
+	tmplDeleteAttempts = mustLoadTemplate("query/delete_attempts")
+	tmplAttempts = mustLoadTemplate("query/attempts")
+	tmplLastAttempts = mustLoadTemplate("query/last_attempts")
+	tmplInsertMetadata = mustLoadTemplate("query/insert_metadata")
+	tmplDeleteMetadata = mustLoadTemplate("query/delete_metadata")
+	tmplMetadata = mustLoadTemplate("query/metadata")
+	tmplCountJobs = mustLoadTemplate("query/count_jobs")
+	tmplOldestJob = mustLoadTemplate("query/oldest_job")
+	tmplDropJob = mustLoadTemplate("query/drop_job")
+	tmplInsertKey = mustLoadTemplate("query/insert_concurrency_key")
+	tmplKeys = mustLoadTemplate("query/concurrency_keys")
+	tmplHoldKey = mustLoadTemplate("query/hold_concurrency_key")
+	tmplReleaseKeys = mustLoadTemplate("query/release_concurrency_keys")
+	tmplDeleteKeys = mustLoadTemplate("query/delete_concurrency_keys")
+	tmplLockDebounceKey = mustLoadTemplate("query/lock_debounce_key")
+	tmplDebouncedJob = mustLoadTemplate("query/debounced_job")
+	tmplCoalesceJob = mustLoadTemplate("query/coalesce_job")
+	tmplDebounceJob = mustLoadTemplate("query/debounce_job")
+	tmplDeleteDebounceKeys = mustLoadTemplate("query/delete_debounce_keys")
+	tmplAddTenant = mustLoadTemplate("schema/job_queue_tenant")
+	tmplAddFairCategory = mustLoadTemplate("schema/job_queue_fair_category")
+	tmplAddFairTenant = mustLoadTemplate("schema/job_queue_fair_tenant")
+	tmplHasColumn = mustLoadTemplate("query/has_column")
+	tmplHasIndex = mustLoadTemplate("query/has_index")
+	tmplReadyCategories = mustLoadTemplate("query/ready_categories")
+	tmplReadyTenants = mustLoadTemplate("query/ready_tenants")
+	tmplGrabCategoryJobs = mustLoadTemplate("query/grab_category_jobs")
+	tmplGrabTenantJobs = mustLoadTemplate("query/grab_tenant_jobs")
 }
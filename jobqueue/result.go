@@ -1,5 +1,7 @@
 package jobqueue
 
+import "time"
+
 const (
 	// ResultStatusSuccess means that the job is successfully processed.
 	ResultStatusSuccess = "success"
@@ -14,6 +16,15 @@ const (
 	// ResultStatusInternalFailure means that the job is failed before
 	// processing it in some internal reason.
 	ResultStatusInternalFailure = "internal-failure"
+
+	// ResultStatusExpired means that the job has passed its
+	// expiration time and will never be dispatched again.
+	ResultStatusExpired = "expired"
+
+	// ResultStatusDropped means that the job has been dropped from a
+	// full queue to make room for a new job and will never be
+	// dispatched.
+	ResultStatusDropped = "dropped"
 )
 
 // Result describes the result of a processed job.
@@ -21,6 +32,11 @@ type Result struct {
 	Status  string `json:"status"`
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+
+	// When the job was dispatched and how long it took.  These are
+	// filled by a dispatcher.
+	StartedAt time.Time     `json:"-"`
+	Elapsed   time.Duration `json:"-"`
 }
 
 // IsSuccess returns if the job succeeded
@@ -41,7 +57,7 @@ func (rslt *Result) IsPermanentFailure() bool {
 // IsFinished returns if the job can be retried or not.
 func (rslt *Result) IsFinished() bool {
 	switch rslt.Status {
-	case ResultStatusSuccess, ResultStatusPermanentFailure:
+	case ResultStatusSuccess, ResultStatusPermanentFailure, ResultStatusExpired, ResultStatusDropped:
 		return true
 	default:
 		return false
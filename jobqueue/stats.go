@@ -16,6 +16,8 @@ type Stats struct {
 	TotalPermanentFailures int64 `json:"total_permanent_failures"`
 	TotalCompletes         int64 `json:"total_completes"`
 	TotalElapsed           int64 `json:"total_elapsed"`
+	TotalOverflows         int64 `json:"total_overflows"`
+	TotalCoalesces         int64 `json:"total_coalesces"`
 	PushesPerSecond        int64 `json:"pushes_per_second"`
 	PopsPerSecond          int64 `json:"pops_per_second"`
 }
@@ -28,6 +30,8 @@ type stats struct {
 	totalPermanentFailures int64
 	totalCompletes         int64
 	totalElapsed           int64
+	totalOverflows         int64
+	totalCoalesces         int64
 	pushesPerSecond        *ratecounter.RateCounter
 	popsPerSecond          *ratecounter.RateCounter
 }
@@ -69,6 +73,14 @@ func (s *stats) elapsed(t int64) {
 	atomic.AddInt64(&s.totalElapsed, t)
 }
 
+func (s *stats) overflow(num int64) {
+	atomic.AddInt64(&s.totalOverflows, num)
+}
+
+func (s *stats) coalesce(num int64) {
+	atomic.AddInt64(&s.totalCoalesces, num)
+}
+
 func (s *stats) export() *Stats {
 	return &Stats{
 		TotalPushes:            atomic.LoadInt64(&s.totalPushes),
@@ -78,6 +90,8 @@ func (s *stats) export() *Stats {
 		TotalPermanentFailures: atomic.LoadInt64(&s.totalPermanentFailures),
 		TotalCompletes:         atomic.LoadInt64(&s.totalCompletes),
 		TotalElapsed:           atomic.LoadInt64(&s.totalElapsed),
+		TotalOverflows:         atomic.LoadInt64(&s.totalOverflows),
+		TotalCoalesces:         atomic.LoadInt64(&s.totalCoalesces),
 		PushesPerSecond:        s.pushesPerSecond.Rate(),
 		PopsPerSecond:          s.popsPerSecond.Rate(),
 	}
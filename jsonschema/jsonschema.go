@@ -0,0 +1,88 @@
+// Package jsonschema validates JSON values against JSON Schemas which
+// do not refer to other documents.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	validator "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	schema *validator.Schema
+}
+
+// The URL of a schema being compiled, against which references in it
+// are resolved.
+const schemaURL = "urn:middleman:schema"
+
+// Compile parses a JSON Schema, which is of draft 2020-12 unless
+// $schema specifies another draft.  It returns an error if the schema
+// is invalid or refers to another document, which is never loaded.
+func Compile(schema []byte) (s *Schema, err error) {
+	c := validator.NewCompiler()
+	c.Draft = validator.Draft2020
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("Cannot refer to %s", url)
+	}
+	if err := c.AddResource(schemaURL, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+
+	// The validator panics on some invalid regular expressions.
+	defer func() {
+		if r := recover(); r != nil {
+			s, err = nil, fmt.Errorf("Invalid schema: %v", r)
+		}
+	}()
+	compiled, err := c.Compile(schemaURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{compiled}, nil
+}
+
+// ValidationError describes the first violation of a schema.
+type ValidationError struct {
+	Pointer string // JSON Pointer to the violating value
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("#%s: %s", e.Pointer, e.Message)
+}
+
+// Validate returns a *ValidationError if a JSON value does not conform
+// to the schema, or another error if the value is not valid JSON.
+func (s *Schema) Validate(value []byte) error {
+	v, err := decode(value)
+	if err != nil {
+		return err
+	}
+	err = s.schema.Validate(v)
+	if verr, ok := err.(*validator.ValidationError); ok {
+		for len(verr.Causes) > 0 {
+			verr = verr.Causes[0]
+		}
+		return &ValidationError{Pointer: verr.InstanceLocation, Message: verr.Message}
+	}
+	return err
+}
+
+func decode(b []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("Trailing data after a JSON value")
+	}
+	return v, nil
+}
@@ -0,0 +1,148 @@
+package jsonschema
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	s, err := Compile([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"required": ["user_id", "items"],
+		"properties": {
+			"user_id": {"type": "integer", "minimum": 1},
+			"email": {"type": "string", "pattern": "@", "maxLength": 32},
+			"items": {
+				"type": "array",
+				"minItems": 1,
+				"uniqueItems": true,
+				"items": {
+					"type": "object",
+					"properties": {
+						"sku": {"type": "string", "minLength": 1},
+						"quantity": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.5}
+					},
+					"additionalProperties": false
+				}
+			},
+			"priority": {"enum": ["low", "high", null]},
+			"a/b": {"not": {"const": 0}}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		payload string
+		pointer string // empty if valid
+	}{
+		{`{"user_id": 1, "items": [{"sku": "A", "quantity": 1.5}]}`, ""},
+		{`{"user_id": 1.0, "items": [{"sku": "A"}], "priority": null, "a/b": 1}`, ""},
+		{`{"user_id": "1", "items": [{}]}`, "/user_id"},
+		{`{"user_id": 0, "items": [{}]}`, "/user_id"},
+		{`{"user_id": 1.5, "items": [{}]}`, "/user_id"},
+		{`{"user_id": 1, "items": []}`, "/items"},
+		{`{"user_id": 1, "items": [{}, {}]}`, "/items"},
+		{`{"user_id": 1, "items": [{"sku": ""}]}`, "/items/0/sku"},
+		{`{"user_id": 1, "items": [{"quantity": 0.3}]}`, "/items/0/quantity"},
+		{`{"user_id": 1, "items": [{"quantity": 0}]}`, "/items/0/quantity"},
+		{`{"user_id": 1, "items": [{"price": 1}]}`, "/items/0"},
+		{`{"user_id": 1, "items": [{}], "email": "example.com"}`, "/email"},
+		{`{"user_id": 1, "items": [{}], "priority": "middle"}`, "/priority"},
+		{`{"user_id": 1, "items": [{}], "a/b": 0.0}`, "/a~1b"},
+	} {
+		err := s.Validate([]byte(c.payload))
+		if c.pointer == "" {
+			if err != nil {
+				t.Errorf("%s should be valid: %s", c.payload, err)
+			}
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s should be invalid: %v", c.payload, err)
+			continue
+		}
+		if verr.Pointer != c.pointer {
+			t.Errorf("%s should be invalid at %s: %s", c.payload, c.pointer, verr)
+		}
+	}
+	if verr, ok := s.Validate([]byte(`{"user_id": 1}`)).(*ValidationError); !ok || verr.Pointer != "" {
+		t.Errorf("A missing property should be reported at the object: %v", verr)
+	}
+
+	if err := s.Validate([]byte(`{"user_id": `)); err == nil {
+		t.Error("Broken JSON should be invalid")
+	}
+}
+
+func TestValidateCombinations(t *testing.T) {
+	s, err := Compile([]byte(`{
+		"anyOf": [{"type": "string"}, {"type": "integer"}],
+		"oneOf": [{"type": "string", "maxLength": 3}, {"type": "integer", "minimum": 10}],
+		"allOf": [true]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for payload, valid := range map[string]bool{
+		`"abc"`:  true,
+		`"abcd"`: false,
+		`10`:     true,
+		`5`:      false,
+		`1.5`:    false,
+		`null`:   false,
+	} {
+		if err := s.Validate([]byte(payload)); (err == nil) != valid {
+			t.Errorf("%s: valid should be %v: %v", payload, valid, err)
+		}
+	}
+
+	s, err = Compile([]byte(`false`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate([]byte(`{}`)); err == nil {
+		t.Error("The false schema should allow nothing")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, schema := range []string{
+		``,
+		`1`,
+		`{"$ref": "#/$defs/item"}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+		`{"$ref": "file:///etc/passwd"}`,
+		`{"type": "float"}`,
+		`{"required": "id"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"patternProperties": {"(": true}}`,
+		`{"anyOf": []}`,
+		`{"multipleOf": 0}`,
+		`{} {}`,
+	} {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("%s should be invalid", schema)
+		}
+	}
+}
+
+func TestCompileReference(t *testing.T) {
+	s, err := Compile([]byte(`{
+		"type": "array",
+		"items": {"$ref": "#/$defs/item"},
+		"$defs": {"item": {"type": "integer"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate([]byte(`[1, 2]`)); err != nil {
+		t.Errorf("Items should be valid by a reference: %s", err)
+	}
+	if verr, ok := s.Validate([]byte(`[1, "2"]`)).(*ValidationError); !ok || verr.Pointer != "/1" {
+		t.Errorf("An item should be invalid by a reference: %v", verr)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -37,11 +38,28 @@ func main() {
 		os.Exit(0)
 	}
 
-	for _, k := range config.Keys() {
-		config.Set(k, *args.settings[k])
+	for k, v := range args.settings {
+		config.Set(k, *v)
+	}
+
+	if file := config.Get("config"); file != "" {
+		if err := config.LoadFile(file); err != nil {
+			_, _ = fmt.Fprintln(out, err.Error())
+			os.Exit(1)
+		}
+		initDefaultConfig() // which may depend on the file
+	}
+
+	if args.checkConfig {
+		os.Exit(checkConfig(os.Stdout, out))
 	}
 
 	accessLog := initLogging(syscall.SIGUSR1)
+
+	if args.definitionsDryRun {
+		os.Exit(planDefinitions(os.Stdout))
+	}
+
 	initProcess()
 	dispatcher.Init()
 	web.Init()
@@ -50,8 +68,10 @@ func main() {
 }
 
 type cmdArgs struct {
-	showVersion bool
-	settings    map[string]*string
+	showVersion       bool
+	checkConfig       bool
+	definitionsDryRun bool
+	settings          map[string]*string // only those given explicitly
 }
 
 func parseCmdArgs(args []string) (*cmdArgs, error) {
@@ -72,6 +92,12 @@ func parseCmdArgs(args []string) (*cmdArgs, error) {
 	}
 	flags.BoolVar(&parsed.showVersion, "v", false, "")
 	flags.BoolVar(&parsed.showVersion, "version", false, "")
+	flags.BoolVar(&parsed.definitionsDryRun, "definitions-dry-run", false, "")
+
+	if len(args) >= 2 && args[0] == "config" && args[1] == "check" {
+		parsed.checkConfig = true
+		args = args[2:]
+	}
 
 	for _, k := range config.Keys() {
 		p := new(string)
@@ -83,6 +109,21 @@ func parseCmdArgs(args []string) (*cmdArgs, error) {
 	if err := flags.Parse(args); err != nil {
 		return nil, err
 	}
+	if flags.NArg() > 0 {
+		err := fmt.Errorf("Unknown command: %s", strings.Join(flags.Args(), " "))
+		_, _ = fmt.Fprintln(out, err.Error())
+		flags.Usage()
+		return nil, err
+	}
+
+	given := make(map[string]*string)
+	flags.Visit(func(f *flag.Flag) {
+		k := strings.Replace(f.Name, "-", "_", -1)
+		if p, ok := parsed.settings[k]; ok {
+			given[k] = p
+		}
+	})
+	parsed.settings = given
 
 	return parsed, nil
 }
@@ -190,6 +231,10 @@ func startServer(accessLogWriter io.Writer) {
 
 	repos := repository.NewRepositories()
 	dService := service.NewService(repos)
+	if err := applyDefinitions(dService); err != nil {
+		log.Panic().Msg(err.Error())
+	}
+	initReload(dService, syscall.SIGHUP)
 
 	app := &web.Application{
 		AccessLogWriter:   accessLogWriter,
@@ -201,6 +246,115 @@ func startServer(accessLogWriter io.Writer) {
 	app.Serve()
 }
 
+func loadDefinitions() (*service.Definitions, bool, error) {
+	defs, err := service.LoadDefinitions(config.Get("definitions"))
+	if err != nil {
+		return nil, false, err
+	}
+	prune, err := strconv.ParseBool(config.Get("definitions_prune"))
+	if err != nil {
+		return nil, false, err
+	}
+	return defs, prune, nil
+}
+
+// applyDefinitions reconciles queues and routings with the definitions
+// file if it is configured.
+func applyDefinitions(s *service.Service) error {
+	if config.Get("definitions") == "" {
+		return nil
+	}
+	defs, prune, err := loadDefinitions()
+	if err != nil {
+		return err
+	}
+	changes, err := s.ApplyDefinitions(defs, prune)
+	for _, c := range changes {
+		log.Info().Msgf("Definitions: %s", c.String())
+	}
+	return err
+}
+
+// initReload reloads the configuration and reapplies the definitions
+// file whenever sig is received.
+func initReload(s *service.Service, sig syscall.Signal) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, sig)
+	go func() {
+		for {
+			sig := <-sigC
+			log.Info().Msgf("Received signal %q; reload configuration", sig)
+			reload(s)
+		}
+	}()
+}
+
+// reload rereads the configuration, applies the changes which do not
+// require a restart, and reapplies the definitions.
+func reload(s *service.Service) {
+	applied, refused, err := config.Reload()
+	if err != nil {
+		log.Error().Msgf("Cannot reload configuration: %s", err)
+		return
+	}
+	for _, c := range applied {
+		log.Info().Msgf("Configuration: %s", c.String())
+	}
+	for _, c := range refused {
+		log.Warn().Msgf("Configuration: %s requires restart; not applied", c.String())
+	}
+
+	zerolog.SetGlobalLevel(logWriter.ParseLevel(config.Get("error_log_level"), zerolog.InfoLevel))
+	logger.SetLevel(logWriter.ParseLevel(config.Get("queue_log_level"), zerolog.InfoLevel))
+	if err := dispatcher.Reload(); err != nil {
+		log.Error().Msg(err.Error())
+	}
+	if err := s.Reconfigure(); err != nil {
+		log.Error().Msg(err.Error())
+	}
+	if err := applyDefinitions(s); err != nil {
+		log.Error().Msg(err.Error())
+	}
+}
+
+// checkConfig writes the effective configuration to out with secrets
+// redacted and errors in it to errOut, and returns an exit status.
+func checkConfig(out io.Writer, errOut io.Writer) int {
+	settings, errs := config.Check()
+	for _, s := range settings {
+		_, _ = fmt.Fprintf(out, "%s = %q\n", s.Name, s.Redacted())
+	}
+	for _, err := range errs {
+		_, _ = fmt.Fprintln(errOut, err.Error())
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// planDefinitions writes changes to reconcile with the definitions
+// file to out without applying them and returns an exit status.
+func planDefinitions(out io.Writer) int {
+	defs, prune, err := loadDefinitions()
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return 1
+	}
+	changes, err := service.PlanDefinitions(repository.NewRepositories(), defs, prune)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		return 1
+	}
+	for _, c := range changes {
+		_, _ = fmt.Fprintln(out, c.String())
+		for _, d := range c.Diffs {
+			_, _ = fmt.Fprintln(out, "  "+d)
+		}
+	}
+	return 0
+}
+
 func versionString(sep string) string {
 	var prerelease string
 	if Prerelease != "" {
@@ -217,12 +371,21 @@ func versionString(sep string) string {
 
 var (
 	helpText = `Usage: middleman [options]
+       middleman config check [options]
 
   A lightweight, high-performance, stand-alone job queue system.
 
+Commands:
+
+  config check   Validate the configuration and show the effective
+                 values with secrets redacted.
+
 Options:
 
   --version, -v  Show the version string.
   --help, -h     Show the help message.
+  --definitions-dry-run
+                 Show changes to reconcile queues and routings with
+                 --definitions without applying them.
 `
 )
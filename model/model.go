@@ -1,5 +1,11 @@
 package model
 
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
 // Queue describes a queue.
 type Queue struct {
 	Name                   string  `json:"name"`
@@ -7,10 +13,111 @@ type Queue struct {
 	MaxWorkers             uint    `json:"max_workers"`
 	MaxDispatchesPerSecond float64 `json:"max_dispatches_per_second,omitempty"`
 	MaxBurstSize           uint    `json:"max_burst_size,omitempty"`
+	AdaptiveWorkers        bool    `json:"adaptive_workers,omitempty"`
+	MinWorkers             uint    `json:"min_workers,omitempty"`
+	TargetLatency          uint    `json:"target_latency,omitempty"`
+	InsecureSkipVerify     bool    `json:"insecure_skip_verify,omitempty"`
+	HistoryRetention       uint    `json:"history_retention,omitempty"`
+	MaxLength              uint    `json:"max_length,omitempty"`
+	MaxAge                 uint    `json:"max_age,omitempty"`
+	OverflowPolicy         string  `json:"overflow_policy,omitempty"`
+	SpillQueue             string  `json:"spill_queue,omitempty"`
+
+	// Workers guaranteed to the queue in the worker budget of a node,
+	// and the weight of the queue to borrow idle workers beyond them,
+	// which defaults to 1.
+	ReservedWorkers uint `json:"reserved_workers,omitempty"`
+	WorkerWeight    uint `json:"worker_weight,omitempty"`
+
+	// Limits of dispatches by job categories or destination hosts of
+	// jobs, in addition to MaxDispatchesPerSecond of the whole queue.
+	RateLimitBy string               `json:"rate_limit_by,omitempty"`
+	RateLimits  map[string]RateLimit `json:"rate_limits,omitempty"`
+
+	// Groups of jobs among which the queue is shared fairly, and the
+	// weights of groups, which default to 1.
+	FairShare        string          `json:"fair_share,omitempty"`
+	FairShareWeights map[string]uint `json:"fair_share_weights,omitempty"`
+
+	// Weekly windows in DispatchTimezone during which jobs are
+	// dispatched, and blackout windows during which they are not even
+	// in the dispatch windows.  No dispatch windows means all the time.
+	DispatchTimezone string           `json:"dispatch_timezone,omitempty"`
+	DispatchWindows  []DispatchWindow `json:"dispatch_windows,omitempty"`
+	BlackoutWindows  []DispatchWindow `json:"blackout_windows,omitempty"`
+}
+
+// DispatchWindow describes a weekly range of time.
+type DispatchWindow struct {
+	Weekdays []string `json:"weekdays,omitempty"` // sun, mon, ..., sat; every day if empty
+	Start    string   `json:"start"`              // HH:MM
+	End      string   `json:"end"`                // HH:MM, before Start to span midnight
+}
+
+// RateLimit describes a limit of dispatches by a token bucket.
+type RateLimit struct {
+	MaxDispatchesPerSecond float64 `json:"max_dispatches_per_second"`
+	MaxBurstSize           uint    `json:"max_burst_size"`
 }
 
 // Routing describes a routing.
 type Routing struct {
 	QueueName   string `json:"queue_name"`
 	JobCategory string `json:"job_category"`
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// Precedence among patterns matching a job category; the higher
+	// wins.  An exact job category always wins over patterns.
+	Priority int `json:"priority,omitempty"`
+
+	// Queues among which jobs are split by weights instead of being
+	// delivered only to QueueName.
+	Targets []RoutingTarget `json:"targets,omitempty"`
+
+	// Defaults of jobs which omit them.  CategoryPlaceholder in URL is
+	// replaced with the category of a job.
+	URL        string `json:"url,omitempty"`
+	Timeout    uint   `json:"timeout,omitempty"` // seconds
+	MaxRetries uint   `json:"max_retries,omitempty"`
+	RetryDelay uint   `json:"retry_delay,omitempty"` // seconds
+
+	// Limits of jobs.  0 means no limit.
+	TimeoutLimit    uint `json:"timeout_limit,omitempty"` // seconds
+	MaxRetriesLimit uint `json:"max_retries_limit,omitempty"`
+
+	// Destinations of jobs in addition to the global allowlist.
+	Allowlist *Allowlist `json:"allowlist,omitempty"`
+
+	// JSON Schema to which payloads of jobs should conform.
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// Allowlist describes destinations to which jobs can be dispatched.
+// An empty list of each kind allows anything.
+type Allowlist struct {
+	Schemes  []string `json:"schemes,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`    // such as example.com or *.example.com
+	Networks []string `json:"networks,omitempty"` // CIDRs
+}
+
+// IsEmpty tells if the allowlist allows anything.
+func (a *Allowlist) IsEmpty() bool {
+	return a == nil || (len(a.Schemes) == 0 && len(a.Hosts) == 0 && len(a.Networks) == 0)
+}
+
+// CategoryPlaceholder in the default URL of a routing is replaced with
+// the category of a job.
+const CategoryPlaceholder = "{category}"
+
+// JobURL returns the default URL of a job of category, or an empty
+// string if the routing has no default URL.
+func (r *Routing) JobURL(category string) string {
+	return strings.Replace(r.URL, CategoryPlaceholder, url.PathEscape(category), -1)
+}
+
+// RoutingTarget describes a queue to which a share of jobs of a
+// routing is delivered.
+type RoutingTarget struct {
+	QueueName string `json:"queue_name"`
+	Weight    uint   `json:"weight"`
 }
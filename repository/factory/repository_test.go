@@ -36,6 +36,19 @@ func TestQueue(t *testing.T) {
 		MaxWorkers:             10,
 		MaxDispatchesPerSecond: 2.5,
 		MaxBurstSize:           5,
+		MaxLength:              1000,
+		MaxAge:                 60,
+		OverflowPolicy:         "spill",
+		SpillQueue:             "repo_queue_test_queue_2",
+		ReservedWorkers:        2,
+		WorkerWeight:           3,
+		RateLimitBy:            "host",
+		RateLimits:             map[string]model.RateLimit{"example.com": {MaxDispatchesPerSecond: 0.5, MaxBurstSize: 2}},
+		FairShare:              "category",
+		FairShareWeights:       map[string]uint{"heavy": 1, "light": 3},
+		DispatchTimezone:       "Asia/Tokyo",
+		DispatchWindows:        []model.DispatchWindow{{Weekdays: []string{"mon", "fri"}, Start: "09:00", End: "17:00"}},
+		BlackoutWindows:        []model.DispatchWindow{{Start: "12:00", End: "13:00"}},
 	}); !u || err != nil {
 		t.Errorf("updated = %v (should be true), error: %s", u, err)
 	}
@@ -100,6 +113,23 @@ func TestQueue(t *testing.T) {
 			q.MaxDispatchesPerSecond != 2.5 || q.MaxBurstSize != 5 {
 			t.Errorf("Defined queues can be retrieved by name: %#v", q)
 		}
+		if q.MaxLength != 1000 || q.MaxAge != 60 ||
+			q.OverflowPolicy != "spill" || q.SpillQueue != "repo_queue_test_queue_2" {
+			t.Errorf("Limits of a queue can be retrieved by name: %#v", q)
+		}
+		if q.ReservedWorkers != 2 || q.WorkerWeight != 3 {
+			t.Errorf("Worker budget of a queue can be retrieved by name: %#v", q)
+		}
+		if q.RateLimitBy != "host" || q.RateLimits["example.com"] != (model.RateLimit{MaxDispatchesPerSecond: 0.5, MaxBurstSize: 2}) {
+			t.Errorf("Rate limits of a queue can be retrieved by name: %#v", q)
+		}
+		if q.FairShare != "category" || len(q.FairShareWeights) != 2 || q.FairShareWeights["light"] != 3 {
+			t.Errorf("Fair share of a queue can be retrieved by name: %#v", q)
+		}
+		if q.DispatchTimezone != "Asia/Tokyo" || len(q.DispatchWindows) != 1 || len(q.DispatchWindows[0].Weekdays) != 2 ||
+			len(q.BlackoutWindows) != 1 || q.BlackoutWindows[0].End != "13:00" {
+			t.Errorf("Dispatch windows of a queue can be retrieved by name: %#v", q)
+		}
 	}
 
 	revision, err := repo.Queue.Revision()
@@ -208,13 +238,13 @@ func TestRouting(t *testing.T) {
 		t.Error(err)
 	}
 
-	if u, err := repo.Routing.Add("repo_routing_test_A", "repo_routing_test_queue_1"); !u || err != nil {
+	if u, err := repo.Routing.Add(&model.Routing{JobCategory: "repo_routing_test_A", QueueName: "repo_routing_test_queue_1"}); !u || err != nil {
 		t.Errorf("updated = %v (should be true), error: %s", u, err)
 	}
-	if u, err := repo.Routing.Add("repo_routing_test_B", "repo_routing_test_queue_1"); !u || err != nil {
+	if u, err := repo.Routing.Add(&model.Routing{JobCategory: "repo_routing_test_B", QueueName: "repo_routing_test_queue_1"}); !u || err != nil {
 		t.Errorf("updated = %v (should be true), error: %s", u, err)
 	}
-	if u, err := repo.Routing.Add("repo_routing_test_C", "repo_routing_test_queue_2"); !u || err != nil {
+	if u, err := repo.Routing.Add(&model.Routing{JobCategory: "repo_routing_test_C", QueueName: "repo_routing_test_queue_2"}); !u || err != nil {
 		t.Errorf("updated = %v (should be true), error: %s", u, err)
 	}
 
@@ -235,12 +265,30 @@ func TestRouting(t *testing.T) {
 		}
 	}
 
+	if u, err := repo.Routing.Add(&model.Routing{JobCategory: "repo_routing_test_D", QueueName: "repo_routing_test_queue_2", CallbackURL: "http://localhost/callback"}); !u || err != nil {
+		t.Errorf("updated = %v (should be true), error: %s", u, err)
+	}
+
+	{
+		r := repo.Routing.FindByJobCategory("repo_routing_test_D")
+		if r == nil || r.QueueName != "repo_routing_test_queue_2" || r.CallbackURL != "http://localhost/callback" {
+			t.Errorf("Wrong routing: %+v", r)
+		}
+		if repo.Routing.FindByJobCategory("repo_routing_test_unknown") != nil {
+			t.Error("Unknown category should have no routing")
+		}
+	}
+
+	if err := repo.Routing.DeleteByJobCategory("repo_routing_test_D"); err != nil {
+		t.Error(err)
+	}
+
 	revision, err := repo.Routing.Revision()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if u, err := repo.Routing.Add("repo_routing_test_A", "repo_routing_test_queue_1"); u || err != nil {
+	if u, err := repo.Routing.Add(&model.Routing{JobCategory: "repo_routing_test_A", QueueName: "repo_routing_test_queue_1"}); u || err != nil {
 		t.Errorf("updated = %v (should be false), error: %s", u, err)
 	}
 
@@ -252,7 +300,7 @@ func TestRouting(t *testing.T) {
 		t.Errorf("Revision %d != %d", revision1, revision)
 	}
 
-	if u, err := repo.Routing.Add("repo_routing_test_A", "repo_routing_test_queue_2"); !u || err != nil {
+	if u, err := repo.Routing.Add(&model.Routing{JobCategory: "repo_routing_test_A", QueueName: "repo_routing_test_queue_2"}); !u || err != nil {
 		t.Errorf("updated = %v (should be true), error: %s", u, err)
 	}
 
@@ -279,3 +327,67 @@ func TestRouting(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRoutingPattern(t *testing.T) {
+	repo := NewRepositories()
+
+	if _, err := repo.Queue.Add(&model.Queue{Name: "repo_pattern_test_queue_1"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := repo.Queue.Add(&model.Queue{Name: "repo_pattern_test_queue_2"}); err != nil {
+		t.Error(err)
+	}
+
+	routings := []model.Routing{
+		{JobCategory: "repo_pattern_test.*", QueueName: "repo_pattern_test_queue_1"},
+		{JobCategory: "repo_pattern_test.mail.*", QueueName: "repo_pattern_test_queue_2"},
+		{JobCategory: "repo_pattern_test.urgent*", QueueName: "repo_pattern_test_queue_1", Priority: 1},
+		{JobCategory: "repo_pattern_test.urgent.mail*", QueueName: "repo_pattern_test_queue_2"},
+		{JobCategory: "repo_pattern_test.mail.reset", QueueName: "repo_pattern_test_queue_1"},
+	}
+	for k := range routings {
+		if _, err := repo.Routing.Add(&routings[k]); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, c := range []struct {
+		category string
+		pattern  string
+	}{
+		{"repo_pattern_test.job", "repo_pattern_test.*"},
+		{"repo_pattern_test.mail.welcome", "repo_pattern_test.mail.*"},
+		{"repo_pattern_test.mail.reset", "repo_pattern_test.mail.reset"},
+		{"repo_pattern_test.urgent.mail", "repo_pattern_test.urgent*"},
+		{"repo_pattern_test.mail.*", "repo_pattern_test.mail.*"},
+		{"repo_pattern_test", ""},
+	} {
+		r := repo.Routing.FindByJobCategory(c.category)
+		switch {
+		case c.pattern == "" && r != nil:
+			t.Errorf("%s should match no routing: %+v", c.category, r)
+		case c.pattern != "" && (r == nil || r.JobCategory != c.pattern):
+			t.Errorf("%s should match %s: %+v", c.category, c.pattern, r)
+		}
+	}
+
+	if err := repo.Routing.DeleteByJobCategory("repo_pattern_test.mail.*"); err != nil {
+		t.Error(err)
+	}
+	if q := repo.Routing.FindQueueNameByJobCategory("repo_pattern_test.mail.welcome"); q != "repo_pattern_test_queue_1" {
+		t.Errorf("Wrong queue after deleting a pattern: %s", q)
+	}
+
+	if err := repo.Routing.Reload(); err != nil {
+		t.Error(err)
+	}
+	if r := repo.Routing.FindByJobCategory("repo_pattern_test.urgent.job"); r == nil || r.Priority != 1 {
+		t.Errorf("Priority should be kept: %+v", r)
+	}
+
+	for _, r := range routings {
+		if err := repo.Routing.DeleteByJobCategory(r.JobCategory); err != nil {
+			t.Error(err)
+		}
+	}
+}
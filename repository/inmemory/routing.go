@@ -1,6 +1,7 @@
 package inmemory
 
 import (
+	"reflect"
 	"sync"
 	"sync/atomic"
 
@@ -10,11 +11,11 @@ import (
 
 type routingStorage struct {
 	sync.RWMutex
-	m        map[string]string
+	t        *repository.RoutingTable
 	revision uint64
 }
 
-var rs = &routingStorage{m: make(map[string]string)}
+var rs = &routingStorage{t: repository.NewRoutingTable(nil)}
 
 type routingRepository struct{}
 
@@ -24,12 +25,12 @@ func NewRoutingRepository() repository.RoutingRepository {
 	return &routingRepository{}
 }
 
-func (r *routingRepository) Add(jobCategory string, queueName string) (bool, error) {
+func (r *routingRepository) Add(routing *model.Routing) (bool, error) {
 	rs.Lock()
 	defer rs.Unlock()
 
-	if rs.m[jobCategory] != queueName {
-		rs.m[jobCategory] = queueName
+	if current, ok := rs.t.Get(routing.JobCategory); !ok || !reflect.DeepEqual(current, *routing) {
+		rs.t.Put(routing)
 		r.updateRevision()
 		return true, nil
 	}
@@ -40,29 +41,31 @@ func (r *routingRepository) FindAll() ([]model.Routing, error) {
 	rs.RLock()
 	defer rs.RUnlock()
 
-	routings := make([]model.Routing, 0, len(rs.m))
-	for category, queue := range rs.m {
-		routings = append(routings, model.Routing{
-			QueueName:   queue,
-			JobCategory: category,
-		})
-	}
+	return rs.t.All(), nil
+}
+
+func (r *routingRepository) FindByJobCategory(category string) *model.Routing {
+	rs.RLock()
+	defer rs.RUnlock()
 
-	return routings, nil
+	return rs.t.Match(category)
 }
 
 func (r *routingRepository) FindQueueNameByJobCategory(category string) string {
 	rs.RLock()
 	defer rs.RUnlock()
 
-	return rs.m[category]
+	if routing := rs.t.Match(category); routing != nil {
+		return routing.QueueName
+	}
+	return ""
 }
 
 func (r *routingRepository) DeleteByJobCategory(category string) error {
-	rs.RLock()
-	defer rs.RUnlock()
+	rs.Lock()
+	defer rs.Unlock()
 
-	delete(rs.m, category)
+	rs.t.Delete(category)
 	r.updateRevision()
 	return nil
 }
@@ -16,7 +16,21 @@ func init() {
 	schema = []string{
 		"repository/mysql/schema/queue.sql",
 		"repository/mysql/schema/queue_throttle.sql",
+		"repository/mysql/schema/queue_concurrency.sql",
+		"repository/mysql/schema/queue_pool.sql",
+		"repository/mysql/schema/queue_tls.sql",
+		"repository/mysql/schema/queue_history.sql",
+		"repository/mysql/schema/queue_limit.sql",
+		"repository/mysql/schema/queue_rate_limit.sql",
+		"repository/mysql/schema/queue_fair_share.sql",
+		"repository/mysql/schema/queue_dispatch_window.sql",
 		"repository/mysql/schema/routing.sql",
+		"repository/mysql/schema/routing_callback.sql",
+		"repository/mysql/schema/routing_priority.sql",
+		"repository/mysql/schema/routing_target.sql",
+		"repository/mysql/schema/routing_job.sql",
+		"repository/mysql/schema/routing_allowlist.sql",
+		"repository/mysql/schema/routing_schema.sql",
 		"repository/mysql/schema/config_revision.sql",
 	}
 }
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"strings"
 
 	"github.com/coosir/middleman/model"
@@ -21,6 +22,12 @@ func NewQueueRepository(db *sql.DB) repository.QueueRepository {
 func (r *queueRepository) Add(q *model.Queue) (bool, error) {
 	updated := false
 
+	tx, err := r.db.Begin()
+	if err != nil {
+		return updated, err
+	}
+	defer tx.Rollback()
+
 	sql := `
 		INSERT INTO queue (name, polling_interval, max_workers)
 		VALUES ( ?, ?, ? )
@@ -28,7 +35,7 @@ func (r *queueRepository) Add(q *model.Queue) (bool, error) {
 			polling_interval = VALUES(polling_interval),
 			max_workers = VALUES(max_workers)
 	`
-	res, err := r.db.Exec(sql, q.Name, q.PollingInterval, q.MaxWorkers)
+	res, err := tx.Exec(sql, q.Name, q.PollingInterval, q.MaxWorkers)
 	if err != nil {
 		return updated, err
 	}
@@ -44,7 +51,153 @@ func (r *queueRepository) Add(q *model.Queue) (bool, error) {
 			max_dispatches_per_second = VALUES(max_dispatches_per_second),
 			max_burst_size = VALUES(max_burst_size)
 	`
-	res, err = r.db.Exec(sql, q.Name, q.MaxDispatchesPerSecond, q.MaxBurstSize)
+	res, err = tx.Exec(sql, q.Name, q.MaxDispatchesPerSecond, q.MaxBurstSize)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	sql = `
+		INSERT INTO queue_concurrency (name, adaptive_workers, min_workers, target_latency)
+		VALUES ( ?, ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			adaptive_workers = VALUES(adaptive_workers),
+			min_workers = VALUES(min_workers),
+			target_latency = VALUES(target_latency)
+	`
+	res, err = tx.Exec(sql, q.Name, q.AdaptiveWorkers, q.MinWorkers, q.TargetLatency)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	sql = `
+		INSERT INTO queue_pool (name, reserved_workers, worker_weight)
+		VALUES ( ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			reserved_workers = VALUES(reserved_workers),
+			worker_weight = VALUES(worker_weight)
+	`
+	res, err = tx.Exec(sql, q.Name, q.ReservedWorkers, q.WorkerWeight)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	sql = `
+		INSERT INTO queue_tls (name, insecure_skip_verify)
+		VALUES ( ?, ? )
+		ON DUPLICATE KEY UPDATE
+			insecure_skip_verify = VALUES(insecure_skip_verify)
+	`
+	res, err = tx.Exec(sql, q.Name, q.InsecureSkipVerify)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	sql = `
+		INSERT INTO queue_history (name, retention)
+		VALUES ( ?, ? )
+		ON DUPLICATE KEY UPDATE
+			retention = VALUES(retention)
+	`
+	res, err = tx.Exec(sql, q.Name, q.HistoryRetention)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	sql = `
+		INSERT INTO queue_limit (name, max_length, max_age, overflow_policy, spill_queue)
+		VALUES ( ?, ?, ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			max_length = VALUES(max_length),
+			max_age = VALUES(max_age),
+			overflow_policy = VALUES(overflow_policy),
+			spill_queue = VALUES(spill_queue)
+	`
+	res, err = tx.Exec(sql, q.Name, q.MaxLength, q.MaxAge, q.OverflowPolicy, q.SpillQueue)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	rateLimits, err := json.Marshal(q.RateLimits)
+	if err != nil {
+		return updated, err
+	}
+	sql = `
+		INSERT INTO queue_rate_limit (name, rate_limit_by, rate_limits)
+		VALUES ( ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			rate_limit_by = VALUES(rate_limit_by),
+			rate_limits = VALUES(rate_limits)
+	`
+	res, err = tx.Exec(sql, q.Name, q.RateLimitBy, rateLimits)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	weights, err := json.Marshal(q.FairShareWeights)
+	if err != nil {
+		return updated, err
+	}
+	sql = `
+		INSERT INTO queue_fair_share (name, fair_share, weights)
+		VALUES ( ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			fair_share = VALUES(fair_share),
+			weights = VALUES(weights)
+	`
+	res, err = tx.Exec(sql, q.Name, q.FairShare, weights)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	windows, err := json.Marshal(q.DispatchWindows)
+	if err != nil {
+		return updated, err
+	}
+	blackouts, err := json.Marshal(q.BlackoutWindows)
+	if err != nil {
+		return updated, err
+	}
+	sql = `
+		INSERT INTO queue_dispatch_window (name, timezone, windows, blackouts)
+		VALUES ( ?, ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			timezone = VALUES(timezone),
+			windows = VALUES(windows),
+			blackouts = VALUES(blackouts)
+	`
+	res, err = tx.Exec(sql, q.Name, q.DispatchTimezone, windows, blackouts)
 	if err != nil {
 		return updated, err
 	}
@@ -53,10 +206,13 @@ func (r *queueRepository) Add(q *model.Queue) (bool, error) {
 		updated = updated || (i != 0)
 	}
 
-	if updated {
-		return updated, r.updateRevision()
+	if !updated {
+		return updated, nil
+	}
+	if err := updateQueueRevision(tx); err != nil {
+		return updated, err
 	}
-	return updated, nil
+	return updated, tx.Commit()
 }
 
 func (r *queueRepository) FindAll() ([]model.Queue, error) {
@@ -91,11 +247,73 @@ func (r *queueRepository) FindAll() ([]model.Queue, error) {
 	if err != nil {
 		return nil, err
 	}
+	concurrencies, err := r.findQueueConcurrencies(names)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := r.findQueuePools(names)
+	if err != nil {
+		return nil, err
+	}
+	insecures, err := r.findQueueInsecureSkipVerify(names)
+	if err != nil {
+		return nil, err
+	}
+	retentions, err := r.findQueueHistoryRetentions(names)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := r.findQueueLimits(names)
+	if err != nil {
+		return nil, err
+	}
+	rateLimits, err := r.findQueueRateLimits(names)
+	if err != nil {
+		return nil, err
+	}
+	fairShares, err := r.findQueueFairShares(names)
+	if err != nil {
+		return nil, err
+	}
+	dispatchWindows, err := r.findQueueDispatchWindows(names)
+	if err != nil {
+		return nil, err
+	}
 	for i, q := range results {
 		if throttle, ok := throttles[q.Name]; ok {
 			results[i].MaxDispatchesPerSecond = throttle.maxDispatchesPerSecond
 			results[i].MaxBurstSize = throttle.maxBurstSize
 		}
+		if concurrency, ok := concurrencies[q.Name]; ok {
+			results[i].AdaptiveWorkers = concurrency.adaptiveWorkers
+			results[i].MinWorkers = concurrency.minWorkers
+			results[i].TargetLatency = concurrency.targetLatency
+		}
+		if pool, ok := pools[q.Name]; ok {
+			results[i].ReservedWorkers = pool.reservedWorkers
+			results[i].WorkerWeight = pool.workerWeight
+		}
+		results[i].InsecureSkipVerify = insecures[q.Name]
+		results[i].HistoryRetention = retentions[q.Name]
+		if limit, ok := limits[q.Name]; ok {
+			results[i].MaxLength = limit.maxLength
+			results[i].MaxAge = limit.maxAge
+			results[i].OverflowPolicy = limit.overflowPolicy
+			results[i].SpillQueue = limit.spillQueue
+		}
+		if rateLimit, ok := rateLimits[q.Name]; ok {
+			results[i].RateLimitBy = rateLimit.rateLimitBy
+			results[i].RateLimits = rateLimit.rateLimits
+		}
+		if fairShare, ok := fairShares[q.Name]; ok {
+			results[i].FairShare = fairShare.fairShare
+			results[i].FairShareWeights = fairShare.weights
+		}
+		if dispatchWindow, ok := dispatchWindows[q.Name]; ok {
+			results[i].DispatchTimezone = dispatchWindow.timezone
+			results[i].DispatchWindows = dispatchWindow.windows
+			results[i].BlackoutWindows = dispatchWindow.blackouts
+		}
 	}
 
 	return results, nil
@@ -126,6 +344,76 @@ func (r *queueRepository) FindByName(name string) (*model.Queue, error) {
 		queue.MaxBurstSize = throttle.maxBurstSize
 	}
 
+	concurrencies, err := r.findQueueConcurrencies([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	if concurrency, ok := concurrencies[queue.Name]; ok {
+		queue.AdaptiveWorkers = concurrency.adaptiveWorkers
+		queue.MinWorkers = concurrency.minWorkers
+		queue.TargetLatency = concurrency.targetLatency
+	}
+
+	pools, err := r.findQueuePools([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	if pool, ok := pools[queue.Name]; ok {
+		queue.ReservedWorkers = pool.reservedWorkers
+		queue.WorkerWeight = pool.workerWeight
+	}
+
+	insecures, err := r.findQueueInsecureSkipVerify([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	queue.InsecureSkipVerify = insecures[queue.Name]
+
+	retentions, err := r.findQueueHistoryRetentions([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	queue.HistoryRetention = retentions[queue.Name]
+
+	limits, err := r.findQueueLimits([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	if limit, ok := limits[queue.Name]; ok {
+		queue.MaxLength = limit.maxLength
+		queue.MaxAge = limit.maxAge
+		queue.OverflowPolicy = limit.overflowPolicy
+		queue.SpillQueue = limit.spillQueue
+	}
+
+	rateLimits, err := r.findQueueRateLimits([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	if rateLimit, ok := rateLimits[queue.Name]; ok {
+		queue.RateLimitBy = rateLimit.rateLimitBy
+		queue.RateLimits = rateLimit.rateLimits
+	}
+
+	fairShares, err := r.findQueueFairShares([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	if fairShare, ok := fairShares[queue.Name]; ok {
+		queue.FairShare = fairShare.fairShare
+		queue.FairShareWeights = fairShare.weights
+	}
+
+	dispatchWindows, err := r.findQueueDispatchWindows([]string{queue.Name})
+	if err != nil {
+		return nil, err
+	}
+	if dispatchWindow, ok := dispatchWindows[queue.Name]; ok {
+		queue.DispatchTimezone = dispatchWindow.timezone
+		queue.DispatchWindows = dispatchWindow.windows
+		queue.BlackoutWindows = dispatchWindow.blackouts
+	}
+
 	return queue, nil
 }
 
@@ -176,26 +464,486 @@ func (r *queueRepository) findQueueThrottles(names []string) (map[string]queueTh
 	return throttleByName, nil
 }
 
-func (r *queueRepository) DeleteByName(name string) error {
+type queueConcurrency struct {
+	adaptiveWorkers bool
+	minWorkers      uint
+	targetLatency   uint
+}
+
+func (r *queueRepository) findQueueConcurrencies(names []string) (map[string]queueConcurrency, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
 	sql := `
-		DELETE FROM queue
-		WHERE name = ?
+		SELECT name, adaptive_workers, min_workers, target_latency
+		FROM queue_concurrency
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
 	`
-	_, err := r.db.Exec(sql, name)
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	sql = `
-		DELETE FROM queue_throttle
-		WHERE name = ?
+	var (
+		name              string
+		adaptiveWorkers   bool
+		minWorkers        uint
+		targetLatency     uint
+		concurrencyByName = make(map[string]queueConcurrency, len(names))
+	)
+	for rows.Next() {
+		if err := rows.Scan(&name, &adaptiveWorkers, &minWorkers, &targetLatency); err != nil {
+			return nil, err
+		}
+		concurrencyByName[name] = queueConcurrency{adaptiveWorkers, minWorkers, targetLatency}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return concurrencyByName, nil
+}
+
+type queuePool struct {
+	reservedWorkers uint
+	workerWeight    uint
+}
+
+func (r *queueRepository) findQueuePools(names []string) (map[string]queuePool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql := `
+		SELECT name, reserved_workers, worker_weight
+		FROM queue_pool
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
 	`
-	_, err = r.db.Exec(sql, name)
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name       string
+		pool       queuePool
+		poolByName = make(map[string]queuePool, len(names))
+	)
+	for rows.Next() {
+		if err := rows.Scan(&name, &pool.reservedWorkers, &pool.workerWeight); err != nil {
+			return nil, err
+		}
+		poolByName[name] = pool
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return poolByName, nil
+}
+
+func (r *queueRepository) findQueueInsecureSkipVerify(names []string) (map[string]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
 	}
 
-	return r.updateRevision()
+	sql := `
+		SELECT name, insecure_skip_verify
+		FROM queue_tls
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name               string
+		insecureSkipVerify bool
+		insecureByName     = make(map[string]bool, len(names))
+	)
+	for rows.Next() {
+		if err := rows.Scan(&name, &insecureSkipVerify); err != nil {
+			return nil, err
+		}
+		insecureByName[name] = insecureSkipVerify
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return insecureByName, nil
+}
+
+func (r *queueRepository) findQueueHistoryRetentions(names []string) (map[string]uint, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql := `
+		SELECT name, retention
+		FROM queue_history
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name            string
+		retention       uint
+		retentionByName = make(map[string]uint, len(names))
+	)
+	for rows.Next() {
+		if err := rows.Scan(&name, &retention); err != nil {
+			return nil, err
+		}
+		retentionByName[name] = retention
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return retentionByName, nil
+}
+
+type queueLimit struct {
+	maxLength      uint
+	maxAge         uint
+	overflowPolicy string
+	spillQueue     string
+}
+
+func (r *queueRepository) findQueueLimits(names []string) (map[string]queueLimit, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql := `
+		SELECT name, max_length, max_age, overflow_policy, spill_queue
+		FROM queue_limit
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name        string
+		limit       queueLimit
+		limitByName = make(map[string]queueLimit, len(names))
+	)
+	for rows.Next() {
+		if err := rows.Scan(&name, &limit.maxLength, &limit.maxAge, &limit.overflowPolicy, &limit.spillQueue); err != nil {
+			return nil, err
+		}
+		limitByName[name] = limit
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return limitByName, nil
+}
+
+type queueRateLimit struct {
+	rateLimitBy string
+	rateLimits  map[string]model.RateLimit
+}
+
+func (r *queueRepository) findQueueRateLimits(names []string) (map[string]queueRateLimit, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql := `
+		SELECT name, rate_limit_by, rate_limits
+		FROM queue_rate_limit
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name            string
+		limits          []byte
+		rateLimitByName = make(map[string]queueRateLimit, len(names))
+	)
+	for rows.Next() {
+		var rateLimit queueRateLimit
+		if err := rows.Scan(&name, &rateLimit.rateLimitBy, &limits); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(limits, &rateLimit.rateLimits); err != nil {
+			return nil, err
+		}
+		rateLimitByName[name] = rateLimit
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rateLimitByName, nil
+}
+
+type queueFairShare struct {
+	fairShare string
+	weights   map[string]uint
+}
+
+func (r *queueRepository) findQueueFairShares(names []string) (map[string]queueFairShare, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql := `
+		SELECT name, fair_share, weights
+		FROM queue_fair_share
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name            string
+		weights         []byte
+		fairShareByName = make(map[string]queueFairShare, len(names))
+	)
+	for rows.Next() {
+		var fairShare queueFairShare
+		if err := rows.Scan(&name, &fairShare.fairShare, &weights); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(weights, &fairShare.weights); err != nil {
+			return nil, err
+		}
+		fairShareByName[name] = fairShare
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fairShareByName, nil
+}
+
+type queueDispatchWindow struct {
+	timezone  string
+	windows   []model.DispatchWindow
+	blackouts []model.DispatchWindow
+}
+
+func (r *queueRepository) findQueueDispatchWindows(names []string) (map[string]queueDispatchWindow, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql := `
+		SELECT name, timezone, windows, blackouts
+		FROM queue_dispatch_window
+		WHERE name IN (` + strings.Repeat("?,", len(names)-1) + `?)
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	rows, err := r.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		name                 string
+		windows, blackouts   []byte
+		dispatchWindowByName = make(map[string]queueDispatchWindow, len(names))
+	)
+	for rows.Next() {
+		var dispatchWindow queueDispatchWindow
+		if err := rows.Scan(&name, &dispatchWindow.timezone, &windows, &blackouts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(windows, &dispatchWindow.windows); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(blackouts, &dispatchWindow.blackouts); err != nil {
+			return nil, err
+		}
+		dispatchWindowByName[name] = dispatchWindow
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return dispatchWindowByName, nil
+}
+
+func (r *queueRepository) DeleteByName(name string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql := `
+		DELETE FROM queue
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_throttle
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_concurrency
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_pool
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_tls
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_history
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_limit
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_rate_limit
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_fair_share
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM queue_dispatch_window
+		WHERE name = ?
+	`
+	_, err = tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	if err := updateQueueRevision(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *queueRepository) Revision() (uint64, error) {
@@ -209,8 +957,8 @@ func (r *queueRepository) Revision() (uint64, error) {
 	return revision, nil
 }
 
-func (r *queueRepository) updateRevision() error {
-	_, err := r.db.Exec(`
+func updateQueueRevision(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 		INSERT INTO config_revision (name, revision)
 		VALUES ('queue_definition', 1)
 		ON DUPLICATE KEY UPDATE
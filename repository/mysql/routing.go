@@ -2,6 +2,8 @@ package mysql
 
 import (
 	"database/sql"
+	"encoding/json"
+	"reflect"
 	"sync"
 
 	"github.com/coosir/middleman/model"
@@ -11,7 +13,7 @@ import (
 type routingRepository struct {
 	sync.RWMutex
 	db       *sql.DB
-	routings map[string]string
+	routings *repository.RoutingTable
 }
 
 // NewRoutingRepository creates a repository.RoutingRepository which uses
@@ -22,19 +24,34 @@ func NewRoutingRepository(db *sql.DB) repository.RoutingRepository {
 	return r
 }
 
-func (r *routingRepository) Add(jobCategory string, queueName string) (bool, error) {
+func (r *routingRepository) Add(routing *model.Routing) (bool, error) {
 	updated := false
 
+	tx, err := r.db.Begin()
+	if err != nil {
+		return updated, err
+	}
+	defer tx.Rollback()
+
 	selectSQL := `
 		 SELECT 1 FROM queue
 		 WHERE name = ?
 	`
-	var queueExists int
-	err := r.db.QueryRow(selectSQL, queueName).Scan(
-		&queueExists,
-	)
-	if err != nil {
-		return updated, &repository.QueueNotFoundError{QueueName: queueName}
+	queueNames := []string{routing.QueueName}
+	for _, t := range routing.Targets {
+		queueNames = append(queueNames, t.QueueName)
+	}
+	for _, qn := range queueNames {
+		var queueExists int
+		err := tx.QueryRow(selectSQL, qn).Scan(
+			&queueExists,
+		)
+		if err == sql.ErrNoRows {
+			return updated, &repository.QueueNotFoundError{QueueName: qn}
+		}
+		if err != nil {
+			return updated, err
+		}
 	}
 
 	insertSQL := `
@@ -43,7 +60,7 @@ func (r *routingRepository) Add(jobCategory string, queueName string) (bool, err
         ON DUPLICATE KEY UPDATE
 			queue_name = VALUES(queue_name)
 	`
-	res, err := r.db.Exec(insertSQL, jobCategory, queueName)
+	res, err := tx.Exec(insertSQL, routing.JobCategory, routing.QueueName)
 	if err != nil {
 		return updated, err
 	}
@@ -52,27 +69,207 @@ func (r *routingRepository) Add(jobCategory string, queueName string) (bool, err
 		updated = updated || (i != 0)
 	}
 
-	if updated {
-		r.Lock()
-		defer r.Unlock()
+	insertSQL = `
+		INSERT INTO routing_callback (job_category, callback_url)
+		VALUES ( ?, ? )
+		ON DUPLICATE KEY UPDATE
+			callback_url = VALUES(callback_url)
+	`
+	res, err = tx.Exec(insertSQL, routing.JobCategory, routing.CallbackURL)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	insertSQL = `
+		INSERT INTO routing_priority (job_category, priority)
+		VALUES ( ?, ? )
+		ON DUPLICATE KEY UPDATE
+			priority = VALUES(priority)
+	`
+	res, err = tx.Exec(insertSQL, routing.JobCategory, routing.Priority)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	insertSQL = `
+		INSERT INTO routing_job (job_category, url, timeout, max_retries, retry_delay, timeout_limit, max_retries_limit)
+		VALUES ( ?, ?, ?, ?, ?, ?, ? )
+		ON DUPLICATE KEY UPDATE
+			url = VALUES(url),
+			timeout = VALUES(timeout),
+			max_retries = VALUES(max_retries),
+			retry_delay = VALUES(retry_delay),
+			timeout_limit = VALUES(timeout_limit),
+			max_retries_limit = VALUES(max_retries_limit)
+	`
+	res, err = tx.Exec(
+		insertSQL,
+		routing.JobCategory,
+		routing.URL,
+		routing.Timeout,
+		routing.MaxRetries,
+		routing.RetryDelay,
+		routing.TimeoutLimit,
+		routing.MaxRetriesLimit,
+	)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
 
-		r.routings[jobCategory] = queueName
-		return updated, r.updateRevision()
+	allowlist, err := json.Marshal(routing.Allowlist)
+	if err != nil {
+		return updated, err
+	}
+	insertSQL = `
+		INSERT INTO routing_allowlist (job_category, allowlist)
+		VALUES ( ?, ? )
+		ON DUPLICATE KEY UPDATE
+			allowlist = VALUES(allowlist)
+	`
+	res, err = tx.Exec(insertSQL, routing.JobCategory, allowlist)
+	if err != nil {
+		return updated, err
 	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	schema := []byte(routing.Schema)
+	if schema == nil {
+		schema = []byte{}
+	}
+	insertSQL = `
+		INSERT INTO routing_schema (job_category, json_schema)
+		VALUES ( ?, ? )
+		ON DUPLICATE KEY UPDATE
+			json_schema = VALUES(json_schema)
+	`
+	res, err = tx.Exec(insertSQL, routing.JobCategory, schema)
+	if err != nil {
+		return updated, err
+	}
+	i, err = res.RowsAffected()
+	if err == nil {
+		updated = updated || (i != 0)
+	}
+
+	targets, err := findTargets(tx, routing.JobCategory)
+	if err != nil {
+		return updated, err
+	}
+	if !reflect.DeepEqual(targets, routing.Targets) {
+		if err := replaceTargets(tx, routing.JobCategory, routing.Targets); err != nil {
+			return updated, err
+		}
+		updated = true
+	}
+
+	if !updated {
+		return updated, nil
+	}
+	if err := updateRoutingRevision(tx); err != nil {
+		return updated, err
+	}
+	if err := tx.Commit(); err != nil {
+		return updated, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.routings.Put(routing)
 	return updated, nil
 }
 
+func findTargets(tx *sql.Tx, category string) ([]model.RoutingTarget, error) {
+	sql := `
+		SELECT queue_name, weight
+		FROM routing_target
+		WHERE job_category = ?
+		ORDER BY queue_name ASC
+	`
+
+	rows, err := tx.Query(sql, category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []model.RoutingTarget
+	for rows.Next() {
+		var t model.RoutingTarget
+		if err := rows.Scan(&(t.QueueName), &(t.Weight)); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+func replaceTargets(tx *sql.Tx, category string, targets []model.RoutingTarget) error {
+	sql := `
+		DELETE FROM routing_target
+		WHERE job_category = ?
+	`
+	if _, err := tx.Exec(sql, category); err != nil {
+		return err
+	}
+
+	sql = `
+		INSERT INTO routing_target (job_category, queue_name, weight)
+		VALUES ( ?, ?, ? )
+	`
+	for _, t := range targets {
+		if _, err := tx.Exec(sql, category, t.QueueName, t.Weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *routingRepository) FindByJobCategory(category string) *model.Routing {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.routings.Match(category)
+}
+
 func (r *routingRepository) FindQueueNameByJobCategory(category string) string {
 	r.RLock()
 	defer r.RUnlock()
 
-	return r.routings[category]
+	if routing := r.routings.Match(category); routing != nil {
+		return routing.QueueName
+	}
+	return ""
 }
 
 func (r *routingRepository) FindAll() ([]model.Routing, error) {
 	sql := `
-		SELECT queue_name, job_category FROM routing
-		ORDER BY queue_name ASC
+		SELECT routing.queue_name, routing.job_category, COALESCE(routing_callback.callback_url, ''), COALESCE(routing_priority.priority, 0),
+			COALESCE(routing_job.url, ''), COALESCE(routing_job.timeout, 0), COALESCE(routing_job.max_retries, 0), COALESCE(routing_job.retry_delay, 0),
+			COALESCE(routing_job.timeout_limit, 0), COALESCE(routing_job.max_retries_limit, 0),
+			COALESCE(routing_allowlist.allowlist, 'null'), COALESCE(routing_schema.json_schema, '')
+		FROM routing
+		LEFT JOIN routing_callback ON routing.job_category = routing_callback.job_category
+		LEFT JOIN routing_priority ON routing.job_category = routing_priority.job_category
+		LEFT JOIN routing_job ON routing.job_category = routing_job.job_category
+		LEFT JOIN routing_allowlist ON routing.job_category = routing_allowlist.job_category
+		LEFT JOIN routing_schema ON routing.job_category = routing_schema.job_category
+		ORDER BY routing.queue_name ASC
 	`
 
 	rows, err := r.db.Query(sql)
@@ -84,41 +281,161 @@ func (r *routingRepository) FindAll() ([]model.Routing, error) {
 	results := make([]model.Routing, 0)
 	for rows.Next() {
 		var row model.Routing
-		if err := rows.Scan(&(row.QueueName), &(row.JobCategory)); err != nil {
+		var allowlist, schema []byte
+		if err := rows.Scan(
+			&(row.QueueName),
+			&(row.JobCategory),
+			&(row.CallbackURL),
+			&(row.Priority),
+			&(row.URL),
+			&(row.Timeout),
+			&(row.MaxRetries),
+			&(row.RetryDelay),
+			&(row.TimeoutLimit),
+			&(row.MaxRetriesLimit),
+			&allowlist,
+			&schema,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(allowlist, &(row.Allowlist)); err != nil {
 			return nil, err
 		}
+		if len(schema) > 0 {
+			row.Schema = json.RawMessage(schema)
+		}
 		results = append(results, row)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
+	if err := r.fillTargets(results); err != nil {
+		return nil, err
+	}
+
 	r.Lock()
 	defer r.Unlock()
 
-	r.routings = make(map[string]string, len(results))
-	for _, routing := range results {
-		r.routings[routing.JobCategory] = routing.QueueName
-	}
+	r.routings = repository.NewRoutingTable(results)
 
 	return results, nil
 }
 
+func (r *routingRepository) fillTargets(routings []model.Routing) error {
+	sql := `
+		SELECT job_category, queue_name, weight
+		FROM routing_target
+		ORDER BY job_category ASC, queue_name ASC
+	`
+
+	rows, err := r.db.Query(sql)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	targets := make(map[string][]model.RoutingTarget)
+	for rows.Next() {
+		var category string
+		var t model.RoutingTarget
+		if err := rows.Scan(&category, &(t.QueueName), &(t.Weight)); err != nil {
+			return err
+		}
+		targets[category] = append(targets[category], t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for k := range routings {
+		routings[k].Targets = targets[routings[k].JobCategory]
+	}
+	return nil
+}
+
 func (r *routingRepository) DeleteByJobCategory(category string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	sql := `
 		DELETE FROM routing
 		WHERE job_category = ?
 	`
-	_, err := r.db.Exec(sql, category)
+	_, err = tx.Exec(sql, category)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM routing_callback
+		WHERE job_category = ?
+	`
+	_, err = tx.Exec(sql, category)
 	if err != nil {
 		return err
 	}
 
+	sql = `
+		DELETE FROM routing_priority
+		WHERE job_category = ?
+	`
+	_, err = tx.Exec(sql, category)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM routing_target
+		WHERE job_category = ?
+	`
+	_, err = tx.Exec(sql, category)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM routing_job
+		WHERE job_category = ?
+	`
+	_, err = tx.Exec(sql, category)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM routing_allowlist
+		WHERE job_category = ?
+	`
+	_, err = tx.Exec(sql, category)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM routing_schema
+		WHERE job_category = ?
+	`
+	_, err = tx.Exec(sql, category)
+	if err != nil {
+		return err
+	}
+
+	if err := updateRoutingRevision(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
 	r.Lock()
 	defer r.Unlock()
 
-	delete(r.routings, category)
-	return r.updateRevision()
+	r.routings.Delete(category)
+	return nil
 }
 
 func (r *routingRepository) Revision() (uint64, error) {
@@ -137,8 +454,8 @@ func (r *routingRepository) Reload() error {
 	return err
 }
 
-func (r *routingRepository) updateRevision() error {
-	_, err := r.db.Exec(`
+func updateRoutingRevision(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 		INSERT INTO config_revision (name, revision)
 		VALUES ('routing', 1)
 		ON DUPLICATE KEY UPDATE
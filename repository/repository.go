@@ -13,8 +13,9 @@ type QueueRepository interface {
 
 // RoutingRepository is an interface of a routing repository.
 type RoutingRepository interface {
-	Add(jobCategory string, queueName string) (bool, error)
+	Add(routing *model.Routing) (bool, error)
 	FindAll() ([]model.Routing, error)
+	FindByJobCategory(category string) *model.Routing
 	FindQueueNameByJobCategory(category string) string
 	DeleteByJobCategory(category string) error
 	Revision() (uint64, error)
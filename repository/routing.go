@@ -1,6 +1,15 @@
 package repository
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/coosir/middleman/allowlist"
+	"github.com/coosir/middleman/jsonschema"
+	"github.com/coosir/middleman/model"
+)
 
 // QueueNotFoundError is an error returned when a non-existing queue
 // is specifie as the destination of a routing.
@@ -11,3 +20,81 @@ type QueueNotFoundError struct {
 func (qe *QueueNotFoundError) Error() string {
 	return fmt.Sprintf("No such queue: %s", qe.QueueName)
 }
+
+// NormalizeRouting validates a routing definition and sorts its
+// targets by queue names.  If the routing has targets and no queue
+// name, the queue of the heaviest target becomes its queue name.
+func NormalizeRouting(r *model.Routing) error {
+	if err := ValidateJobCategory(r.JobCategory); err != nil {
+		return err
+	}
+	if err := validateJobDefaults(r); err != nil {
+		return err
+	}
+	if len(r.Targets) == 0 {
+		r.Targets = nil
+		return nil
+	}
+
+	sort.SliceStable(r.Targets, func(i, j int) bool {
+		return r.Targets[i].QueueName < r.Targets[j].QueueName
+	})
+	var total uint
+	heaviest := r.Targets[0]
+	for k, t := range r.Targets {
+		switch {
+		case t.QueueName == "":
+			return errors.New("Target without queue_name")
+		case k > 0 && t.QueueName == r.Targets[k-1].QueueName:
+			return fmt.Errorf("Duplicate target: %s", t.QueueName)
+		}
+		total += t.Weight
+		if t.Weight > heaviest.Weight {
+			heaviest = t
+		}
+	}
+	if total == 0 {
+		return errors.New("Total weight of targets should be positive")
+	}
+
+	if r.QueueName == "" {
+		r.QueueName = heaviest.QueueName
+	}
+	for _, t := range r.Targets {
+		if t.QueueName == r.QueueName {
+			return nil
+		}
+	}
+	return fmt.Errorf("queue_name should be one of the targets: %s", r.QueueName)
+}
+
+func validateJobDefaults(r *model.Routing) error {
+	if r.URL != "" {
+		if !IsHTTPURL(r.JobURL("category")) {
+			return fmt.Errorf("Invalid url: %s", r.URL)
+		}
+	}
+	if r.TimeoutLimit > 0 && r.Timeout > r.TimeoutLimit {
+		return errors.New("timeout should not be greater than timeout_limit")
+	}
+	if r.MaxRetriesLimit > 0 && r.MaxRetries > r.MaxRetriesLimit {
+		return errors.New("max_retries should not be greater than max_retries_limit")
+	}
+	if r.Allowlist.IsEmpty() {
+		r.Allowlist = nil
+	} else if _, err := allowlist.New(r.Allowlist); err != nil {
+		return fmt.Errorf("Invalid allowlist: %s", err)
+	}
+	if len(r.Schema) == 0 {
+		r.Schema = nil
+	} else if _, err := jsonschema.Compile(r.Schema); err != nil {
+		return fmt.Errorf("Invalid schema: %s", err)
+	}
+	return nil
+}
+
+// IsHTTPURL tells if s is an absolute HTTP or HTTPS URL.
+func IsHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
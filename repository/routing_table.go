@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/coosir/middleman/model"
+)
+
+// PatternWildcard at the end of the job category of a routing makes
+// the routing match any job category which begins with the rest.
+const PatternWildcard = "*"
+
+// IsPattern tells if a job category of a routing is a pattern.
+func IsPattern(category string) bool {
+	return strings.HasSuffix(category, PatternWildcard)
+}
+
+// ValidateJobCategory returns an error if a job category of a routing
+// has a wildcard elsewhere than at the end.
+func ValidateJobCategory(category string) error {
+	if strings.Contains(strings.TrimSuffix(category, PatternWildcard), PatternWildcard) {
+		return errors.New("Wildcard is only allowed at the end of job_category")
+	}
+	return nil
+}
+
+// RoutingTable looks up the routing of a job category.
+//
+// A routing whose job category is the same as the given one wins.
+// Otherwise, the pattern with the highest priority among the matching
+// ones wins, and the longest one wins if they have the same priority.
+// The lookup takes time proportional to the length of the job
+// category regardless of the number of routings.
+//
+// RoutingTable is not goroutine safe.
+type RoutingTable struct {
+	routings map[string]model.Routing
+	patterns *patternNode
+}
+
+type patternNode struct {
+	children map[byte]*patternNode
+	routing  *model.Routing
+}
+
+// NewRoutingTable creates a table of routings.
+func NewRoutingTable(routings []model.Routing) *RoutingTable {
+	t := &RoutingTable{
+		routings: make(map[string]model.Routing, len(routings)),
+		patterns: &patternNode{},
+	}
+	for k := range routings {
+		t.Put(&routings[k])
+	}
+	return t
+}
+
+// Get returns the routing whose job category is exactly category.
+func (t *RoutingTable) Get(category string) (model.Routing, bool) {
+	routing, ok := t.routings[category]
+	return routing, ok
+}
+
+// All returns all the routings in no particular order.
+func (t *RoutingTable) All() []model.Routing {
+	routings := make([]model.Routing, 0, len(t.routings))
+	for _, routing := range t.routings {
+		routings = append(routings, routing)
+	}
+	return routings
+}
+
+// Put adds or replaces a routing.
+func (t *RoutingTable) Put(routing *model.Routing) {
+	t.routings[routing.JobCategory] = *routing
+	if IsPattern(routing.JobCategory) {
+		r := *routing
+		t.node(routing.JobCategory, true).routing = &r
+	}
+}
+
+// Delete removes the routing whose job category is exactly category.
+func (t *RoutingTable) Delete(category string) {
+	delete(t.routings, category)
+	if IsPattern(category) {
+		if n := t.node(category, false); n != nil {
+			n.routing = nil
+		}
+	}
+}
+
+// Match returns the routing of a job category or nil if no routing
+// matches.
+func (t *RoutingTable) Match(category string) *model.Routing {
+	if routing, ok := t.routings[category]; ok {
+		return &routing
+	}
+
+	var matched *model.Routing
+	n := t.patterns
+	for i := 0; n != nil; i++ {
+		if r := n.routing; r != nil && (matched == nil || r.Priority >= matched.Priority) {
+			matched = r
+		}
+		if i == len(category) {
+			break
+		}
+		n = n.children[category[i]]
+	}
+	if matched == nil {
+		return nil
+	}
+	routing := *matched
+	return &routing
+}
+
+func (t *RoutingTable) node(pattern string, create bool) *patternNode {
+	prefix := strings.TrimSuffix(pattern, PatternWildcard)
+	n := t.patterns
+	for i := 0; i < len(prefix); i++ {
+		child, ok := n.children[prefix[i]]
+		if !ok {
+			if !create {
+				return nil
+			}
+			if n.children == nil {
+				n.children = make(map[byte]*patternNode)
+			}
+			child = &patternNode{}
+			n.children[prefix[i]] = child
+		}
+		n = child
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/jobqueue"
+
+	"github.com/rs/zerolog/log"
+)
+
+// A timeout, in seconds, of a callback request.
+const callbackTimeout = 30
+
+// callback describes a notification of a finished job sent to its
+// callback URL.
+type callback struct {
+	ID        uint64           `json:"id"`
+	QueueName string           `json:"queue_name"`
+	Category  string           `json:"category"`
+	Result    *jobqueue.Result `json:"result"`
+	Attempts  uint             `json:"attempts"`
+}
+
+// callbackJob : implements the following interfaces
+// - jobqueue.IncomingJob
+// - jobqueue.HasMetadata
+type callbackJob struct {
+	category   string
+	url        string
+	payload    string
+	retryCount uint
+	retryDelay uint
+	metadata   *jobqueue.Metadata
+}
+
+func (j *callbackJob) Category() string  { return j.category }
+func (j *callbackJob) URL() string       { return j.url }
+func (j *callbackJob) Payload() string   { return j.payload }
+func (j *callbackJob) NextDelay() uint64 { return 0 }
+func (j *callbackJob) Timeout() uint     { return callbackTimeout }
+func (j *callbackJob) RetryDelay() uint  { return j.retryDelay }
+func (j *callbackJob) RetryCount() uint  { return j.retryCount }
+
+func (j *callbackJob) Metadata() *jobqueue.Metadata { return j.metadata }
+
+// notify pushes a callback of a finished job to the callback queue.
+// The callback is delivered by the dispatcher of the callback queue
+// and retried there so that it never blocks the original queue.
+//
+// This method is goroutine safe.
+func (s *Service) notify(queueName string, job jobqueue.Job, res *jobqueue.Result) {
+	metadata := jobqueue.MetadataOf(job)
+	if metadata == nil || metadata.CallbackURL == "" {
+		return
+	}
+
+	s.muCallback.RLock()
+	cq := s.callbackQueue
+	s.muCallback.RUnlock()
+
+	loggable := job.ToLoggable()
+	if cq == nil {
+		log.Warn().Msgf("No callback queue to notify the completion of job %d in %s", loggable.ID(), queueName)
+		return
+	}
+
+	payload, err := json.Marshal(&callback{
+		ID:        loggable.ID(),
+		QueueName: queueName,
+		Category:  loggable.Category(),
+		Result:    res,
+		Attempts:  job.FailCount() + 1,
+	})
+	if err != nil {
+		log.Warn().Msg(err.Error())
+		return
+	}
+
+	if _, err := cq.Push(&callbackJob{
+		category:   loggable.Category(),
+		url:        metadata.CallbackURL,
+		payload:    string(payload),
+		retryCount: s.callbackMaxRetries,
+		retryDelay: s.callbackRetryDelay,
+		metadata:   metadata.TraceContext(),
+	}); err != nil {
+		log.Warn().Msgf("Cannot push a callback of job %d in %s: %s", loggable.ID(), queueName, err)
+	}
+}
+
+func callbackMaxRetries() uint {
+	n, err := strconv.ParseUint(config.Get("callback_max_retries"), 10, 32)
+	if err != nil {
+		log.Panic().Msg(err.Error())
+	}
+	return uint(n)
+}
+
+func callbackRetryDelay() uint {
+	n, err := strconv.ParseUint(config.Get("callback_retry_delay"), 10, 32)
+	if err != nil {
+		log.Panic().Msg(err.Error())
+	}
+	return uint(n)
+}
@@ -7,6 +7,7 @@ import (
 type configWatcher struct {
 	revision     func() (uint64, error)
 	reload       func()
+	intervalC    chan uint
 	stopC        chan struct{}
 	stoppedC     chan struct{}
 	lastRevision uint64
@@ -17,6 +18,7 @@ func newConfigWatcher(revision func() (uint64, error), reload func()) *configWat
 	return &configWatcher{
 		revision:     revision,
 		reload:       reload,
+		intervalC:    make(chan uint, 1),
 		stopC:        make(chan struct{}, 1),
 		stoppedC:     make(chan struct{}, 1),
 		lastRevision: rev,
@@ -27,6 +29,16 @@ func (w *configWatcher) start(interval uint) {
 	go w.loop(interval)
 }
 
+// setInterval changes the interval of checking the revision after the
+// watcher started.
+func (w *configWatcher) setInterval(interval uint) {
+	select {
+	case <-w.intervalC: // replace a pending interval
+	default:
+	}
+	w.intervalC <- interval
+}
+
 func (w *configWatcher) stop() <-chan struct{} {
 	w.stopC <- struct{}{}
 	return w.stoppedC
@@ -43,6 +55,8 @@ Loop:
 				w.lastRevision = revision
 				w.reload()
 			}
+		case interval := <-w.intervalC:
+			ticker.Reset(time.Duration(interval) * time.Millisecond)
 		case <-w.stopC:
 			ticker.Stop()
 			break Loop
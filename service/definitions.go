@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/model"
+	"github.com/coosir/middleman/repository"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Definitions describes the desired state of queues and routings.
+type Definitions struct {
+	Queues   []model.Queue   `json:"queues"`
+	Routings []model.Routing `json:"routings"`
+}
+
+// LoadDefinitions reads definitions from a JSON file, or a YAML file
+// (.yaml or .yml) of the same structure.
+func LoadDefinitions(path string) (*Definitions, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if b, err = yaml.YAMLToJSON(b); err != nil {
+			return nil, fmt.Errorf("Cannot parse definitions %s: %s", path, err)
+		}
+	}
+
+	var defs Definitions
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&defs); err != nil {
+		return nil, fmt.Errorf("Cannot parse definitions %s: %s", path, err)
+	}
+	return &defs, nil
+}
+
+// Actions of a change
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change describes a change of either a queue or a routing to
+// reconcile the repositories with definitions.
+type Change struct {
+	Action  string
+	Queue   *model.Queue   // nil if the change is of a routing
+	Routing *model.Routing // nil if the change is of a queue
+
+	// Fields of an update which differ from the existing ones, such
+	// as "max_workers: 5 -> 6".
+	Diffs []string
+}
+
+func (c *Change) String() string {
+	if c.Queue != nil {
+		return fmt.Sprintf("%s queue %s", c.Action, c.Queue.Name)
+	}
+	return fmt.Sprintf("%s routing %s -> %s", c.Action, c.Routing.JobCategory, c.Routing.QueueName)
+}
+
+// PlanDefinitions returns changes to reconcile the repositories with
+// defs without applying them.
+//
+// If prune is true, queues and routings which are not in defs are
+// deleted, except the default queue and the callback queue.
+func PlanDefinitions(repos *repository.Repositories, defs *Definitions, prune bool) ([]Change, error) {
+	existingQueues, err := repos.Queue.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	existingRoutings, err := repos.Routing.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string]*model.Queue)
+	for k := range existingQueues {
+		queues[existingQueues[k].Name] = &existingQueues[k]
+	}
+	routings := make(map[string]*model.Routing)
+	for k := range existingRoutings {
+		routings[existingRoutings[k].JobCategory] = &existingRoutings[k]
+	}
+
+	var changes []Change
+
+	declaredQueues := make(map[string]bool)
+	for k := range defs.Queues {
+		q := defs.Queues[k]
+		if q.Name == "" {
+			return nil, errors.New("Queue definition without name")
+		}
+		if declaredQueues[q.Name] {
+			return nil, fmt.Errorf("Duplicate queue definition: %s", q.Name)
+		}
+		declaredQueues[q.Name] = true
+
+		if err := normalizeQueue(&q); err != nil {
+			return nil, fmt.Errorf("Invalid queue definition %s: %s", q.Name, err)
+		}
+		existing, ok := queues[q.Name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Action: ChangeCreate, Queue: &q})
+		case !reflect.DeepEqual(*existing, q):
+			changes = append(changes, Change{Action: ChangeUpdate, Queue: &q, Diffs: diffFields(*existing, q)})
+		}
+	}
+
+	isKept := func(qn string) bool {
+		if qn == "" {
+			return false
+		}
+		if declaredQueues[qn] || qn == config.Get("queue_default") || qn == config.Get("callback_queue") {
+			return true
+		}
+		_, ok := queues[qn]
+		return ok && !prune
+	}
+
+	declaredRoutings := make(map[string]bool)
+	for k := range defs.Routings {
+		r := defs.Routings[k]
+		if r.JobCategory == "" {
+			return nil, errors.New("Routing definition without job_category")
+		}
+		if declaredRoutings[r.JobCategory] {
+			return nil, fmt.Errorf("Duplicate routing definition: %s", r.JobCategory)
+		}
+		declaredRoutings[r.JobCategory] = true
+
+		if err := repository.NormalizeRouting(&r); err != nil {
+			return nil, fmt.Errorf("Invalid routing definition %s: %s", r.JobCategory, err)
+		}
+		if !isKept(r.QueueName) {
+			return nil, fmt.Errorf("Routing %s refers to an undeclared queue: %s", r.JobCategory, r.QueueName)
+		}
+		for _, t := range r.Targets {
+			if !isKept(t.QueueName) {
+				return nil, fmt.Errorf("Routing %s refers to an undeclared queue: %s", r.JobCategory, t.QueueName)
+			}
+		}
+		if r.CallbackURL != "" && !repository.IsHTTPURL(r.CallbackURL) {
+			return nil, fmt.Errorf("Invalid callback_url of routing %s: %s", r.JobCategory, r.CallbackURL)
+		}
+		existing, ok := routings[r.JobCategory]
+		if ok && r.Schema == nil {
+			// The schema may be managed by /routing/{category}/schema.
+			r.Schema = existing.Schema
+		}
+		switch {
+		case !ok:
+			changes = append(changes, Change{Action: ChangeCreate, Routing: &r})
+		case !reflect.DeepEqual(*existing, r):
+			changes = append(changes, Change{Action: ChangeUpdate, Routing: &r, Diffs: diffFields(*existing, r)})
+		}
+	}
+
+	if !prune {
+		return changes, nil
+	}
+
+	for k := range existingRoutings {
+		r := &existingRoutings[k]
+		if !declaredRoutings[r.JobCategory] {
+			changes = append(changes, Change{Action: ChangeDelete, Routing: r})
+		}
+	}
+	var pruned []Change
+	for k := range existingQueues {
+		q := &existingQueues[k]
+		if !isKept(q.Name) {
+			pruned = append(pruned, Change{Action: ChangeDelete, Queue: q})
+		}
+	}
+	sort.Slice(pruned, func(i, j int) bool {
+		return pruned[i].Queue.Name < pruned[j].Queue.Name
+	})
+
+	return append(changes, pruned...), nil
+}
+
+// ApplyDefinitions reconciles the queues and the routings with defs
+// and returns the applied changes.  Queues are added or deleted in the
+// same way as AddJobQueue and DeleteJobQueue.
+//
+// This method is goroutine safe.
+func (s *Service) ApplyDefinitions(defs *Definitions, prune bool) ([]Change, error) {
+	changes, err := PlanDefinitions(&repository.Repositories{Queue: s.queue, Routing: s.routing}, defs, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, c := range changes {
+		var err error
+		switch {
+		case c.Queue != nil && c.Action == ChangeDelete:
+			err = s.DeleteJobQueue(c.Queue.Name)
+		case c.Queue != nil:
+			err = s.AddJobQueue(c.Queue)
+		case c.Action == ChangeDelete:
+			err = s.routing.DeleteByJobCategory(c.Routing.JobCategory)
+		default:
+			_, err = s.routing.Add(c.Routing)
+		}
+		if err != nil {
+			return changes[:k], fmt.Errorf("Cannot %s: %s", c.String(), err)
+		}
+	}
+
+	return changes, nil
+}
+
+// diffFields describes the fields of two structs of the same type
+// which differ, by their JSON names and values.
+func diffFields(old, new interface{}) []string {
+	var diffs []string
+	o, n := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < o.NumField(); i++ {
+		of, nf := o.Field(i).Interface(), n.Field(i).Interface()
+		if reflect.DeepEqual(of, nf) {
+			continue
+		}
+		name := strings.Split(o.Type().Field(i).Tag.Get("json"), ",")[0]
+		ob, _ := json.Marshal(of)
+		nb, _ := json.Marshal(nf)
+		diffs = append(diffs, fmt.Sprintf("%s: %s -> %s", name, ob, nb))
+	}
+	return diffs
+}
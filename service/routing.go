@@ -0,0 +1,209 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/coosir/middleman/allowlist"
+	"github.com/coosir/middleman/jobqueue"
+	"github.com/coosir/middleman/model"
+)
+
+// selectQueue returns the name of the queue to which a job is
+// delivered by its routing, or an empty string if there is no routing.
+//
+// If the routing has targets, a queue is chosen randomly in proportion
+// to their weights.  Jobs having the same routing key are delivered to
+// the same queue as long as the targets are unchanged.
+func selectQueue(routing *model.Routing, job jobqueue.IncomingJob) string {
+	if routing == nil {
+		return ""
+	}
+	if len(routing.Targets) == 0 {
+		return routing.QueueName
+	}
+
+	var total uint64
+	for _, t := range routing.Targets {
+		total += uint64(t.Weight)
+	}
+	if total == 0 {
+		return routing.QueueName
+	}
+
+	var n uint64
+	if key := jobqueue.RoutingKeyOf(job); key != "" {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		n = h.Sum64() % total
+	} else {
+		n = uint64(rand.Int63n(int64(total)))
+	}
+	for _, t := range routing.Targets {
+		if n < uint64(t.Weight) {
+			return t.QueueName
+		}
+		n -= uint64(t.Weight)
+	}
+	return routing.QueueName
+}
+
+// InvalidJobError is an error returned when a job lacks a mandatory
+// field or exceeds a limit of its routing.
+type InvalidJobError struct {
+	Reason string
+}
+
+func (e *InvalidJobError) Error() string {
+	return e.Reason
+}
+
+// routedJob : implements the following interfaces
+// - jobqueue.IncomingJob
+// - jobqueue.HasMetadata
+// - jobqueue.HasRunAt
+// - jobqueue.HasRoutingKey
+// - jobqueue.HasConcurrencyKey
+// - jobqueue.HasDebounce
+// - jobqueue.HasTenant
+type routedJob struct {
+	jobqueue.IncomingJob
+	url        string
+	timeout    uint
+	retryCount uint
+	retryDelay uint
+	metadata   *jobqueue.Metadata
+}
+
+func (j *routedJob) URL() string                  { return j.url }
+func (j *routedJob) Timeout() uint                { return j.timeout }
+func (j *routedJob) RetryCount() uint             { return j.retryCount }
+func (j *routedJob) RetryDelay() uint             { return j.retryDelay }
+func (j *routedJob) Metadata() *jobqueue.Metadata { return j.metadata }
+func (j *routedJob) RunAt() uint64                { return jobqueue.RunAtOf(j.IncomingJob) }
+func (j *routedJob) RoutingKey() string           { return jobqueue.RoutingKeyOf(j.IncomingJob) }
+func (j *routedJob) ConcurrencyKey() string       { return jobqueue.ConcurrencyKeyOf(j.IncomingJob) }
+func (j *routedJob) Debounce() *jobqueue.Debounce { return jobqueue.DebounceOf(j.IncomingJob) }
+func (j *routedJob) Tenant() string               { return jobqueue.TenantOf(j.IncomingJob) }
+
+// HasExplicitFields is an interface describing that it tells which of
+// the timeout, the max retries and the retry delay are given, so that
+// the defaults of its routing do not override them even if they are
+// zero.  Zero values of a job without it are regarded as omitted.
+//
+// This is typically an IncomingJob sub-interface.
+type HasExplicitFields interface {
+	HasTimeout() bool
+	HasRetryCount() bool
+	HasRetryDelay() bool
+}
+
+// applyRouting fills the fields of a job which it omits with the
+// defaults of its routing and checks the limits of the routing.
+func applyRouting(job jobqueue.IncomingJob, routing *model.Routing, global globalAllowlist) (jobqueue.IncomingJob, error) {
+	if routing == nil {
+		routing = &model.Routing{}
+	}
+
+	j := &routedJob{
+		IncomingJob: job,
+		url:         job.URL(),
+		timeout:     job.Timeout(),
+		retryCount:  job.RetryCount(),
+		retryDelay:  job.RetryDelay(),
+		metadata:    jobqueue.MetadataOf(job),
+	}
+	if j.url == "" {
+		j.url = routing.JobURL(job.Category())
+	}
+	if j.url == "" {
+		return nil, &InvalidJobError{"Missing field: url"}
+	}
+	omits := func(v uint, has func(HasExplicitFields) bool) bool {
+		if explicit, ok := job.(HasExplicitFields); ok {
+			return !has(explicit)
+		}
+		return v == 0
+	}
+	if omits(j.timeout, HasExplicitFields.HasTimeout) {
+		j.timeout = routing.Timeout
+	}
+	if omits(j.retryCount, HasExplicitFields.HasRetryCount) {
+		j.retryCount = routing.MaxRetries
+	}
+	if omits(j.retryDelay, HasExplicitFields.HasRetryDelay) {
+		j.retryDelay = routing.RetryDelay
+	}
+
+	if limit := routing.TimeoutLimit; limit > 0 {
+		if j.timeout == 0 {
+			j.timeout = limit
+		} else if j.timeout > limit {
+			return nil, &InvalidJobError{fmt.Sprintf("timeout should not be greater than %d", limit)}
+		}
+	}
+	if limit := routing.MaxRetriesLimit; limit > 0 && j.retryCount > limit {
+		return nil, &InvalidJobError{fmt.Sprintf("max_retries should not be greater than %d", limit)}
+	}
+
+	if routing.CallbackURL != "" && (j.metadata == nil || j.metadata.CallbackURL == "") {
+		j.updateMetadata(func(m *jobqueue.Metadata) { m.CallbackURL = routing.CallbackURL })
+	}
+
+	if err := checkDestinations(j, routing, global); err != nil {
+		return nil, err
+	}
+	if !routing.Allowlist.IsEmpty() {
+		j.updateMetadata(func(m *jobqueue.Metadata) { m.Allowlist = routing.Allowlist })
+	}
+
+	return j, nil
+}
+
+// updateMetadata updates a copy of the metadata of the job.
+func (j *routedJob) updateMetadata(update func(m *jobqueue.Metadata)) {
+	metadata := &jobqueue.Metadata{}
+	if j.metadata != nil {
+		*metadata = *j.metadata
+	}
+	update(metadata)
+	j.metadata = metadata
+}
+
+// globalAllowlist is the global allowlist loaded from the configuration
+// or the error in it, which is reported on pushing jobs.
+type globalAllowlist struct {
+	allowlist *allowlist.Allowlist
+	err       error
+}
+
+func loadGlobalAllowlist() globalAllowlist {
+	a, err := allowlist.FromConfig()
+	return globalAllowlist{a, err}
+}
+
+// checkDestinations checks the URL and the callback URL of a job
+// against the global allowlist and the allowlist of its routing.
+func checkDestinations(j *routedJob, routing *model.Routing, global globalAllowlist) error {
+	if global.err != nil {
+		return global.err
+	}
+	local, err := allowlist.New(routing.Allowlist)
+	if err != nil {
+		return err
+	}
+
+	urls := []string{j.url}
+	if j.metadata != nil && j.metadata.CallbackURL != "" {
+		urls = append(urls, j.metadata.CallbackURL)
+	}
+	for _, u := range urls {
+		for _, a := range []*allowlist.Allowlist{global.allowlist, local} {
+			if err := a.CheckURL(u); err != nil {
+				return &InvalidJobError{err.Error()}
+			}
+		}
+	}
+	return nil
+}
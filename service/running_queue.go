@@ -22,9 +22,12 @@ type runningQueue struct {
 	dispatcher dispatcher.Dispatcher
 }
 
-func startJobQueue(q *model.Queue) *runningQueue {
+func startJobQueue(q *model.Queue, cfg dispatcher.Config, notify func(string, jobqueue.Job, *jobqueue.Result)) *runningQueue {
 	jq := factory.Start(q)
-	d := dispatcher.Start(jq, q)
+	jq.OnFinish(func(job jobqueue.Job, res *jobqueue.Result) {
+		notify(q.Name, job, res)
+	})
+	d := cfg.Start(jq, q)
 	return &runningQueue{jq, d}
 }
 
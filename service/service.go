@@ -7,6 +7,8 @@ import (
 	"sync"
 
 	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/dispatcher"
+	"github.com/coosir/middleman/dispatcher/worker"
 	jobqueue "github.com/coosir/middleman/jobqueue/factory"
 	"github.com/coosir/middleman/model"
 	"github.com/coosir/middleman/repository"
@@ -19,28 +21,41 @@ import (
 type PushResult struct {
 	ID        uint64
 	QueueName string
+	Job       jobqueue.IncomingJob // with the defaults of the routing
 }
 
 // Service is an application use case service that manages running
 // queues.
 type Service struct {
-	defaultQueueName string
-	queue            repository.QueueRepository
-	routing          repository.RoutingRepository
-	runningQueues    map[string]RunningQueue
-	mu               sync.Mutex
-	muJob            sync.RWMutex
-	queueW           *configWatcher
-	routingW         *configWatcher
+	defaultQueueName   string
+	callbackQueueName  string
+	callbackMaxRetries uint
+	callbackRetryDelay uint
+	queue              repository.QueueRepository
+	routing            repository.RoutingRepository
+	runningQueues      map[string]RunningQueue
+	callbackQueue      RunningQueue
+	mu                 sync.Mutex
+	muJob              sync.RWMutex
+	muCallback         sync.RWMutex
+	muDefault          sync.RWMutex
+	allowlist          globalAllowlist
+	muAllowlist        sync.RWMutex
+	queueW             *configWatcher
+	routingW           *configWatcher
 }
 
 // NewService creates a new Service instance.
 func NewService(repos *repository.Repositories) *Service {
 	s := &Service{
-		defaultQueueName: config.Get("queue_default"),
-		queue:            repos.Queue,
-		routing:          repos.Routing,
-		runningQueues:    make(map[string]RunningQueue),
+		defaultQueueName:   config.Get("queue_default"),
+		callbackQueueName:  config.Get("callback_queue"),
+		callbackMaxRetries: callbackMaxRetries(),
+		callbackRetryDelay: callbackRetryDelay(),
+		queue:              repos.Queue,
+		routing:            repos.Routing,
+		runningQueues:      make(map[string]RunningQueue),
+		allowlist:          loadGlobalAllowlist(),
 	}
 	s.queueW = newConfigWatcher(
 		s.queue.Revision,
@@ -126,6 +141,9 @@ func (s *Service) DeleteJobQueue(qn string) error {
 		<-jq.Stop()
 		delete(s.runningQueues, qn)
 	}
+	if qn == s.callbackQueueName {
+		s.setCallbackQueue(nil)
+	}
 
 	return nil
 }
@@ -147,6 +165,24 @@ func (s *Service) AddJobQueue(q *model.Queue) error {
 const throttleQueuePollingInterval = 100
 
 func (s *Service) addJobQueue(q *model.Queue) error {
+	if err := normalizeQueue(q); err != nil {
+		return err
+	}
+
+	updated, err := s.queue.Add(q)
+	if err != nil {
+		return err
+	}
+	if updated {
+		s.putJobQueue(q)
+	}
+
+	return nil
+}
+
+// normalizeQueue validates a queue definition and fills in default
+// values.
+func normalizeQueue(q *model.Queue) error {
 	switch {
 	case q.MaxDispatchesPerSecond > 0.0:
 		if q.MaxBurstSize == 0 {
@@ -166,12 +202,83 @@ func (s *Service) addJobQueue(q *model.Queue) error {
 		q.MaxWorkers = defaultMaxWorkers()
 	}
 
-	updated, err := s.queue.Add(q)
-	if err != nil {
-		return err
+	if q.ReservedWorkers > q.MaxWorkers {
+		return errors.New("ReservedWorkers should not be greater than MaxWorkers")
 	}
-	if updated {
-		s.putJobQueue(q)
+
+	if q.AdaptiveWorkers {
+		if q.MinWorkers == 0 {
+			q.MinWorkers = 1
+		}
+		if q.MinWorkers > q.MaxWorkers {
+			return errors.New("MinWorkers should not be greater than MaxWorkers")
+		}
+	} else if q.MinWorkers != 0 || q.TargetLatency != 0 {
+		return errors.New("Cannot configure MinWorkers or TargetLatency without AdaptiveWorkers")
+	}
+
+	if q.MaxLength == 0 && q.MaxAge == 0 {
+		if q.OverflowPolicy != "" || q.SpillQueue != "" {
+			return errors.New("Cannot configure OverflowPolicy or SpillQueue without MaxLength or MaxAge")
+		}
+	} else if q.OverflowPolicy == "" {
+		q.OverflowPolicy = jobqueue.OverflowReject
+	}
+	switch q.OverflowPolicy {
+	case "", jobqueue.OverflowReject, jobqueue.OverflowDropOldest:
+		if q.SpillQueue != "" {
+			return errors.New("Cannot configure SpillQueue without spill OverflowPolicy")
+		}
+	case jobqueue.OverflowSpill:
+		if q.SpillQueue == "" || q.SpillQueue == q.Name {
+			return errors.New("SpillQueue should be another queue")
+		}
+	default:
+		return fmt.Errorf("Unknown OverflowPolicy: %s", q.OverflowPolicy)
+	}
+
+	if len(q.RateLimits) == 0 {
+		if q.RateLimitBy != "" {
+			return errors.New("Cannot configure RateLimitBy without RateLimits")
+		}
+		q.RateLimits = nil
+	} else if q.RateLimitBy == "" {
+		q.RateLimitBy = dispatcher.RateLimitByCategory
+	}
+	switch q.RateLimitBy {
+	case "", dispatcher.RateLimitByCategory, dispatcher.RateLimitByHost:
+	default:
+		return fmt.Errorf("Unknown RateLimitBy: %s", q.RateLimitBy)
+	}
+	for key, limit := range q.RateLimits {
+		if limit.MaxDispatchesPerSecond <= 0.0 || limit.MaxBurstSize == 0 {
+			return fmt.Errorf("RateLimits should have positive MaxDispatchesPerSecond and MaxBurstSize: %s", key)
+		}
+	}
+
+	switch q.FairShare {
+	case "":
+		if len(q.FairShareWeights) > 0 {
+			return errors.New("Cannot configure FairShareWeights without FairShare")
+		}
+	case jobqueue.FairShareCategory, jobqueue.FairShareTenant:
+		for group, weight := range q.FairShareWeights {
+			if weight == 0 {
+				return fmt.Errorf("FairShareWeights should be positive: %s", group)
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown FairShare: %s", q.FairShare)
+	}
+	if len(q.FairShareWeights) == 0 {
+		q.FairShareWeights = nil
+	}
+
+	if len(q.DispatchWindows) == 0 && len(q.BlackoutWindows) == 0 && q.DispatchTimezone != "" {
+		return errors.New("Cannot configure DispatchTimezone without DispatchWindows or BlackoutWindows")
+	}
+	if err := dispatcher.ValidateSchedule(q); err != nil {
+		return err
 	}
 
 	return nil
@@ -180,18 +287,37 @@ func (s *Service) addJobQueue(q *model.Queue) error {
 // Push pushes a job to a queue.  The target queue is determined by
 // the category of the job and defined routings.
 func (s *Service) Push(job jobqueue.IncomingJob) (*PushResult, error) {
-	qn := s.routing.FindQueueNameByJobCategory(job.Category())
+	routing := s.routing.FindByJobCategory(job.Category())
+	qn := selectQueue(routing, job)
 	if qn == "" {
-		qn = s.defaultQueueName
+		qn = s.getDefaultQueueName()
 	}
 	if qn == "" {
 		s.routing.Reload()
-		qn = s.routing.FindQueueNameByJobCategory(job.Category())
+		routing = s.routing.FindByJobCategory(job.Category())
+		qn = selectQueue(routing, job)
 	}
 	if qn == "" {
 		return nil, fmt.Errorf("No routing of job category '%s' exists", job.Category())
 	}
+	job, err := applyRouting(job, routing, s.getAllowlist())
+	if err != nil {
+		return nil, err
+	}
 
+	id, err := s.pushTo(qn, job)
+	if oe, ok := err.(*jobqueue.OverflowError); ok && oe.SpillQueue != "" {
+		qn = oe.SpillQueue
+		id, err = s.pushTo(qn, job)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &PushResult{ID: id, QueueName: qn, Job: job}, nil
+}
+
+func (s *Service) pushTo(qn string, job jobqueue.IncomingJob) (uint64, error) {
 	ok, id, err := func() (bool, uint64, error) {
 		s.muJob.RLock()
 		defer s.muJob.RUnlock()
@@ -205,7 +331,7 @@ func (s *Service) Push(job jobqueue.IncomingJob) (*PushResult, error) {
 		return ok, id, err
 	}()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	if !ok {
@@ -221,17 +347,66 @@ func (s *Service) Push(job jobqueue.IncomingJob) (*PushResult, error) {
 
 		q, err := s.queue.FindByName(qn)
 		if err != nil {
-			return nil, fmt.Errorf("Undefined queue: %s", qn)
+			return 0, fmt.Errorf("Undefined queue: %s", qn)
 		}
 		jq := s.putJobQueue(q)
 
 		id, err = jq.Push(job)
 		if err != nil {
-			return nil, err
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// Reconfigure applies the configuration values which can be changed at
+// runtime: the refresh interval of queues and routings, and the default
+// queue, which is created if it does not exist.
+//
+// This method is goroutine safe.
+func (s *Service) Reconfigure() error {
+	interval := configRefreshInterval()
+	s.queueW.setInterval(interval)
+	s.routingW.setInterval(interval)
+
+	s.muAllowlist.Lock()
+	s.allowlist = loadGlobalAllowlist()
+	s.muAllowlist.Unlock()
+
+	queueName := config.Get("queue_default")
+	if queueName == s.getDefaultQueueName() {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.muJob.Lock()
+	defer s.muJob.Unlock()
+
+	if len(queueName) > 0 {
+		if err := s.initDefaultQueue(queueName); err != nil {
+			return fmt.Errorf("Cannot create default job queue: %s", queueName)
 		}
 	}
 
-	return &PushResult{ID: id, QueueName: qn}, nil
+	s.muDefault.Lock()
+	defer s.muDefault.Unlock()
+	s.defaultQueueName = queueName
+	return nil
+}
+
+func (s *Service) getAllowlist() globalAllowlist {
+	s.muAllowlist.RLock()
+	defer s.muAllowlist.RUnlock()
+	return s.allowlist
+}
+
+func (s *Service) getDefaultQueueName() string {
+	s.muDefault.RLock()
+	defer s.muDefault.RUnlock()
+	return s.defaultQueueName
 }
 
 func (s *Service) startup() {
@@ -252,6 +427,13 @@ func (s *Service) startup() {
 		}
 	}
 
+	if len(s.callbackQueueName) > 0 {
+		err := s.initDefaultQueue(s.callbackQueueName)
+		if err != nil {
+			log.Panic().Msgf("Cannot create callback job queue: %s", s.callbackQueueName)
+		}
+	}
+
 	log.Info().Msgf("Started %d queue dispatchers", len(s.runningQueues))
 }
 
@@ -294,11 +476,30 @@ func (s *Service) putJobQueue(q *model.Queue) RunningQueue {
 		delete(s.runningQueues, q.Name)
 	}
 
-	jq := startJobQueue(q)
+	cfg := dispatcher.Config{}
+	if q.Name == s.callbackQueueName {
+		logger := log.With().Str("package", "dispatcher").Str("queue", q.Name).Logger()
+		cfg.Worker = &worker.HTTPWorker{
+			Logger:             &logger,
+			InsecureSkipVerify: q.InsecureSkipVerify,
+			StatusOnly:         true,
+		}
+	}
+
+	jq := startJobQueue(q, cfg, s.notify)
 	s.runningQueues[q.Name] = jq
+	if q.Name == s.callbackQueueName {
+		s.setCallbackQueue(jq)
+	}
 	return jq
 }
 
+func (s *Service) setCallbackQueue(q RunningQueue) {
+	s.muCallback.Lock()
+	defer s.muCallback.Unlock()
+	s.callbackQueue = q
+}
+
 func (s *Service) deactivateQueues() {
 	n := len(s.runningQueues)
 	ch := make(chan struct{}, n)
@@ -328,6 +529,7 @@ func (s *Service) destroyQueues() {
 		n--
 	}
 	s.runningQueues = make(map[string]RunningQueue)
+	s.setCallbackQueue(nil)
 }
 
 func defaultPollingInterval() uint {
@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -13,7 +16,8 @@ import (
 	"github.com/coosir/middleman/config"
 	"github.com/coosir/middleman/jobqueue"
 	"github.com/coosir/middleman/model"
-	repository "github.com/coosir/middleman/repository/factory"
+	"github.com/coosir/middleman/repository"
+	repositoryFactory "github.com/coosir/middleman/repository/factory"
 	"github.com/coosir/middleman/test"
 )
 
@@ -172,6 +176,130 @@ func TestAddJobQueue(t *testing.T) {
 			t.Error("AddJobQueue should fail with MaxDispatchesPerSecond but without MaxBurstSize")
 		}
 	}()
+
+	func() {
+		q := &model.Queue{
+			Name:            queueName,
+			MaxWorkers:      10,
+			AdaptiveWorkers: true,
+			TargetLatency:   500,
+		}
+		err := svc.AddJobQueue(q)
+		if err != nil {
+			t.Error(err)
+		}
+		if q.MinWorkers != 1 {
+			t.Error("Adaptive queue should have a default MinWorkers")
+		}
+
+		q1, ok := svc.GetJobQueue(queueName)
+		if q1 == nil || !ok {
+			t.Error("Defined queue should be retrieved")
+		}
+		if q1.WorkerStats().ConcurrencyLimit != 1 {
+			t.Error("Adaptive queue should start from MinWorkers")
+		}
+	}()
+
+	func() {
+		q := &model.Queue{
+			Name:            queueName,
+			MaxWorkers:      10,
+			AdaptiveWorkers: true,
+			MinWorkers:      11,
+		}
+		err := svc.AddJobQueue(q)
+		if err == nil {
+			t.Error("AddJobQueue should fail with MinWorkers greater than MaxWorkers")
+		}
+	}()
+
+	func() {
+		q := &model.Queue{
+			Name:       queueName,
+			MinWorkers: 1,
+		}
+		err := svc.AddJobQueue(q)
+		if err == nil {
+			t.Error("AddJobQueue should fail with MinWorkers but without AdaptiveWorkers")
+		}
+	}()
+
+	func() {
+		q := &model.Queue{
+			Name:      queueName,
+			MaxLength: 10,
+		}
+		err := svc.AddJobQueue(q)
+		if err != nil {
+			t.Error(err)
+		}
+		if q.OverflowPolicy != "reject" {
+			t.Error("Limited queue should have a default OverflowPolicy")
+		}
+	}()
+
+	for _, q := range []*model.Queue{
+		{Name: queueName, OverflowPolicy: "reject"},
+		{Name: queueName, MaxLength: 10, OverflowPolicy: "unknown"},
+		{Name: queueName, MaxLength: 10, OverflowPolicy: "spill"},
+		{Name: queueName, MaxLength: 10, OverflowPolicy: "spill", SpillQueue: queueName},
+		{Name: queueName, MaxLength: 10, SpillQueue: "other"},
+	} {
+		if err := svc.AddJobQueue(q); err == nil {
+			t.Errorf("AddJobQueue should fail with invalid limits: %#v", q)
+		}
+	}
+
+	if err := svc.AddJobQueue(&model.Queue{Name: queueName, MaxWorkers: 5, ReservedWorkers: 10}); err == nil {
+		t.Error("AddJobQueue should fail with ReservedWorkers greater than MaxWorkers")
+	}
+
+	for _, q := range []*model.Queue{
+		{Name: queueName, FairShare: "unknown"},
+		{Name: queueName, FairShareWeights: map[string]uint{"a": 2}},
+		{Name: queueName, FairShare: "category", FairShareWeights: map[string]uint{"a": 0}},
+	} {
+		if err := svc.AddJobQueue(q); err == nil {
+			t.Errorf("AddJobQueue should fail with invalid fair share: %#v", q)
+		}
+	}
+
+	func() {
+		q := &model.Queue{
+			Name:       queueName,
+			RateLimits: map[string]model.RateLimit{"*": {MaxDispatchesPerSecond: 1, MaxBurstSize: 1}},
+		}
+		err := svc.AddJobQueue(q)
+		if err != nil {
+			t.Error(err)
+		}
+		if q.RateLimitBy != "category" {
+			t.Error("Rate limited queue should be limited by categories by default")
+		}
+	}()
+
+	for _, q := range []*model.Queue{
+		{Name: queueName, RateLimitBy: "host"},
+		{Name: queueName, RateLimitBy: "unknown", RateLimits: map[string]model.RateLimit{"*": {MaxDispatchesPerSecond: 1, MaxBurstSize: 1}}},
+		{Name: queueName, RateLimits: map[string]model.RateLimit{"*": {MaxDispatchesPerSecond: 1}}},
+		{Name: queueName, RateLimits: map[string]model.RateLimit{"*": {MaxBurstSize: 1}}},
+	} {
+		if err := svc.AddJobQueue(q); err == nil {
+			t.Errorf("AddJobQueue should fail with invalid rate limits: %#v", q)
+		}
+	}
+
+	for _, q := range []*model.Queue{
+		{Name: queueName, DispatchTimezone: "Asia/Tokyo"},
+		{Name: queueName, DispatchTimezone: "Nowhere/Unknown", DispatchWindows: []model.DispatchWindow{{Start: "09:00", End: "17:00"}}},
+		{Name: queueName, DispatchWindows: []model.DispatchWindow{{Weekdays: []string{"someday"}, Start: "09:00", End: "17:00"}}},
+		{Name: queueName, BlackoutWindows: []model.DispatchWindow{{Start: "09:00", End: "25:00"}}},
+	} {
+		if err := svc.AddJobQueue(q); err == nil {
+			t.Errorf("AddJobQueue should fail with invalid dispatch windows: %#v", q)
+		}
+	}
 }
 
 func TestDeleteJobQueue(t *testing.T) {
@@ -228,7 +356,7 @@ func TestDefaultQueue(t *testing.T) {
 
 	})
 
-	r := repository.NewRepositories()
+	r := repositoryFactory.NewRepositories()
 	r.Queue.DeleteByName(queueName)
 }
 
@@ -243,7 +371,7 @@ func TestBindFailure(t *testing.T) {
 	jobCategory := "service_bind_failure_test_job"
 	queueName := "service_bind_failure_test_queue"
 
-	if _, err := svc.routing.Add(jobCategory, queueName); err == nil {
+	if _, err := svc.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err == nil {
 		t.Error("Binding undefined queue to a job should fail")
 	}
 }
@@ -264,7 +392,7 @@ func TestPush(t *testing.T) {
 		}
 	}()
 
-	if _, err := svc.routing.Add(jobCategory, queueName); err != nil {
+	if _, err := svc.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
 		t.Error(err)
 	}
 
@@ -304,6 +432,359 @@ func TestPush(t *testing.T) {
 	}
 }
 
+func TestCallback(t *testing.T) {
+	jobCategory := "service_callback_test_job"
+	queueName := "service_callback_test_queue"
+
+	var svc *Service
+	config.Locally("callback_queue", "service_callback_test_callback_queue", func() {
+		svc = newService()
+	})
+	defer func() { <-svc.Stop() }()
+	defer svc.DeleteJobQueue("service_callback_test_callback_queue")
+	defer svc.DeleteJobQueue(queueName)
+
+	if err := svc.AddJobQueue(&model.Queue{Name: queueName, MaxWorkers: uint(10)}); err != nil {
+		t.Error(err)
+	}
+
+	receiver := newTestWorker(t)
+	defer receiver.close()
+
+	if _, err := svc.routing.Add(&model.Routing{
+		JobCategory: jobCategory,
+		QueueName:   queueName,
+		CallbackURL: receiver.url(),
+	}); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for up
+
+	worker := newTestWorker(t)
+	defer worker.close()
+
+	r, err := svc.Push(&incomingJob{
+		category: jobCategory,
+		url:      worker.url(),
+		payload:  `{"status":"success","message":"done"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker.wait(3 * time.Second)
+
+	var cb struct {
+		ID        uint64          `json:"id"`
+		QueueName string          `json:"queue_name"`
+		Category  string          `json:"category"`
+		Result    jobqueue.Result `json:"result"`
+		Attempts  uint            `json:"attempts"`
+	}
+	if err := json.Unmarshal([]byte(receiver.wait(3*time.Second)), &cb); err != nil {
+		t.Fatal(err)
+	}
+	if cb.ID != r.ID || cb.QueueName != queueName || cb.Category != jobCategory {
+		t.Errorf("Wrong callback: %+v", cb)
+	}
+	if cb.Result.Status != jobqueue.ResultStatusSuccess || cb.Result.Message != "done" {
+		t.Errorf("Wrong result: %+v", cb.Result)
+	}
+	if cb.Attempts != 1 {
+		t.Errorf("Wrong attempts: %d", cb.Attempts)
+	}
+}
+
+func TestCallbackOfExpiredJob(t *testing.T) {
+	jobCategory := "service_callback_expired_test_job"
+	queueName := "service_callback_expired_test_queue"
+
+	var svc *Service
+	config.Locally("callback_queue", "service_callback_expired_test_callback_queue", func() {
+		svc = newService()
+	})
+	defer func() { <-svc.Stop() }()
+	defer svc.DeleteJobQueue("service_callback_expired_test_callback_queue")
+	defer svc.DeleteJobQueue(queueName)
+
+	if err := svc.AddJobQueue(&model.Queue{Name: queueName, PollingInterval: 100, MaxWorkers: uint(10)}); err != nil {
+		t.Error(err)
+	}
+
+	receiver := newTestWorker(t)
+	defer receiver.close()
+
+	if _, err := svc.routing.Add(&model.Routing{
+		JobCategory: jobCategory,
+		QueueName:   queueName,
+		CallbackURL: receiver.url(),
+	}); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for up
+
+	worker := newTestWorker(t)
+	defer worker.close()
+
+	// The job expires while it is waiting.
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	r, err := svc.Push(&incomingJob{
+		category:  jobCategory,
+		url:       worker.url(),
+		nextDelay: 500,
+		metadata:  &jobqueue.Metadata{ExpireAt: now + 200},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cb struct {
+		ID     uint64          `json:"id"`
+		Result jobqueue.Result `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(receiver.wait(3*time.Second)), &cb); err != nil {
+		t.Fatal(err)
+	}
+	if cb.ID != r.ID || cb.Result.Status != jobqueue.ResultStatusExpired {
+		t.Errorf("Wrong callback of an expired job: %+v", cb)
+	}
+	select {
+	case <-worker.worker.request:
+		t.Error("An expired job should not be dispatched")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWeightedRouting(t *testing.T) {
+	jobCategory := "service_weighted_routing_test_job"
+	queueNames := []string{
+		"service_weighted_routing_test_queue_1",
+		"service_weighted_routing_test_queue_2",
+		"service_weighted_routing_test_queue_3",
+	}
+
+	svc := newService()
+	defer func() { <-svc.Stop() }()
+	for _, qn := range queueNames {
+		defer svc.DeleteJobQueue(qn)
+		if err := svc.AddJobQueue(&model.Queue{Name: qn, MaxWorkers: uint(10)}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	routing := &model.Routing{
+		JobCategory: jobCategory,
+		Targets: []model.RoutingTarget{
+			{QueueName: queueNames[2], Weight: 0},
+			{QueueName: queueNames[1], Weight: 1},
+			{QueueName: queueNames[0], Weight: 1},
+		},
+	}
+	if err := repository.NormalizeRouting(routing); err != nil {
+		t.Fatal(err)
+	}
+	if routing.QueueName != queueNames[0] || routing.Targets[0].QueueName != queueNames[0] {
+		t.Errorf("Targets should be sorted and the first heaviest should be the queue: %+v", routing)
+	}
+	if _, err := svc.routing.Add(routing); err != nil {
+		t.Error(err)
+	}
+	defer svc.routing.DeleteByJobCategory(jobCategory)
+
+	time.Sleep(100 * time.Millisecond) // wait for up
+
+	worker := newTestWorker(t)
+	defer worker.close()
+
+	pushed := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		r, err := svc.Push(&incomingJob{category: jobCategory, url: worker.url()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushed[r.QueueName]++
+	}
+	if pushed[queueNames[0]] == 0 || pushed[queueNames[1]] == 0 {
+		t.Errorf("Jobs should be split among the targets: %v", pushed)
+	}
+	if pushed[queueNames[2]] != 0 {
+		t.Errorf("No job should be delivered to a target without weight: %v", pushed)
+	}
+
+	var first string
+	for i := 0; i < 20; i++ {
+		r, err := svc.Push(&incomingJob{category: jobCategory, url: worker.url(), routingKey: "user:1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == "" {
+			first = r.QueueName
+		}
+		if r.QueueName != first {
+			t.Errorf("Jobs with the same routing key should go to the same queue: %s != %s", r.QueueName, first)
+		}
+	}
+
+	for i := 0; i < 120; i++ {
+		worker.wait(3 * time.Second)
+	}
+}
+
+func TestRoutingJobDefaults(t *testing.T) {
+	jobCategory := "service_routing_defaults_test_job"
+	queueName := "service_routing_defaults_test_queue"
+
+	svc := newService()
+	defer func() { <-svc.Stop() }()
+	defer svc.DeleteJobQueue(queueName)
+
+	if err := svc.AddJobQueue(&model.Queue{Name: queueName, MaxWorkers: uint(10)}); err != nil {
+		t.Error(err)
+	}
+
+	worker := newTestWorker(t)
+	defer worker.close()
+
+	routing := &model.Routing{
+		JobCategory:     jobCategory,
+		QueueName:       queueName,
+		URL:             worker.url() + "/" + model.CategoryPlaceholder,
+		RetryDelay:      3,
+		TimeoutLimit:    10,
+		MaxRetriesLimit: 2,
+	}
+	if _, err := svc.routing.Add(routing); err != nil {
+		t.Error(err)
+	}
+	defer svc.routing.DeleteByJobCategory(jobCategory)
+
+	time.Sleep(100 * time.Millisecond) // wait for up
+
+	r, err := svc.Push(&incomingJob{category: jobCategory, retryCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := r.Job.URL(); u != worker.url()+"/"+jobCategory {
+		t.Errorf("The default URL should be applied: %s", u)
+	}
+	if r.Job.RetryDelay() != 3 || r.Job.RetryCount() != 1 {
+		t.Errorf("Only omitted fields should be filled: %d, %d", r.Job.RetryDelay(), r.Job.RetryCount())
+	}
+	if r.Job.Timeout() != 10 {
+		t.Errorf("A job without timeout should have the limit: %d", r.Job.Timeout())
+	}
+	worker.wait(3 * time.Second)
+
+	r, err = svc.Push(&explicitJob{&incomingJob{category: jobCategory}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Job.RetryDelay() != 0 {
+		t.Errorf("An explicit zero should not be replaced with the default: %d", r.Job.RetryDelay())
+	}
+	worker.wait(3 * time.Second)
+
+	_, err = svc.Push(&incomingJob{category: jobCategory, retryCount: 3})
+	if _, ok := err.(*InvalidJobError); !ok {
+		t.Errorf("A job exceeding the limit should be rejected: %v", err)
+	}
+
+	_, err = svc.Push(&incomingJob{category: "service_routing_defaults_test_unknown"})
+	if err == nil {
+		t.Error("A job without URL should be rejected")
+	}
+}
+
+func TestPushAllowlist(t *testing.T) {
+	jobCategory := "service_push_allowlist_test_job"
+	queueName := "service_push_allowlist_test_queue"
+
+	svc := newService()
+	defer func() { <-svc.Stop() }()
+	defer svc.DeleteJobQueue(queueName)
+
+	if err := svc.AddJobQueue(&model.Queue{Name: queueName, MaxWorkers: uint(10)}); err != nil {
+		t.Error(err)
+	}
+	if _, err := svc.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
+		t.Error(err)
+	}
+	defer svc.routing.DeleteByJobCategory(jobCategory)
+
+	job := &incomingJob{category: jobCategory, url: "http://example.com/", nextDelay: 60000}
+	config.Locally("dispatch_allowed_hosts", "example.org", func() {
+		if _, err := svc.Push(job); err != nil {
+			t.Errorf("The allowlist should be loaded only on reconfiguration: %v", err)
+		}
+		if err := svc.Reconfigure(); err != nil {
+			t.Error(err)
+		}
+		if _, err := svc.Push(job); err == nil {
+			t.Error("A job to a host out of the allowlist should be rejected")
+		}
+	})
+	if err := svc.Reconfigure(); err != nil {
+		t.Error(err)
+	}
+	if _, err := svc.Push(job); err != nil {
+		t.Errorf("A job should be allowed after reconfiguration: %v", err)
+	}
+}
+
+func TestPushOverflow(t *testing.T) {
+	jobCategory := "service_push_overflow_test_job"
+	queueName := "service_push_overflow_test_queue"
+	spillQueueName := "service_push_overflow_test_spill_queue"
+
+	svc := newService()
+	defer func() { <-svc.Stop() }()
+	defer svc.DeleteJobQueue(queueName)
+	defer svc.DeleteJobQueue(spillQueueName)
+
+	if err := svc.AddJobQueue(&model.Queue{
+		Name:       spillQueueName,
+		MaxWorkers: uint(10),
+		MaxLength:  1,
+	}); err != nil {
+		t.Error(err)
+	}
+	if err := svc.AddJobQueue(&model.Queue{
+		Name:           queueName,
+		MaxWorkers:     uint(10),
+		MaxLength:      1,
+		OverflowPolicy: "spill",
+		SpillQueue:     spillQueueName,
+	}); err != nil {
+		t.Error(err)
+	}
+	if _, err := svc.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for up
+
+	job := &incomingJob{
+		category:  jobCategory,
+		url:       "http://localhost/",
+		nextDelay: 60000,
+	}
+
+	for _, expected := range []string{queueName, spillQueueName} {
+		r, err := svc.Push(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.QueueName != expected {
+			t.Errorf("Job should be pushed to %s: %s", expected, r.QueueName)
+		}
+	}
+
+	if _, err := svc.Push(job); err == nil {
+		t.Error("Pushing to full queues should fail")
+	}
+}
+
 func TestPushFailure(t *testing.T) {
 	svc := newService()
 	defer func() { <-svc.Stop() }()
@@ -339,7 +820,7 @@ func TestFailingOver(t *testing.T) {
 		}
 	}()
 
-	if _, err := svc1.routing.Add(jobCategory, queueName); err != nil {
+	if _, err := svc1.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
 		t.Error(err)
 	}
 
@@ -428,7 +909,7 @@ func TestRoutingMishit(t *testing.T) {
 		t.Error("Pushed job should not be delivered if it has no routing")
 	}
 
-	if _, err := svc.routing.Add(jobCategory, queueName); err != nil {
+	if _, err := svc.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
 		t.Error(err)
 	}
 
@@ -470,7 +951,7 @@ func TestReloadingRoutings(t *testing.T) {
 			}
 		}()
 
-		if _, err := svc1.routing.Add(jobCategory, queueName); err != nil {
+		if _, err := svc1.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
 			t.Error(err)
 		}
 
@@ -594,7 +1075,7 @@ func TestReloadingQueueDefinitions(t *testing.T) {
 		}
 		time.Sleep(100 * time.Millisecond) // wait for up
 
-		if _, err := svc1.routing.Add(jobCategory, queueName); err != nil {
+		if _, err := svc1.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
 			t.Error(err)
 		}
 
@@ -629,7 +1110,7 @@ func TestReloadingQueueDefinitions(t *testing.T) {
 		}
 		time.Sleep(100 * time.Millisecond) // wait for up
 
-		if _, err := svc2.routing.Add(jobCategory, queueName); err != nil {
+		if _, err := svc2.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName}); err != nil {
 			t.Error(err)
 		}
 
@@ -648,6 +1129,140 @@ func TestReloadingQueueDefinitions(t *testing.T) {
 	})
 }
 
+func TestDefinitions(t *testing.T) {
+	queueName1 := "service_definitions_test_queue1"
+	queueName2 := "service_definitions_test_queue2"
+	jobCategory := "service_definitions_test_job"
+
+	svc := newService()
+	defer func() { <-svc.Stop() }()
+	defer svc.DeleteJobQueue(queueName1)
+	defer svc.DeleteJobQueue(queueName2)
+	defer svc.routing.DeleteByJobCategory(jobCategory)
+
+	file := filepath.Join(t.TempDir(), "definitions.json")
+	if err := os.WriteFile(file, []byte(`{
+		"queues": [
+			{"name": "`+queueName1+`", "max_workers": 5},
+			{"name": "`+queueName2+`"}
+		],
+		"routings": [
+			{"job_category": "`+jobCategory+`", "queue_name": "`+queueName1+`"}
+		]
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := LoadDefinitions(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := svc.ApplyDefinitions(defs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("Queues and a routing should be created: %v", changes)
+	}
+	if q, ok := svc.GetJobQueue(queueName1); !ok || q.MaxWorkers() != 5 {
+		t.Error("A declared queue should be running")
+	}
+	if qn := svc.routing.FindQueueNameByJobCategory(jobCategory); qn != queueName1 {
+		t.Errorf("A declared routing should be added: %s", qn)
+	}
+
+	repos := &repository.Repositories{Queue: svc.queue, Routing: svc.routing}
+	changes, err = PlanDefinitions(repos, defs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Nothing should be changed twice: %v", changes)
+	}
+
+	withSchema := *svc.routing.FindByJobCategory(jobCategory)
+	withSchema.Schema = json.RawMessage(`{"type":"object"}`)
+	if _, err := svc.routing.Add(&withSchema); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = PlanDefinitions(repos, defs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("A schema should be kept if the definition omits it: %v", changes)
+	}
+
+	defs.Queues = []model.Queue{{Name: queueName1, MaxWorkers: 6}}
+	changes, err = PlanDefinitions(repos, defs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned := make(map[string]bool)
+	for _, c := range changes {
+		planned[c.String()] = true
+		if c.Action == ChangeUpdate && !reflect.DeepEqual(c.Diffs, []string{"max_workers: 5 -> 6"}) {
+			t.Errorf("Wrong diffs: %v", c.Diffs)
+		}
+	}
+	if !planned["update queue "+queueName1] || !planned["delete queue "+queueName2] {
+		t.Errorf("Wrong changes: %v", changes)
+	}
+	if q, _ := svc.GetJobQueue(queueName1); q.MaxWorkers() != 5 {
+		t.Error("Planning should not change anything")
+	}
+
+	if _, err := svc.ApplyDefinitions(defs, true); err != nil {
+		t.Fatal(err)
+	}
+	if q, ok := svc.GetJobQueue(queueName1); !ok || q.MaxWorkers() != 6 {
+		t.Error("A declared queue should be updated")
+	}
+	if _, ok := svc.GetJobQueue(queueName2); ok {
+		t.Error("An undeclared queue should be pruned")
+	}
+
+	defs.Routings = []model.Routing{{JobCategory: jobCategory, QueueName: queueName2}}
+	if _, err := PlanDefinitions(repos, defs, false); err == nil {
+		t.Error("A routing to an undeclared queue should be rejected")
+	}
+}
+
+func TestLoadDefinitionsFromYAML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "definitions.yaml")
+	if err := os.WriteFile(file, []byte(`
+queues:
+  - name: yaml_queue
+    max_workers: 5
+    rate_limits:
+      "*":
+        max_dispatches_per_second: 2
+        max_burst_size: 1
+routings:
+  - job_category: yaml_job
+    queue_name: yaml_queue
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := LoadDefinitions(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs.Queues) != 1 || defs.Queues[0].MaxWorkers != 5 || defs.Queues[0].RateLimits["*"].MaxBurstSize != 1 {
+		t.Errorf("Queues should be loaded from YAML: %+v", defs.Queues)
+	}
+	if len(defs.Routings) != 1 || defs.Routings[0].QueueName != "yaml_queue" {
+		t.Errorf("Routings should be loaded from YAML: %+v", defs.Routings)
+	}
+
+	if err := os.WriteFile(file, []byte("queues:\n  - name: yaml_queue\n    unknown: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDefinitions(file); err == nil {
+		t.Error("Unknown fields should be rejected in YAML")
+	}
+}
+
 func TestFailureLogging(t *testing.T) {
 	if test.If("driver", "in-memory") { // not supported
 		return
@@ -669,7 +1284,7 @@ func TestFailureLogging(t *testing.T) {
 	}()
 
 	func() {
-		_, err := svc.routing.Add(jobCategory, queueName)
+		_, err := svc.routing.Add(&model.Routing{JobCategory: jobCategory, QueueName: queueName})
 		if err != nil {
 			t.Error(err)
 		}
@@ -1021,6 +1636,8 @@ type incomingJob struct {
 	nextDelay  uint64
 	retryDelay uint
 	retryCount uint
+	routingKey string
+	metadata   *jobqueue.Metadata
 }
 
 func (job *incomingJob) Category() string {
@@ -1055,8 +1672,25 @@ func (job *incomingJob) Timeout() uint {
 	return uint(0)
 }
 
+func (job *incomingJob) RoutingKey() string {
+	return job.routingKey
+}
+
+func (job *incomingJob) Metadata() *jobqueue.Metadata {
+	return job.metadata
+}
+
+// explicitJob is an incomingJob which gives all of its fields.
+type explicitJob struct {
+	*incomingJob
+}
+
+func (job *explicitJob) HasTimeout() bool    { return true }
+func (job *explicitJob) HasRetryCount() bool { return true }
+func (job *explicitJob) HasRetryDelay() bool { return true }
+
 func newService() *Service {
-	return NewService(repository.NewRepositories())
+	return NewService(repositoryFactory.NewRepositories())
 }
 
 type testServer struct {
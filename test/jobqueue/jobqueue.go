@@ -1,6 +1,8 @@
 package jqtest
 
 import (
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,6 +64,48 @@ func newTestJob(category, url, data string) jobqueue.IncomingJob {
 	}
 }
 
+type keyedJob struct {
+	jobqueue.IncomingJob
+	concurrencyKey string
+}
+
+func (j *keyedJob) ConcurrencyKey() string {
+	return j.concurrencyKey
+}
+
+func newKeyedTestJob(data, concurrencyKey string) jobqueue.IncomingJob {
+	return &keyedJob{newTestJob("foo", "http://localhost/worker", data), concurrencyKey}
+}
+
+type debouncedJob struct {
+	jobqueue.IncomingJob
+	debounce *jobqueue.Debounce
+}
+
+func (j *debouncedJob) Debounce() *jobqueue.Debounce {
+	return j.debounce
+}
+
+func newDebouncedTestJob(data, key, policy string, window uint) jobqueue.IncomingJob {
+	return &debouncedJob{
+		newTestJob("foo", "http://localhost/worker", data),
+		&jobqueue.Debounce{Key: key, Window: window, Policy: policy},
+	}
+}
+
+type tenantJob struct {
+	jobqueue.IncomingJob
+	tenant string
+}
+
+func (j *tenantJob) Tenant() string {
+	return j.tenant
+}
+
+func newTenantTestJob(category, data, tenant string) jobqueue.IncomingJob {
+	return &tenantJob{newTestJob(category, "http://localhost/worker", data), tenant}
+}
+
 type nextJob struct {
 	jobqueue.Job
 	nextDelay uint64
@@ -112,6 +156,9 @@ func TestSubtests(t *testing.T, runner SubtestRunner) {
 		subtestAsyncPop1,
 		subtestAsyncDelete1,
 		subtestAsyncUpdate1,
+		subtestConcurrencyKey,
+		subtestDebounce,
+		subtestPopGroup,
 	})
 }
 
@@ -653,3 +700,149 @@ func subtestAsyncUpdate1(t *testing.T, jq jobqueue.Impl) {
 
 	<-done
 }
+
+func subtestConcurrencyKey(t *testing.T, jq jobqueue.Impl) {
+	jq.Push(newKeyedTestJob("1", "user:1"))
+	jq.Push(newKeyedTestJob("2", "user:1"))
+	jq.Push(newTestJob("foo", "http://localhost/worker", "3"))
+	jq.Push(newKeyedTestJob("4", "user:2"))
+	jq.Push(newKeyedTestJob("5", "user:2"))
+	time.Sleep(10 * time.Millisecond)
+
+	pop := func(expected ...string) []jobqueue.Job {
+		jobs, err := jq.Pop(10)
+		if err != nil {
+			t.Errorf("Failed to pop job: %s", err)
+		}
+		if len(jobs) != len(expected) {
+			t.Errorf("Wrong queue length: %d", len(jobs))
+			return jobs
+		}
+		for i, num := range expected {
+			if jobs[i].Payload() != num {
+				t.Errorf("Wrong job returned: %v", jobs[i])
+			}
+		}
+		return jobs
+	}
+
+	jobs := pop("1", "3", "4")
+	if len(jobs) != 3 {
+		return
+	}
+	pop()
+
+	jq.Delete(jobs[0])
+	jq.Delete(jobs[1])
+	jobs = append(pop("2"), jobs[2])
+	if len(jobs) != 2 {
+		return
+	}
+
+	jq.Update(jobs[0], &nextJob{jobs[0], 0})
+	time.Sleep(10 * time.Millisecond)
+	pop("2")
+
+	jq.Update(jobs[1], &nextJob{jobs[1], 1000})
+	pop("5")
+}
+
+func subtestDebounce(t *testing.T, jq jobqueue.Impl) {
+	debouncer, ok := jq.(jobqueue.Debouncer)
+	if !ok {
+		return
+	}
+	admit := func() error { return nil }
+	push := func(j jobqueue.IncomingJob, coalesced bool) uint64 {
+		job, c, err := debouncer.PushDebounced(j, jobqueue.DebounceOf(j), admit)
+		if err != nil {
+			t.Fatalf("Failed to push job: %s", err)
+		}
+		if c != coalesced {
+			t.Errorf("Job %s should be coalesced: %v", j.Payload(), coalesced)
+		}
+		return job.ToLoggable().ID()
+	}
+
+	id := push(newDebouncedTestJob("1", "doc:1", jobqueue.DebounceLast, 0), false)
+	if push(newDebouncedTestJob("2", "doc:1", jobqueue.DebounceLast, 0), true) != id {
+		t.Error("A coalesced job should have the ID of the waiting job")
+	}
+	push(newDebouncedTestJob("3", "doc:1", jobqueue.DebounceFirst, 0), true)
+	push(newDebouncedTestJob("4", "doc:2", jobqueue.DebounceLast, 0), false)
+	time.Sleep(10 * time.Millisecond)
+
+	jobs, err := jq.Pop(10)
+	if err != nil {
+		t.Errorf("Failed to pop job: %s", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("Wrong queue length: %d", len(jobs))
+	}
+	for i, num := range []string{"2", "4"} {
+		if jobs[i].Payload() != num {
+			t.Errorf("Wrong job returned: %v", jobs[i])
+		}
+	}
+
+	// A grabbed job is no longer coalesced.
+	push(newDebouncedTestJob("5", "doc:1", jobqueue.DebounceLast, 0), false)
+	// The waiting job is pushed back by the window.
+	push(newDebouncedTestJob("6", "doc:1", jobqueue.DebounceLast, 60), true)
+	time.Sleep(10 * time.Millisecond)
+
+	jobs, err = jq.Pop(10)
+	if err != nil {
+		t.Errorf("Failed to pop job: %s", err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("Wrong queue length: %d", len(jobs))
+	}
+}
+
+func subtestPopGroup(t *testing.T, jq jobqueue.Impl) {
+	popper, ok := jq.(jobqueue.FairPopper)
+	if !ok {
+		return
+	}
+	jq.Push(newTenantTestJob("foo", "1", "alice"))
+	jq.Push(newTenantTestJob("bar", "2", "bob"))
+	jq.Push(newTenantTestJob("foo", "3", "bob"))
+	jq.Push(newTestJob("baz", "http://localhost/worker", "4"))
+	time.Sleep(10 * time.Millisecond)
+
+	readyGroups := func(by string, expected ...string) {
+		groups, err := popper.ReadyGroups(by)
+		if err != nil {
+			t.Errorf("Failed to get ready groups: %s", err)
+		}
+		sort.Strings(groups)
+		if strings.Join(groups, ",") != strings.Join(expected, ",") {
+			t.Errorf("Wrong ready groups by %s: %v", by, groups)
+		}
+	}
+	popGroup := func(by, group string, expected ...string) {
+		jobs, err := popper.PopGroup(by, group, 10)
+		if err != nil {
+			t.Errorf("Failed to pop job: %s", err)
+		}
+		if len(jobs) != len(expected) {
+			t.Errorf("Wrong queue length of %s: %d", group, len(jobs))
+			return
+		}
+		for i, num := range expected {
+			if jobs[i].Payload() != num {
+				t.Errorf("Wrong job returned: %v", jobs[i])
+			}
+		}
+	}
+
+	readyGroups(jobqueue.FairShareCategory, "bar", "baz", "foo")
+	readyGroups(jobqueue.FairShareTenant, "", "alice", "bob")
+	popGroup(jobqueue.FairShareTenant, "bob", "2", "3")
+	readyGroups(jobqueue.FairShareCategory, "baz", "foo")
+	popGroup(jobqueue.FairShareCategory, "bar")
+	popGroup(jobqueue.FairShareCategory, "foo", "1")
+	popGroup(jobqueue.FairShareTenant, "", "4")
+	readyGroups(jobqueue.FairShareTenant)
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/coosir/middleman/repository"
 	"github.com/coosir/middleman/service"
 
-	stats "github.com/fukata/golang-stats-api-handler"
 	"github.com/rs/zerolog/log"
 )
 
@@ -31,29 +30,39 @@ type Application struct {
 	Service           Service
 	QueueRepository   repository.QueueRepository
 	RoutingRepository repository.RoutingRepository
+
+	schemas schemaCache
 }
 
 func (app *Application) newServer() *server {
 	s := newServer(app.AccessLogWriter)
 
+	operator := requireRole(roleOperator)
+	definition := requireRoleToModify(roleOperator, roleAdmin)
+	// Producers look up the status of their own jobs.
+	jobStatus := requireRoleToModify(roleProducer, roleOperator)
+
 	s.handle("/", app.serveVersion)
 	s.handle("/version", app.serveVersion)
-	s.handle("/settings", app.serveSettings)
-	s.mux.HandleFunc("/stats", stats.Handler)
-	s.handle("/job/{category:.+}", app.serveJob)
-	s.handle("/queues", app.serveQueueList)
-	s.handle("/queues/stats", app.serveQueueListStats)
-	s.handle("/queue/{queue:[^/]+}", app.serveQueue)
-	s.handle("/queue/{queue:[^/]+}/node", app.serveQueueNode)
-	s.handle("/queue/{queue:[^/]+}/stats", app.serveQueueStats)
-	s.handle("/queue/{queue:[^/]+}/grabbed", app.serveQueueGrabbed)
-	s.handle("/queue/{queue:[^/]+}/waiting", app.serveQueueWaiting)
-	s.handle("/queue/{queue:[^/]+}/deferred", app.serveQueueDeferred)
-	s.handle("/queue/{queue:[^/]+}/job/{id:[^/]+}", app.serveQueueJob)
-	s.handle("/queue/{queue:[^/]+}/failed", app.serveQueueFailed)
-	s.handle("/queue/{queue:[^/]+}/failed/{id:[^/]+}", app.serveQueueFailedJob)
-	s.handle("/routings", app.serveRoutingList)
-	s.handle("/routing/{category:.+}", app.serveRouting)
+	s.handleWith("/settings", requireRole(roleAdmin), app.serveSettings)
+	s.handleWith("/stats", operator, serveGoStats)
+	s.handleWith("/job/{category:.+}", requireRole(roleProducer), app.serveJob)
+	s.handleWith("/queues", operator, app.serveQueueList)
+	s.handleWith("/queues/stats", operator, app.serveQueueListStats)
+	s.handleWith("/queue/{queue:[^/]+}", definition, app.serveQueue)
+	s.handleWith("/queue/{queue:[^/]+}/node", operator, app.serveQueueNode)
+	s.handleWith("/queue/{queue:[^/]+}/stats", operator, app.serveQueueStats)
+	s.handleWith("/queue/{queue:[^/]+}/grabbed", operator, app.serveQueueGrabbed)
+	s.handleWith("/queue/{queue:[^/]+}/waiting", operator, app.serveQueueWaiting)
+	s.handleWith("/queue/{queue:[^/]+}/deferred", operator, app.serveQueueDeferred)
+	s.handleWith("/queue/{queue:[^/]+}/job/{id:[^/]+}", jobStatus, app.serveQueueJob)
+	s.handleWith("/queue/{queue:[^/]+}/job/{id:[^/]+}/attempts", operator, app.serveQueueJobAttempts)
+	s.handleWith("/queue/{queue:[^/]+}/failed", operator, app.serveQueueFailed)
+	s.handleWith("/queue/{queue:[^/]+}/failed/{id:[^/]+}", operator, app.serveQueueFailedJob)
+	s.handleWith("/routings", operator, app.serveRoutingList)
+	s.handleWith("/routing/{category:.+}/schema", definition, app.serveRoutingSchema)
+	s.handleWith("/routing/{category:.+}", definition, app.serveRouting)
+	s.handleStream("/events", operator, app.serveEvents(s.shutdown))
 
 	return s
 }
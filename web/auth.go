@@ -0,0 +1,138 @@
+package web
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+
+	"github.com/coosir/middleman/config"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"github.com/rs/zerolog/log"
+)
+
+// role describes what an authenticated client is allowed to do.  A
+// role includes all the permissions of the lower roles.
+type role int
+
+const (
+	roleNone     role = iota // no authentication required
+	roleProducer             // pushing jobs
+	roleOperator             // inspecting queues, jobs and failures
+	roleAdmin                // managing queue and routing definitions
+)
+
+var roleNames = map[string]role{
+	"producer": roleProducer,
+	"operator": roleOperator,
+	"admin":    roleAdmin,
+}
+
+// permission returns the role required for a request.
+type permission func(req *http.Request) role
+
+func requireRole(r role) permission {
+	return func(req *http.Request) role {
+		return r
+	}
+}
+
+// requireRoleToModify requires the role read for GET or HEAD requests
+// and the role write for the others.
+func requireRoleToModify(read, write role) permission {
+	return func(req *http.Request) role {
+		if req.Method == "GET" || req.Method == "HEAD" {
+			return read
+		}
+		return write
+	}
+}
+
+type identity struct {
+	name string
+	role role
+}
+
+type authenticator struct {
+	identities map[[sha256.Size]byte]*identity
+}
+
+// newAuthenticator creates an authenticator from "api_tokens"
+// configuration, which is a comma separated list of
+// <name>:<role>:<token>.  It returns nil if no token is configured,
+// which means that authentication is disabled.
+func newAuthenticator() *authenticator {
+	tokens := config.Get("api_tokens")
+	if strings.TrimSpace(tokens) == "" {
+		return nil
+	}
+
+	a := &authenticator{identities: make(map[[sha256.Size]byte]*identity)}
+	for i, entry := range strings.Split(tokens, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, ":", 3)
+		if len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+			log.Panic().Msgf("Invalid API token entry at %d", i)
+		}
+		r, ok := roleNames[fields[1]]
+		if !ok {
+			log.Panic().Msgf("Unknown role of API token %s: %s", fields[0], fields[1])
+		}
+
+		a.identities[sha256.Sum256([]byte(fields[2]))] = &identity{fields[0], r}
+	}
+	return a
+}
+
+// authenticate returns the identity of the client or nil if the
+// request has no known token.
+//
+// A token is taken from either an "Authorization: Bearer <token>"
+// header or an "X-API-Key: <token>" header.  Tokens are compared by
+// their hash values so that the comparison does not leak them.
+func (a *authenticator) authenticate(req *http.Request) *identity {
+	token := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		const prefix = "Bearer "
+		if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+			token = strings.TrimSpace(auth[len(prefix):])
+		}
+	}
+	if token == "" {
+		return nil
+	}
+
+	return a.identities[sha256.Sum256([]byte(token))]
+}
+
+// authorize checks if the client is allowed to make the request and
+// records the authenticated identity in the access log.
+func (a *authenticator) authorize(req *http.Request, p permission) error {
+	if a == nil {
+		return nil
+	}
+
+	required := p(req)
+	if required == roleNone {
+		return nil
+	}
+
+	id := a.authenticate(req)
+	if id == nil {
+		return errUnauthorized
+	}
+
+	hlog.FromRequest(req).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("identity", id.name)
+	})
+
+	if id.role < required {
+		return errForbidden
+	}
+	return nil
+}
@@ -51,6 +51,17 @@ func (e *detailedClientError) clientError() string {
 	return e.Error()
 }
 
+// WithRetryAfter makes the error tell the client to retry the request
+// after the seconds.
+func (e *detailedClientError) WithRetryAfter(seconds uint) *retryableClientError {
+	return &retryableClientError{e, seconds}
+}
+
+type retryableClientError struct {
+	*detailedClientError
+	retryAfter uint
+}
+
 type serverError interface {
 	error
 	serverError() string
@@ -101,6 +112,9 @@ const (
 	errMethodNotAllowed    = simpleClientError(http.StatusMethodNotAllowed)
 	errNotFound            = simpleClientError(http.StatusNotFound)
 	errBadRequest          = simpleClientError(http.StatusBadRequest)
+	errUnauthorized        = simpleClientError(http.StatusUnauthorized)
+	errForbidden           = simpleClientError(http.StatusForbidden)
+	errTooManyRequests     = simpleClientError(http.StatusTooManyRequests)
 	errNotImplemented      = simpleServerError(http.StatusNotImplemented)
 	errInternalServerError = simpleServerError(http.StatusInternalServerError)
 )
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/jobqueue/event"
+
+	"github.com/rs/zerolog/log"
+)
+
+// An interval to send a comment to keep an idle event stream alive
+// through proxies.
+const eventsHeartbeatInterval = 15 * time.Second
+
+// serveEvents streams job lifecycle events as Server-Sent Events until
+// the client disconnects, the server shuts down or the client is
+// dropped for being too slow.
+func (app *Application) serveEvents(shutdown <-chan struct{}) func(w http.ResponseWriter, req *http.Request) error {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		if req.Method != "GET" {
+			return errMethodNotAllowed
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return errNotImplemented.WithDetail("Streaming is not supported")
+		}
+
+		query := req.URL.Query()
+		sub := event.Subscribe(event.Filter{
+			Queue:    query.Get("queue"),
+			Category: query.Get("category"),
+		}, eventsBufferSize())
+		defer event.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					if sub.Dropped() {
+						fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+						flusher.Flush()
+					}
+					return nil
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.Warn().Msg(err.Error())
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+					return nil
+				}
+				flusher.Flush()
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+				flusher.Flush()
+			case <-req.Context().Done():
+				return nil
+			case <-shutdown:
+				return nil
+			}
+		}
+	}
+}
+
+func eventsBufferSize() uint {
+	n, err := strconv.ParseUint(config.Get("events_buffer_size"), 10, 32)
+	if err != nil {
+		n, _ = strconv.ParseUint(config.GetDefault("events_buffer_size"), 10, 32)
+	}
+	return uint(n)
+}
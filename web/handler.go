@@ -2,6 +2,7 @@ package web
 
 import (
 	"net/http"
+	"strconv"
 )
 
 type handler func(w http.ResponseWriter, req *http.Request) error
@@ -14,17 +15,36 @@ func (f handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var rb responseBuffer
 	err := f(&rb, req)
 	if err != nil {
-		if ce, ok := err.(clientError); ok {
-			http.Error(w, ce.clientError(), ce.httpStatus())
-			return
-		}
-		if se, ok := err.(serverError); ok {
-			http.Error(w, se.serverError(), se.httpStatus())
-			return
-		}
-
-		se := errInternalServerError.WithDetail(err.Error())
-		http.Error(w, se.serverError(), se.httpStatus())
+		writeError(w, err)
+		return
 	}
 	rb.WriteTo(w)
 }
+
+// streamHandler is a handler which writes a response directly instead
+// of buffering it.  An error is reported only if nothing has been
+// written yet.
+type streamHandler func(w http.ResponseWriter, req *http.Request) error
+
+func (f streamHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := f(w, req); err != nil {
+		writeError(w, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if re, ok := err.(*retryableClientError); ok {
+		w.Header().Set("Retry-After", strconv.FormatUint(uint64(re.retryAfter), 10))
+	}
+	if ce, ok := err.(clientError); ok {
+		http.Error(w, ce.clientError(), ce.httpStatus())
+		return
+	}
+	if se, ok := err.(serverError); ok {
+		http.Error(w, se.serverError(), se.httpStatus())
+		return
+	}
+
+	se := errInternalServerError.WithDetail(err.Error())
+	http.Error(w, se.serverError(), se.httpStatus())
+}
@@ -3,7 +3,17 @@ package web
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/jobqueue"
+	"github.com/coosir/middleman/repository"
+	"github.com/coosir/middleman/service"
 
 	"github.com/gorilla/mux"
 )
@@ -24,15 +34,44 @@ func (app *Application) serveJob(w http.ResponseWriter, req *http.Request) error
 	if err := job.DecodePayload(); err != nil {
 		return errBadRequest.WithDetail(err.Error())
 	}
-	if job.URLField == "" {
-		return errBadRequest.WithDetail("Missing field: url")
+	if job.CallbackURLField != "" && !repository.IsHTTPURL(job.CallbackURLField) {
+		return errBadRequest.WithDetail("Invalid callback_url: " + job.CallbackURLField)
+	}
+	if len(job.ConcurrencyKeyField) > jobqueue.MaxConcurrencyKeyLength {
+		return errBadRequest.WithDetail(fmt.Sprintf("concurrency_key is longer than %d bytes", jobqueue.MaxConcurrencyKeyLength))
+	}
+	if len(job.TenantField) > jobqueue.MaxTenantLength {
+		return errBadRequest.WithDetail(fmt.Sprintf("tenant is longer than %d bytes", jobqueue.MaxTenantLength))
+	}
+	if err := job.validateDebounce(); err != nil {
+		return errBadRequest.WithDetail(err.Error())
+	}
+	now := time.Now()
+	if err := job.decodeExpiration(now); err != nil {
+		return errBadRequest.WithDetail(err.Error())
+	}
+	if err := job.decodeSchedule(now); err != nil {
+		return errBadRequest.WithDetail(err.Error())
 	}
 	job.CategoryField = vars["category"]
+	job.readTraceContext(req.Header)
+	if err := app.validatePayload(&job); err != nil {
+		return err
+	}
 
 	r, err := app.Service.Push(&job)
+	if oe, ok := err.(*jobqueue.OverflowError); ok {
+		return errTooManyRequests.WithDetail(oe.Error()).WithRetryAfter(overflowRetryAfter())
+	}
+	if ie, ok := err.(*service.InvalidJobError); ok {
+		return errBadRequest.WithDetail(ie.Error())
+	}
 	if err != nil {
 		return err
 	}
+	if r.Job != nil {
+		job.applyDefaults(r.Job)
+	}
 
 	result := PushResult{r.ID, r.QueueName, job}
 
@@ -52,10 +91,30 @@ type IncomingJob struct {
 	PayloadField  json.RawMessage `json:"payload"`
 	payloadField  string
 
-	RunAfterField   uint `json:"run_after"`   // seconds
-	TimeoutField    uint `json:"timeout"`     // seconds
-	RetryDelayField uint `json:"retry_delay"` // seconds
-	MaxRetriesField uint `json:"max_retries"`
+	RunAfterField   uint  `json:"run_after"`   // seconds
+	TimeoutField    *uint `json:"timeout"`     // seconds
+	RetryDelayField *uint `json:"retry_delay"` // seconds
+	MaxRetriesField *uint `json:"max_retries"`
+
+	RunAtField json.RawMessage `json:"run_at,omitempty"` // RFC 3339 or epoch milliseconds
+	runAt      uint64          // milliseconds
+
+	CallbackURLField    string `json:"callback_url,omitempty"`
+	RoutingKeyField     string `json:"routing_key,omitempty"`
+	ConcurrencyKeyField string `json:"concurrency_key,omitempty"`
+	TenantField         string `json:"tenant,omitempty"`
+
+	DebounceKeyField    string `json:"debounce_key,omitempty"`
+	DebounceWindowField uint   `json:"debounce_window,omitempty"` // seconds
+	DebouncePolicyField string `json:"debounce_policy,omitempty"`
+
+	ExpireAtField *time.Time `json:"expire_at,omitempty"`
+	TTLField      uint       `json:"ttl,omitempty"` // seconds
+	expireAt      uint64     // milliseconds
+
+	traceParent string
+	traceState  string
+	requestID   string
 }
 
 // PushResult describes a job pushed to a queue.
@@ -110,20 +169,254 @@ func (job *IncomingJob) Payload() string {
 
 // NextDelay returns the delay for a next try of the job.
 func (job *IncomingJob) NextDelay() uint64 {
-	return uint64(job.RunAfterField * 1000)
+	delay := job.RunAfterField
+	if job.DebounceKeyField != "" && job.DebounceWindowField > delay {
+		delay = job.DebounceWindowField
+	}
+	return uint64(delay * 1000)
+}
+
+// RunAt returns the time in milliseconds when the job is scheduled or
+// 0 if it is not scheduled at an absolute time.
+func (job *IncomingJob) RunAt() uint64 {
+	return job.runAt
+}
+
+// RoutingKey returns the key to choose a target queue of the job.
+func (job *IncomingJob) RoutingKey() string {
+	return job.RoutingKeyField
+}
+
+// ConcurrencyKey returns the key to exclude other jobs from running
+// at the same time.
+func (job *IncomingJob) ConcurrencyKey() string {
+	return job.ConcurrencyKeyField
+}
+
+// Tenant returns the tenant of the job to share a queue fairly by.
+func (job *IncomingJob) Tenant() string {
+	return job.TenantField
+}
+
+// Debounce returns how to coalesce the job with a waiting job or nil
+// if the job is not coalesced.
+func (job *IncomingJob) Debounce() *jobqueue.Debounce {
+	if job.DebounceKeyField == "" {
+		return nil
+	}
+	policy := job.DebouncePolicyField
+	if policy == "" {
+		policy = jobqueue.DebounceLast
+	}
+	return &jobqueue.Debounce{
+		Key:    job.DebounceKeyField,
+		Window: job.DebounceWindowField,
+		Policy: policy,
+	}
+}
+
+func (job *IncomingJob) validateDebounce() error {
+	if job.DebounceKeyField == "" {
+		if job.DebounceWindowField != 0 || job.DebouncePolicyField != "" {
+			return errors.New("debounce_window and debounce_policy require debounce_key")
+		}
+		return nil
+	}
+	if len(job.DebounceKeyField) > jobqueue.MaxDebounceKeyLength {
+		return fmt.Errorf("debounce_key is longer than %d bytes", jobqueue.MaxDebounceKeyLength)
+	}
+	switch job.DebouncePolicyField {
+	case "", jobqueue.DebounceLast, jobqueue.DebounceFirst:
+	default:
+		return errors.New("Invalid debounce_policy: " + job.DebouncePolicyField)
+	}
+	return nil
 }
 
 // RetryCount returns the max retries of the job.
 func (job *IncomingJob) RetryCount() uint {
-	return job.MaxRetriesField
+	return uintValue(job.MaxRetriesField)
 }
 
 // RetryDelay returns the delay for retries of the job.
 func (job *IncomingJob) RetryDelay() uint {
-	return job.RetryDelayField
+	return uintValue(job.RetryDelayField)
 }
 
 // Timeout returns the timeout of the job.
 func (job *IncomingJob) Timeout() uint {
-	return job.TimeoutField
+	return uintValue(job.TimeoutField)
+}
+
+// HasRetryCount tells if the job gives max_retries.
+func (job *IncomingJob) HasRetryCount() bool {
+	return job.MaxRetriesField != nil
+}
+
+// HasRetryDelay tells if the job gives retry_delay.
+func (job *IncomingJob) HasRetryDelay() bool {
+	return job.RetryDelayField != nil
+}
+
+// HasTimeout tells if the job gives timeout.
+func (job *IncomingJob) HasTimeout() bool {
+	return job.TimeoutField != nil
+}
+
+func uintValue(p *uint) uint {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// Metadata returns optional attributes of the job.
+func (job *IncomingJob) Metadata() *jobqueue.Metadata {
+	if job.CallbackURLField == "" && job.traceParent == "" && job.requestID == "" && job.expireAt == 0 {
+		return nil
+	}
+	return &jobqueue.Metadata{
+		CallbackURL: job.CallbackURLField,
+		TraceParent: job.traceParent,
+		TraceState:  job.traceState,
+		RequestID:   job.requestID,
+		ExpireAt:    job.expireAt,
+	}
+}
+
+// applyDefaults copies the fields which the job omitted from the job
+// pushed with the defaults of its routing.
+func (job *IncomingJob) applyDefaults(pushed jobqueue.IncomingJob) {
+	job.URLField = pushed.URL()
+	timeout, retryCount, retryDelay := pushed.Timeout(), pushed.RetryCount(), pushed.RetryDelay()
+	job.TimeoutField = &timeout
+	job.MaxRetriesField = &retryCount
+	job.RetryDelayField = &retryDelay
+	if m := jobqueue.MetadataOf(pushed); m != nil && job.CallbackURLField == "" {
+		job.CallbackURLField = m.CallbackURL
+	}
+}
+
+// decodeExpiration decides the expiration time of the job from either
+// ExpireAtField or TTLField.
+func (job *IncomingJob) decodeExpiration(now time.Time) error {
+	if job.ExpireAtField != nil && job.TTLField != 0 {
+		return errors.New("Cannot specify both expire_at and ttl")
+	}
+
+	var expireAt time.Time
+	switch {
+	case job.ExpireAtField != nil:
+		expireAt = *job.ExpireAtField
+		if !expireAt.After(now) {
+			return errors.New("expire_at is in the past")
+		}
+	case job.TTLField != 0:
+		expireAt = now.Add(time.Duration(job.TTLField) * time.Second)
+	default:
+		return nil
+	}
+
+	job.expireAt = uint64(expireAt.UnixNano() / int64(time.Millisecond))
+	return nil
+}
+
+// decodeSchedule decides the absolute time to run the job from
+// RunAtField, which is either an RFC 3339 string or a number of epoch
+// milliseconds.
+func (job *IncomingJob) decodeSchedule(now time.Time) error {
+	if len(job.RunAtField) == 0 || string(job.RunAtField) == "null" {
+		return nil
+	}
+	if job.RunAfterField != 0 {
+		return errors.New("Cannot specify both run_at and run_after")
+	}
+	if job.DebounceWindowField != 0 {
+		return errors.New("Cannot specify both run_at and debounce_window")
+	}
+
+	var runAt time.Time
+	if job.RunAtField[0] == '"' {
+		if err := json.Unmarshal(job.RunAtField, &runAt); err != nil {
+			return errors.New("Invalid run_at: " + err.Error())
+		}
+		if runAt.Before(time.Unix(0, 0)) {
+			return errors.New("run_at is before 1970")
+		}
+	} else {
+		ms, err := strconv.ParseUint(string(job.RunAtField), 10, 64)
+		if err != nil {
+			return errors.New("Invalid run_at: " + string(job.RunAtField))
+		}
+		runAt = millisecondsToTime(ms)
+	}
+
+	if horizon := runAtMaxHorizon(); horizon > 0 && runAt.After(now.Add(horizon)) {
+		return errors.New("run_at is too far in the future")
+	}
+
+	job.runAt = uint64(runAt.UnixNano() / int64(time.Millisecond))
+	if job.expireAt != 0 && job.runAt >= job.expireAt {
+		return errors.New("run_at is after the expiration of the job")
+	}
+	return nil
+}
+
+// parseTimestamp parses either an RFC 3339 string or a number of epoch
+// milliseconds.
+func parseTimestamp(s string) (time.Time, error) {
+	if ms, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return millisecondsToTime(ms), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func millisecondsToTime(ms uint64) time.Time {
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+}
+
+func runAtMaxHorizon() time.Duration {
+	n, err := strconv.ParseUint(config.Get("run_at_max_horizon"), 10, 32)
+	if err != nil {
+		n, _ = strconv.ParseUint(config.GetDefault("run_at_max_horizon"), 10, 32)
+	}
+	return time.Duration(n) * time.Second
+}
+
+const (
+	maxTraceStateLength = 512
+	maxRequestIDLength  = 256
+)
+
+var traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// readTraceContext takes W3C Trace Context and a request ID from the
+// headers of a push request.  Malformed values are ignored as the
+// specification requires.
+func (job *IncomingJob) readTraceContext(header http.Header) {
+	if tp := header.Get("traceparent"); isTraceParent(tp) {
+		job.traceParent = tp
+		if ts := strings.Join(header.Values("tracestate"), ","); len(ts) <= maxTraceStateLength {
+			job.traceState = ts
+		}
+	}
+	if id := header.Get("X-Request-Id"); len(id) <= maxRequestIDLength {
+		job.requestID = id
+	}
+}
+
+func isTraceParent(s string) bool {
+	if !traceParentPattern.MatchString(s) || strings.HasPrefix(s, "ff-") {
+		return false
+	}
+	fields := strings.Split(s, "-")
+	return strings.Trim(fields[1], "0") != "" && strings.Trim(fields[2], "0") != ""
+}
+
+func overflowRetryAfter() uint {
+	n, err := strconv.ParseUint(config.Get("overflow_retry_after"), 10, 32)
+	if err != nil {
+		n, _ = strconv.ParseUint(config.GetDefault("overflow_retry_after"), 10, 32)
+	}
+	return uint(n)
 }
@@ -6,6 +6,8 @@ import (
 	"net/http"
 
 	"github.com/coosir/middleman/config"
+
+	stats "github.com/fukata/golang-stats-api-handler"
 )
 
 func (app *Application) serveVersion(w http.ResponseWriter, req *http.Request) error {
@@ -13,12 +15,18 @@ func (app *Application) serveVersion(w http.ResponseWriter, req *http.Request) e
 	return nil
 }
 
+const redacted = "<redacted>"
+
 func (app *Application) serveSettings(w http.ResponseWriter, req *http.Request) error {
 	keys := config.Keys()
 	settings := make(map[string]string)
 
 	for _, k := range keys {
-		settings[k] = config.Get(k)
+		v := config.Get(k)
+		if v != "" && config.IsSecret(k) {
+			v = redacted
+		}
+		settings[k] = v
 	}
 
 	j, err := json.Marshal(settings)
@@ -29,3 +37,8 @@ func (app *Application) serveSettings(w http.ResponseWriter, req *http.Request)
 	writeJSON(w, j)
 	return nil
 }
+
+func serveGoStats(w http.ResponseWriter, req *http.Request) error {
+	stats.Handler(w, req)
+	return nil
+}
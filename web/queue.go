@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/coosir/middleman/dispatcher"
 	"github.com/coosir/middleman/jobqueue"
@@ -161,8 +162,26 @@ func (app *Application) serveQueueWaiting(w http.ResponseWriter, req *http.Reque
 }
 
 func (app *Application) serveQueueDeferred(w http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+
+	var from, to time.Time
+	if s := query.Get("from"); s != "" {
+		t, err := parseTimestamp(s)
+		if err != nil {
+			return errBadRequest.WithDetail("Invalid from: " + s)
+		}
+		from = t
+	}
+	if s := query.Get("to"); s != "" {
+		t, err := parseTimestamp(s)
+		if err != nil {
+			return errBadRequest.WithDetail("Invalid to: " + s)
+		}
+		to = t
+	}
+
 	return app.serveQueueJobs(func(i jobqueue.Inspector, l uint, c string, o jobqueue.SortOrder) (*jobqueue.InspectedJobs, error) {
-		return i.FindAllDeferred(l, c, o)
+		return i.FindAllDeferredBetween(from, to, l, c, o)
 	}, w, req)
 }
 
@@ -267,7 +286,7 @@ func (app *Application) serveQueueJob(w http.ResponseWriter, req *http.Request)
 
 	job, err := inspector.Find(uint64(id))
 	if err == sql.ErrNoRows {
-		return errNotFound
+		return serveQueueFinishedJob(q, uint64(id), w, req)
 	}
 	if err != nil {
 		return err
@@ -288,6 +307,67 @@ func (app *Application) serveQueueJob(w http.ResponseWriter, req *http.Request)
 	return nil
 }
 
+func serveQueueFinishedJob(q jobqueue.JobQueue, id uint64, w http.ResponseWriter, req *http.Request) error {
+	history, ok := q.History()
+	if !ok {
+		return errNotFound
+	}
+
+	job, err := history.Find(id)
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if req.Method == "DELETE" {
+		if err := history.Delete(id); err != nil {
+			return err
+		}
+	}
+
+	j, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, j)
+
+	return nil
+}
+
+func (app *Application) serveQueueJobAttempts(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errBadRequest
+	}
+
+	q, ok := app.Service.GetJobQueue(vars["queue"])
+	if !ok {
+		return errNotFound
+	}
+
+	attemptLog, ok := q.AttemptLog()
+	if !ok {
+		return errNotImplemented
+	}
+
+	attempts, err := attemptLog.FindAll(uint64(id))
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(attempts)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, j)
+
+	return nil
+}
+
 func (app *Application) serveQueueFailedJob(w http.ResponseWriter, req *http.Request) error {
 	vars := mux.Vars(req)
 
@@ -36,28 +36,41 @@ func (app *Application) serveRouting(w http.ResponseWriter, req *http.Request) e
 			return errBadRequest.WithDetail(err.Error())
 		}
 		definition.JobCategory = jobCategory
+		if definition.Schema == nil {
+			// The schema is managed by /routing/{category}/schema.
+			if routing := app.RoutingRepository.FindByJobCategory(jobCategory); routing != nil && routing.JobCategory == jobCategory {
+				definition.Schema = routing.Schema
+			}
+		}
+		if err := repository.NormalizeRouting(&definition); err != nil {
+			return errBadRequest.WithDetail(err.Error())
+		}
+		if definition.CallbackURL != "" && !repository.IsHTTPURL(definition.CallbackURL) {
+			return errBadRequest.WithDetail("Invalid callback_url: " + definition.CallbackURL)
+		}
 
-		if _, err := app.RoutingRepository.Add(jobCategory, definition.QueueName); err != nil {
+		if _, err := app.RoutingRepository.Add(&definition); err != nil {
 			if _, ok := err.(*repository.QueueNotFoundError); ok {
 				return errNotFound.WithDetail(err.Error())
 			}
 			return err
 		}
+		app.schemas.evict(jobCategory)
 	} else {
-		qn := app.RoutingRepository.FindQueueNameByJobCategory(jobCategory)
-		if qn == "" {
+		routing := app.RoutingRepository.FindByJobCategory(jobCategory)
+		if routing == nil {
 			return errNotFound
 		}
-
-		definition = model.Routing{
-			JobCategory: jobCategory,
-			QueueName:   qn,
-		}
+		definition = *routing
 
 		if req.Method == "DELETE" {
+			if routing.JobCategory != jobCategory { // matched a pattern
+				return errNotFound
+			}
 			if err := app.RoutingRepository.DeleteByJobCategory(jobCategory); err != nil {
 				return err
 			}
+			app.schemas.evict(jobCategory)
 		}
 	}
 
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/coosir/middleman/jsonschema"
+	"github.com/coosir/middleman/model"
+	"github.com/coosir/middleman/repository"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) serveRoutingSchema(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	jobCategory := vars["category"]
+
+	routing := app.RoutingRepository.FindByJobCategory(jobCategory)
+	if routing == nil || routing.JobCategory != jobCategory { // matched a pattern
+		return errNotFound
+	}
+	schema := routing.Schema
+
+	switch req.Method {
+	case "PUT":
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		if _, err := jsonschema.Compile(body); err != nil {
+			return errBadRequest.WithDetail("Invalid schema: " + err.Error())
+		}
+		schema = json.RawMessage(body)
+		if err := app.replaceSchema(routing, schema); err != nil {
+			return err
+		}
+	case "DELETE":
+		if len(schema) == 0 {
+			return errNotFound
+		}
+		if err := app.replaceSchema(routing, nil); err != nil {
+			return err
+		}
+	default:
+		if len(schema) == 0 {
+			return errNotFound
+		}
+	}
+
+	writeJSON(w, schema)
+	return nil
+}
+
+func (app *Application) replaceSchema(routing *model.Routing, schema json.RawMessage) error {
+	definition := *routing
+	definition.Schema = schema
+	if _, err := app.RoutingRepository.Add(&definition); err != nil {
+		if _, ok := err.(*repository.QueueNotFoundError); ok {
+			return errNotFound.WithDetail(err.Error())
+		}
+		return err
+	}
+	app.schemas.evict(routing.JobCategory)
+	return nil
+}
+
+// validatePayload returns a client error if the payload of a job does
+// not conform to the schema of the routing of its category.
+func (app *Application) validatePayload(job *IncomingJob) error {
+	routing := app.RoutingRepository.FindByJobCategory(job.CategoryField)
+	if routing == nil || len(routing.Schema) == 0 {
+		return nil
+	}
+	schema, err := app.schemas.get(routing)
+	if err != nil {
+		return err
+	}
+
+	// A payload sent as a JSON string is validated by its content.
+	payload := []byte(job.Payload())
+	if len(payload) == 0 {
+		payload = []byte("null")
+	}
+	if err := schema.Validate(payload); err != nil {
+		return errBadRequest.WithDetail("Payload does not conform to the schema: " + err.Error())
+	}
+	return nil
+}
+
+// schemaCache holds compiled schemas of routings.
+type schemaCache struct {
+	sync.Mutex
+	schemas map[string]*compiledSchema // by job categories of routings
+}
+
+type compiledSchema struct {
+	source string
+	schema *jsonschema.Schema
+}
+
+func (c *schemaCache) get(routing *model.Routing) (*jsonschema.Schema, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if cs, ok := c.schemas[routing.JobCategory]; ok && cs.source == string(routing.Schema) {
+		return cs.schema, nil
+	}
+	schema, err := jsonschema.Compile(routing.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if c.schemas == nil {
+		c.schemas = make(map[string]*compiledSchema)
+	}
+	c.schemas[routing.JobCategory] = &compiledSchema{string(routing.Schema), schema}
+	return schema, nil
+}
+
+// evict drops the compiled schema of a routing which is deleted or
+// replaced.
+func (c *schemaCache) evict(category string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.schemas, category)
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
@@ -21,7 +22,7 @@ import (
 
 func graceful(server *http.Server, shutdownTimeout time.Duration) (time.Duration, error) {
 	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
 
 	sig := <-sigc
 	log.Info().Msgf(
@@ -51,6 +52,9 @@ type server struct {
 	addrs       []net.Addr
 	makeHandler func(h http.Handler) http.Handler
 	mux         *mux.Router
+	auth        *authenticator
+	certificate *certificate
+	shutdown    chan struct{}
 }
 
 func newServer(out io.Writer) *server {
@@ -74,13 +78,22 @@ func newServer(out io.Writer) *server {
 		makeHandler: func(h http.Handler) http.Handler {
 			return hlog.NewHandler(logger)(accessLog(remoteAddr(ua(h))))
 		},
-		mux: mux.NewRouter(),
+		mux:      mux.NewRouter(),
+		auth:     newAuthenticator(),
+		shutdown: make(chan struct{}),
 	}
 	return s
 }
 
 func (s *server) start() (*http.Server, error) {
 	server := &http.Server{Handler: s.mux}
+	// Streaming responses never end by themselves
+	server.RegisterOnShutdown(func() { close(s.shutdown) })
+
+	cert, err := newCertificate()
+	if err != nil {
+		return nil, err
+	}
 
 	listeners, err := serverstarter.ListenAll()
 	if err == serverstarter.ErrNoListeningTarget {
@@ -98,6 +111,15 @@ func (s *server) start() (*http.Server, error) {
 		log.Info().Msg("Starting a server under start_server ...")
 	}
 
+	if cert != nil {
+		log.Info().Msg("Serving HTTPS ...")
+		tlsConfig := cert.tlsConfig()
+		for i, ln := range listeners {
+			listeners[i] = tls.NewListener(ln, tlsConfig)
+		}
+	}
+	s.certificate = cert
+
 	addrs := make([]net.Addr, 0)
 	for _, ln := range listeners {
 		ln := ln
@@ -120,6 +142,9 @@ func (s *server) startGracefully(shutdownTimeout time.Duration) (time.Duration,
 	if err != nil {
 		return 0, err
 	}
+	if s.certificate != nil {
+		s.certificate.watch(syscall.SIGUSR1)
+	}
 
 	return graceful(server, shutdownTimeout)
 }
@@ -127,3 +152,26 @@ func (s *server) startGracefully(shutdownTimeout time.Duration) (time.Duration,
 func (s *server) handle(pattern string, h func(http.ResponseWriter, *http.Request) error) {
 	s.mux.Handle(pattern, s.makeHandler(handler(h)))
 }
+
+// handleWith registers a handler which requires the client to be
+// authorized by p.
+func (s *server) handleWith(pattern string, p permission, h func(http.ResponseWriter, *http.Request) error) {
+	s.handle(pattern, func(w http.ResponseWriter, req *http.Request) error {
+		if err := s.auth.authorize(req, p); err != nil {
+			return err
+		}
+		return h(w, req)
+	})
+}
+
+// handleStream registers a handler which requires the client to be
+// authorized by p and writes a streaming response.  The handler should
+// return when the server is shutting down.
+func (s *server) handleStream(pattern string, p permission, h func(http.ResponseWriter, *http.Request) error) {
+	s.mux.Handle(pattern, s.makeHandler(streamHandler(func(w http.ResponseWriter, req *http.Request) error {
+		if err := s.auth.authorize(req, p); err != nil {
+			return err
+		}
+		return h(w, req)
+	})))
+}
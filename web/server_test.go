@@ -1,16 +1,27 @@
 package web
 
 import (
+	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/coosir/middleman/config"
+	"github.com/coosir/middleman/jobqueue/event"
 
 	serverstarter "github.com/lestrrat-go/server-starter/listener"
 )
@@ -332,6 +343,236 @@ func TestGraceful(t *testing.T) {
 	})
 }
 
+func TestAuthorization(t *testing.T) {
+	tokens := "p:producer:ptoken, o:operator:otoken, a:admin:atoken"
+	config.Locally("api_tokens", tokens, func() {
+		config.Locally("bind", "127.0.0.1:0", func() {
+			s := newServer(os.Stdout)
+			s.handle("/public", serveTest)
+			s.handleWith("/producer", requireRole(roleProducer), serveTest)
+			s.handleWith("/definition", requireRoleToModify(roleOperator, roleAdmin), serveTest)
+
+			server, err := s.start()
+			if err != nil {
+				t.Error(err)
+			}
+			defer server.Close()
+
+			time.Sleep(1 * time.Second) // wait for up
+
+			request := func(method, path string, header http.Header) int {
+				req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", s.addrs[0].String(), path), nil)
+				if err != nil {
+					t.Error(err)
+				}
+				for k, v := range header {
+					req.Header[k] = v
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return 0
+				}
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			bearer := func(token string) http.Header {
+				return http.Header{"Authorization": []string{"Bearer " + token}}
+			}
+
+			cases := []struct {
+				method string
+				path   string
+				header http.Header
+				status int
+			}{
+				{"GET", "/public", nil, http.StatusOK},
+				{"GET", "/producer", nil, http.StatusUnauthorized},
+				{"GET", "/producer", bearer("unknown"), http.StatusUnauthorized},
+				{"GET", "/producer", bearer("ptoken"), http.StatusOK},
+				{"GET", "/producer", http.Header{"X-Api-Key": []string{"otoken"}}, http.StatusOK},
+				{"GET", "/definition", bearer("ptoken"), http.StatusForbidden},
+				{"GET", "/definition", bearer("otoken"), http.StatusOK},
+				{"PUT", "/definition", bearer("otoken"), http.StatusForbidden},
+				{"PUT", "/definition", bearer("atoken"), http.StatusOK},
+			}
+			for _, c := range cases {
+				if status := request(c.method, c.path, c.header); status != c.status {
+					t.Errorf("Wrong status for %s %s: %d", c.method, c.path, status)
+				}
+			}
+		})
+	})
+}
+
+func TestServeEvents(t *testing.T) {
+	config.Locally("bind", "127.0.0.1:0", func() {
+		app := &Application{}
+		s := newServer(os.Stdout)
+		s.handleStream("/events", requireRole(roleOperator), app.serveEvents(s.shutdown))
+
+		server, err := s.start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+
+		time.Sleep(1 * time.Second) // wait for up
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/events?queue=q1", s.addrs[0].String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Wrong content type: %s", ct)
+		}
+
+		for !event.Active() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		event.Publish(&event.Event{Type: event.TypePush, Queue: "q2", ID: 1})
+		event.Publish(&event.Event{Type: event.TypePush, Queue: "q1", ID: 2})
+
+		r := bufio.NewReader(resp.Body)
+		for _, expected := range []string{"event: push\n", `data: {"type":"push","time":0,"queue":"q1","category":"","id":2,"url":"","fail_count":0}` + "\n", "\n"} {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line != expected {
+				t.Errorf("Wrong line: %q != %q", line, expected)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			t.Errorf("Streams should end on shutdown: %s", err)
+		}
+		if event.Active() {
+			t.Error("Streams should unsubscribe on shutdown")
+		}
+	})
+}
+
+func TestServerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	config.Locally("tls_cert_file", certFile, func() {
+		config.Locally("tls_key_file", keyFile, func() {
+			config.Locally("bind", "127.0.0.1:0", func() {
+				s := newServer(os.Stdout)
+				s.handle("/test", serveTest)
+
+				server, err := s.start()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer server.Close()
+
+				time.Sleep(1 * time.Second) // wait for up
+
+				client := &http.Client{Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				}}
+				commonName := func() string {
+					resp, err := client.Get(fmt.Sprintf("https://%s/test", s.addrs[0].String()))
+					if err != nil {
+						t.Error(err)
+						return ""
+					}
+					defer resp.Body.Close()
+
+					body, err := ioutil.ReadAll(resp.Body)
+					if err != nil {
+						t.Error(err)
+					}
+					if string(body) != testContent {
+						t.Errorf("Wrong content: %s", string(body))
+					}
+					return resp.TLS.PeerCertificates[0].Subject.CommonName
+				}
+
+				if cn := commonName(); cn != "first" {
+					t.Errorf("Wrong certificate: %s", cn)
+				}
+
+				writeTestCertificate(t, certFile, keyFile, "second")
+				if err := s.certificate.reload(); err != nil {
+					t.Error(err)
+				}
+				client.CloseIdleConnections()
+
+				if cn := commonName(); cn != "second" {
+					t.Errorf("Certificate should be reloaded: %s", cn)
+				}
+
+				os.Remove(keyFile)
+				if err := s.certificate.reload(); err == nil {
+					t.Error("Reloading a missing key should fail")
+				}
+				client.CloseIdleConnections()
+
+				if cn := commonName(); cn != "second" {
+					t.Errorf("A failed reload should keep the certificate: %s", cn)
+				}
+			})
+		})
+	})
+
+	config.Locally("tls_cert_file", filepath.Join(dir, "missing.pem"), func() {
+		config.Locally("bind", "127.0.0.1:0", func() {
+			s := newServer(os.Stdout)
+			if server, err := s.start(); err == nil {
+				server.Close()
+				t.Error("It should fail starting without a valid certificate")
+			}
+		})
+	})
+}
+
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func serveTest(w http.ResponseWriter, req *http.Request) error {
 	fmt.Fprint(w, testContent)
 	return nil
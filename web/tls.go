@@ -0,0 +1,81 @@
+package web
+
+import (
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/coosir/middleman/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// certificate is a server certificate which can be reloaded from the
+// files without restarting the server.
+type certificate struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certificate) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+// watch reloads the certificate whenever sig is received.
+func (c *certificate) watch(sig os.Signal) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, sig)
+	go func() {
+		for {
+			s := <-sigC
+			log.Info().Msgf("Received signal %q; reload TLS certificate", s)
+			if err := c.reload(); err != nil {
+				log.Error().Msg(err.Error())
+			}
+		}
+	}()
+}
+
+func (c *certificate) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.get,
+	}
+}
+
+// newCertificate loads a server certificate specified by "tls_"
+// configuration values.  It returns nil if the server should not
+// serve HTTPS.
+func newCertificate() (*certificate, error) {
+	certFile := config.Get("tls_cert_file")
+	keyFile := config.Get("tls_key_file")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	return loadCertificate(certFile, keyFile)
+}